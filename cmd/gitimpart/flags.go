@@ -0,0 +1,544 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mumoshu/gitimpart"
+	"github.com/mumoshu/gitimpart/config"
+	"github.com/mumoshu/gitimpart/envvar"
+	"github.com/mumoshu/gitimpart/store"
+)
+
+// commonFlags are the flags shared by all the subcommands,
+// which are for rendering the jsonnet file and authenticating against the remote.
+type commonFlags struct {
+	files      []string
+	ghTokenEnv string
+	vars       map[string]string
+	extCode    map[string]string
+	tlaStr     map[string]string
+	tlaCode    map[string]string
+	jpaths     []string
+	repoDir    string
+	context    gitimpart.RunContext
+	schemas    gitimpart.SchemaConfig
+	configFile string
+	team       string
+}
+
+func (f *commonFlags) register(fs *flag.FlagSet) {
+	f.vars = make(map[string]string)
+	f.extCode = make(map[string]string)
+	f.tlaStr = make(map[string]string)
+	f.tlaCode = make(map[string]string)
+
+	fs.Func("file", "The configuration file for rendering and pushing files (default gitimpart.jsonnet). It can be a jsonnet, json, yaml, cue, or Go template (.tmpl) file, or a directory to be copied as is. "+
+		"It can also be `git::URL//PATH@REF` to render the file in the git repository, or an HTTP(S) URL optionally pinned with ?checksum=sha256:HEX. "+
+		"Can be specified multiple times to deep-merge the contents in order", func(v string) error {
+		f.files = append(f.files, v)
+		return nil
+	})
+	fs.StringVar(&f.ghTokenEnv, "github-token-env", "GITHUB_TOKEN", "The environment variable name that contains the GitHub token")
+	fs.Func("var", "The variables to pass to the jsonnet file. Variables are available via std.extVar(name)", func(v string) error {
+		fields := strings.Split(v, ",")
+		for _, kv := range fields {
+			kv := strings.Split(kv, "=")
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid format for -var: %s", v)
+			}
+			f.vars[kv[0]] = kv[1]
+		}
+		return nil
+	})
+	fs.Func("ext-str", "The string ext var in the form of `name=value`, available via std.extVar(name). Unlike -var, the value can contain commas and equal signs. Can be specified multiple times", func(v string) error {
+		return setKV(f.vars, "ext-str", v, false)
+	})
+	fs.Func("ext-str-file", "The string ext var in the form of `name=file`, whose value is read from the file. Can be specified multiple times", func(v string) error {
+		return setKV(f.vars, "ext-str-file", v, true)
+	})
+	fs.Func("ext-code", "The ext var in the form of `name=code`, whose value is the jsonnet code like [1, 2] or {a: 1}. Can be specified multiple times", func(v string) error {
+		return setKV(f.extCode, "ext-code", v, false)
+	})
+	fs.Func("ext-code-file", "The ext var in the form of `name=file`, whose value is the jsonnet code in the file. Can be specified multiple times", func(v string) error {
+		return setCodeFile(f.extCode, "ext-code-file", v)
+	})
+	fs.Func("tla-str", "The string top-level argument in the form of `name=value`, passed to the function the jsonnet file evaluates to. Can be specified multiple times", func(v string) error {
+		return setKV(f.tlaStr, "tla-str", v, false)
+	})
+	fs.Func("tla-str-file", "The string top-level argument in the form of `name=file`, whose value is read from the file. Can be specified multiple times", func(v string) error {
+		return setKV(f.tlaStr, "tla-str-file", v, true)
+	})
+	fs.Func("tla-code", "The top-level argument in the form of `name=code`, whose value is the jsonnet code like [1, 2] or {a: 1}. Can be specified multiple times", func(v string) error {
+		return setKV(f.tlaCode, "tla-code", v, false)
+	})
+	fs.Func("tla-code-file", "The top-level argument in the form of `name=file`, whose value is the jsonnet code in the file. Can be specified multiple times", func(v string) error {
+		return setCodeFile(f.tlaCode, "tla-code-file", v)
+	})
+	jpath := func(v string) error {
+		f.jpaths = append(f.jpaths, v)
+		return nil
+	}
+	fs.Func("J", "Shorthand for -jpath", jpath)
+	fs.Func("jpath", "The library search directory for the jsonnet imports. Can be specified multiple times, and the right-most one wins. The directories in $JSONNET_PATH and the vendor directory next to jsonnetfile.json are searched after them", jpath)
+	fs.StringVar(&f.repoDir, "repo-dir", "", "The local checkout of the target repository to resolve gitimpart://path imports and std.native(\"repoFile\") against, when rendering without cloning")
+	fs.StringVar(&f.context.Repo, "context-repo", "", "Overrides the repository in the form of OWNER/NAME of the context available to the .template. jsonnet files via std.extVar(\"context\"). The context is detected from GitHub Actions, GitLab CI, or the git repository of the working directory by default")
+	fs.StringVar(&f.context.Ref, "context-ref", "", "Overrides the ref of the context, like refs/heads/main")
+	fs.StringVar(&f.context.SHA, "context-sha", "", "Overrides the commit SHA of the context")
+	fs.StringVar(&f.context.Actor, "context-actor", "", "Overrides the actor of the context")
+	fs.StringVar(&f.context.Event, "context-event", "", "Overrides the event name of the context, like push or pull_request")
+	fs.IntVar(&f.context.PullRequest, "context-pr", 0, "Overrides the pull request number of the context")
+	fs.StringVar(&f.context.RunURL, "context-run-url", "", "Overrides the CI run URL of the context")
+	fs.StringVar(&f.schemas.Dir, "schema-dir", "", "The directory containing the JSON Schemas of the Kubernetes objects, named like deployment-apps-v1.json, to validate the rendered files against")
+	fs.Func("schema", "The JSON Schema to validate the rendered files against, in the form of `glob=file`, like apps/**/values.yaml=values.schema.json. Can be specified multiple times, and the first matching one wins", func(v string) error {
+		glob, file, ok := strings.Cut(v, "=")
+		if !ok || glob == "" || file == "" {
+			return fmt.Errorf("invalid format for -schema: %s: it must be in the form of glob=file", v)
+		}
+		f.schemas.Rules = append(f.schemas.Rules, gitimpart.SchemaRule{Path: glob, Schema: file})
+		return nil
+	})
+	fs.BoolVar(&f.schemas.Strict, "schema-strict", false, "Fail the validation of the YAML and JSON files whose objects have no schema")
+	fs.StringVar(&f.configFile, "config", config.DefaultFile, "The configuration file containing the policy the changes are checked against, the encryption rules, and the redaction of the secrets in the output. It is ignored when it does not exist")
+	fs.StringVar(&f.team, "team", "", "The team making the changes, which selects the paths the policy allows")
+}
+
+// setKV sets the value of the `name=value` flag to the map, reading the value from the file when fromFile is true.
+// Unlike -var, only the first equal sign separates the name and the value.
+func setKV(m map[string]string, flagName, v string, fromFile bool) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid format for -%s: %s: it must be in the form of name=value", flagName, v)
+	}
+
+	if fromFile {
+		b, err := os.ReadFile(value)
+		if err != nil {
+			return fmt.Errorf("unable to read the file for -%s: %w", flagName, err)
+		}
+		value = string(b)
+	}
+
+	m[name] = value
+
+	return nil
+}
+
+// setCodeFile sets the jsonnet code that imports the file, as the jsonnet command does,
+// so that the imports within the file are resolved relative to the file.
+func setCodeFile(m map[string]string, flagName, v string) error {
+	name, file, ok := strings.Cut(v, "=")
+	if !ok || name == "" || file == "" {
+		return fmt.Errorf("invalid format for -%s: %s: it must be in the form of name=file", flagName, v)
+	}
+
+	if _, err := os.Stat(file); err != nil {
+		return fmt.Errorf("unable to read the file for -%s: %w", flagName, err)
+	}
+
+	m[name] = "import " + strconv.Quote(file)
+
+	return nil
+}
+
+// sources returns the -file values, defaulting to gitimpart.jsonnet.
+func (f *commonFlags) sources() []string {
+	if len(f.files) == 0 {
+		return []string{"gitimpart.jsonnet"}
+	}
+
+	return f.files
+}
+
+// source describes the -file values for the messages.
+func (f *commonFlags) source() string {
+	return strings.Join(f.sources(), ", ")
+}
+
+// render renders the files with the variables.
+func (f *commonFlags) render(tokenHosts ...string) (*gitimpart.Contents, error) {
+	paths, cleanup, err := f.fetch(tokenHosts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	r, err := gitimpart.RenderFiles(paths, f.loadOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s: %v", f.source(), err)
+	}
+
+	return r, nil
+}
+
+// fetch fetches the remote files into a temporary directory, and returns the local paths of all the files
+// and the function to remove the directory.
+// The GitHub token is sent only to github.com and the tokenHosts, like the host of the repository to push to.
+func (f *commonFlags) fetch(tokenHosts ...string) ([]string, func(), error) {
+	sources := f.sources()
+	cleanup := func() {}
+
+	var tmp string
+	for _, src := range sources {
+		if gitimpart.IsRemoteSource(src) {
+			d, err := os.MkdirTemp("", "gitimpart-sources-")
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to create temporary directory: %v", err)
+			}
+			tmp = d
+			cleanup = func() { os.RemoveAll(d) }
+			break
+		}
+	}
+
+	paths := make([]string, 0, len(sources))
+	for _, src := range sources {
+		p, err := gitimpart.FetchSource(src, tmp, gitimpart.FetchWithGitHubToken(os.Getenv(f.ghTokenEnv), tokenHosts...))
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("failed to fetch %s: %v", src, err)
+		}
+		paths = append(paths, p)
+	}
+
+	return paths, cleanup, nil
+}
+
+// loadOptions returns the options to render the jsonnet file with.
+func (f *commonFlags) loadOptions() []gitimpart.LoadOption {
+	var loadOpts []gitimpart.LoadOption
+
+	if len(f.vars) > 0 {
+		loadOpts = append(loadOpts, gitimpart.Vars(f.vars))
+	}
+
+	if len(f.extCode) > 0 {
+		loadOpts = append(loadOpts, gitimpart.ExtCode(f.extCode))
+	}
+
+	if len(f.tlaStr) > 0 {
+		loadOpts = append(loadOpts, gitimpart.TLAs(f.tlaStr))
+	}
+
+	if len(f.tlaCode) > 0 {
+		loadOpts = append(loadOpts, gitimpart.TLACode(f.tlaCode))
+	}
+
+	if len(f.jpaths) > 0 {
+		loadOpts = append(loadOpts, gitimpart.JPaths(f.jpaths...))
+	}
+
+	if f.repoDir != "" {
+		loadOpts = append(loadOpts, gitimpart.RepoDir(f.repoDir))
+	}
+
+	if f.context != (gitimpart.RunContext{}) {
+		loadOpts = append(loadOpts, gitimpart.Context(gitimpart.DetectRunContext(".").Override(f.context)))
+	}
+
+	return loadOpts
+}
+
+// schemaConfig returns the configuration of the schema validation, or nil when no schema is given.
+func (f *commonFlags) schemaConfig() *gitimpart.SchemaConfig {
+	if f.schemas.Dir == "" && len(f.schemas.Rules) == 0 {
+		return nil
+	}
+
+	return &f.schemas
+}
+
+// config returns the configuration file, which is empty when the file does not exist.
+func (f *commonFlags) config() (*config.Config, error) {
+	c, err := config.Load(f.configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the configuration: %v", err)
+	}
+
+	if r := c.Redaction; r != nil {
+		for _, name := range r.Env {
+			if v := os.Getenv(name); v != "" {
+				logRedactor.Secrets = append(logRedactor.Secrets, v)
+			}
+		}
+		// The invalid patterns are reported by Push.
+		for _, p := range r.Patterns {
+			if re, err := regexp.Compile(p); err == nil {
+				logRedactor.Patterns = append(logRedactor.Patterns, re)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// token returns the GitHub token, warning when it is not set.
+func (f *commonFlags) token() string {
+	ghtoken := os.Getenv(f.ghTokenEnv)
+	if ghtoken != "" {
+		logRedactor.Secrets = append(logRedactor.Secrets, ghtoken)
+	} else {
+		log.Printf("GITHUB_TOKEN is not set. Access to private repositories will be denied unless you configure other means of authentication")
+	}
+
+	return ghtoken
+}
+
+// pushFlags are the flags of the subcommands that clone the repository and commit the changes.
+type pushFlags struct {
+	repo                    string
+	branch                  string
+	dryRun                  bool
+	pullRequest             bool
+	cloneFirst              bool
+	depth                   int
+	filter                  string
+	sparse                  bool
+	sparseDirs              []string
+	cacheDir                string
+	gitBackend              string
+	signingKeyFile          string
+	signingKeyEnv           string
+	signingKeyPassphraseEnv string
+	commitSubject           string
+	commitBody              string
+	commitBodyFile          string
+	pullRequestBodyFile     string
+	authorName              string
+	authorEmail             string
+	committerName           string
+	committerEmail          string
+	coAuthors               []string
+	signOff                 bool
+	sourceCommit            string
+	branchTemplate          string
+	onBranchCollision       string
+	output                  string
+	diffFormat              string
+	color                   string
+	maxRetries              int
+	retryBackoff            time.Duration
+}
+
+func (f *pushFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.repo, "repo", "", "The repository to push the changes to. It should be in the format of `https://github.com/USER/REPO.git`")
+	fs.StringVar(&f.branch, "branch", "main", "The branch to push the changes to")
+	fs.BoolVar(&f.cloneFirst, "clone-first", false, "Clone the repository before rendering the jsonnet file, so that it can read the files on the branch via gitimpart://path imports and std.native(\"repoFile\"). The file is rendered again on top of the updated branch on retry")
+	fs.IntVar(&f.depth, "depth", 0, "Clone only the branch with the history truncated to the specified number of commits. 0 means the full history")
+	fs.StringVar(&f.filter, "filter", "", "The partial clone filter spec like `blob:none`. It is ignored unless -git-backend is exec, as go-git does not support partial clones")
+	fs.BoolVar(&f.sparse, "sparse", false, "Check out only the directories that the rendered files are written to")
+	fs.Func("sparse-dir", "The directory to check out. Can be specified multiple times. Implies -sparse", func(v string) error {
+		f.sparseDirs = append(f.sparseDirs, v)
+		return nil
+	})
+	fs.StringVar(&f.cacheDir, "cache-dir", os.Getenv(envvar.CacheDir), "The directory to cache the clone of the repository across runs. Defaults to $"+envvar.CacheDir+". If empty, the repository is cloned into a temporary directory")
+	fs.StringVar(&f.gitBackend, "git-backend", os.Getenv(envvar.GitBackend), "The git backend to use. Either `go-git` or `exec`, which shells out to the git binary. Defaults to $"+envvar.GitBackend+" or go-git")
+	fs.StringVar(&f.signingKeyFile, "signing-key-file", os.Getenv(envvar.SigningKeyFile), "The file that contains the armored OpenPGP private key or the SSH private key to sign the commit with. Defaults to $"+envvar.SigningKeyFile)
+	fs.StringVar(&f.signingKeyEnv, "signing-key-env", envvar.SigningKey, "The environment variable name that contains the key to sign the commit with. Takes precedence over -signing-key-file")
+	fs.StringVar(&f.signingKeyPassphraseEnv, "signing-key-passphrase-env", envvar.SigningKeyPassphrase, "The environment variable name that contains the passphrase of the signing key")
+	fs.StringVar(&f.commitSubject, "commit-subject", "", "The Go template of the commit message subject. It can refer to .Files, .Vars, .SourceCommit, .Repo, and .Branch")
+	fs.StringVar(&f.commitBody, "commit-body", "", "The Go template of the commit message body. It can refer to the same data as -commit-subject")
+	fs.StringVar(&f.commitBodyFile, "commit-body-file", "", "The file that contains the Go template of the commit message body. It takes precedence over -commit-body, and can be the same file as -pull-request-body-file")
+	fs.StringVar(&f.pullRequestBodyFile, "pull-request-body-file", "", "The file that contains the Go template of the pull request body. It can refer to .Dirs, .Diff, .SemanticDiff, .Provenance, and the same data as -commit-subject. Defaults to the built-in template")
+	fs.StringVar(&f.authorName, "author-name", "", "The name of the commit author. Defaults to $"+envvar.GitCommitAuthorUserName)
+	fs.StringVar(&f.authorEmail, "author-email", "", "The email of the commit author. Defaults to $"+envvar.GitCommitAuthorEmail)
+	fs.StringVar(&f.committerName, "committer-name", "", "The name of the committer. Defaults to $"+envvar.GitCommitCommitterUserName+" or the author")
+	fs.StringVar(&f.committerEmail, "committer-email", "", "The email of the committer. Defaults to $"+envvar.GitCommitCommitterEmail+" or the author")
+	fs.Func("co-author", "The co-author in the form of `Name <email>` to add as a Co-authored-by trailer. Can be specified multiple times", func(v string) error {
+		f.coAuthors = append(f.coAuthors, v)
+		return nil
+	})
+	fs.BoolVar(&f.signOff, "signoff", false, "Add the Signed-off-by trailer of the committer to the commit message")
+	fs.StringVar(&f.sourceCommit, "source-commit", "", "The commit of the source repository available to the commit message templates as .SourceCommit. Defaults to $GITHUB_SHA")
+	fs.StringVar(&f.branchTemplate, "branch-template", "", "The Go template of the feature branch name. It can refer to .Target, .ContentHash, .Timestamp, and .Vars. Defaults to `"+store.DefaultBranchTemplate+"`")
+	fs.StringVar(&f.onBranchCollision, "on-branch-collision", store.BranchCollisionSuffix, "What to do when the feature branch already exists on the remote. Either `suffix` to append -2, -3, and so on, or `reuse` to overwrite the branch")
+	fs.StringVar(&f.output, "output", outputText, "The output format of the result. Either `text` or `json`, which prints the commit, the ref, the pull request, and the changed files to stdout")
+	fs.StringVar(&f.diffFormat, "diff-format", store.DiffFormatPatch, "The format of the changes printed in dry-run mode. One of `patch`, `stat` like git diff --stat, `patch-with-stat`, `semantic` which prints the changed keys of YAML and JSON files like spec.replicas: 2 → 3, and `json` which prints the status, the old and new hashes, and the hunks of each file")
+	fs.StringVar(&f.color, "color", colorNever, "Whether to colorize the diff printed in dry-run mode. One of `auto` which colorizes only when stdout is a terminal, `always`, and `never`")
+	fs.IntVar(&f.maxRetries, "max-retries", 3, "The number of times to re-render and push again when the push is rejected because the branch was updated concurrently")
+	fs.DurationVar(&f.retryBackoff, "retry-backoff", time.Second, "The duration to wait before the first retry. It doubles on each subsequent retry")
+}
+
+func (f *pushFlags) registerDryRun(fs *flag.FlagSet) {
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Print the changes that would be made without actually making them")
+}
+
+func (f *pushFlags) registerPullRequest(fs *flag.FlagSet) {
+	fs.BoolVar(&f.pullRequest, "pull-request", false, "Send a pull request to the branch after pushing the changes, instead of pushing directly to the branch")
+}
+
+// options returns the options to push the changes with the flags and the vars.
+func (f *pushFlags) options(common *commonFlags) ([]gitimpart.PushOptions, error) {
+	if f.output != outputText && f.output != outputJSON {
+		return nil, fmt.Errorf("invalid -output %q: it must be either %q or %q", f.output, outputText, outputJSON)
+	}
+
+	switch f.diffFormat {
+	case store.DiffFormatPatch, store.DiffFormatStat, store.DiffFormatPatchWithStat, store.DiffFormatSemantic, store.DiffFormatJSON:
+	default:
+		return nil, fmt.Errorf("invalid -diff-format %q: it must be one of %q, %q, %q, %q, and %q", f.diffFormat, store.DiffFormatPatch, store.DiffFormatStat, store.DiffFormatPatchWithStat, store.DiffFormatSemantic, store.DiffFormatJSON)
+	}
+
+	color, err := useColor(f.color)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []gitimpart.PushOptions{
+		gitimpart.WithGitHubToken(common.token()),
+		gitimpart.WithRetries(f.maxRetries, f.retryBackoff),
+	}
+
+	if f.dryRun {
+		opts = append(opts, gitimpart.WithDryRun(), gitimpart.WithDiffFormat(f.diffFormat))
+
+		if color {
+			opts = append(opts, gitimpart.WithDiffColor())
+		}
+
+		// Keep stdout a single JSON document. The result contains the diff anyway.
+		if f.output == outputJSON {
+			opts = append(opts, gitimpart.WithDryRunOutput(os.Stderr))
+		}
+	}
+
+	if f.cacheDir != "" {
+		opts = append(opts, gitimpart.WithCacheDir(f.cacheDir))
+	}
+
+	if f.gitBackend != "" {
+		opts = append(opts, gitimpart.WithGitBackend(f.gitBackend))
+	}
+
+	signingKey := []byte(os.Getenv(f.signingKeyEnv))
+	if len(signingKey) == 0 && f.signingKeyFile != "" {
+		signingKey, err = os.ReadFile(f.signingKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the signing key: %v", err)
+		}
+	}
+
+	if len(signingKey) > 0 {
+		opts = append(opts, gitimpart.WithSigningKey(signingKey, os.Getenv(f.signingKeyPassphraseEnv)))
+	}
+
+	commitBody := f.commitBody
+	if f.commitBodyFile != "" {
+		b, err := os.ReadFile(f.commitBodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the commit body template: %v", err)
+		}
+		commitBody = string(b)
+	}
+
+	if f.pullRequestBodyFile != "" {
+		b, err := os.ReadFile(f.pullRequestBodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pull request body template: %v", err)
+		}
+		opts = append(opts, gitimpart.WithPullRequestBody(string(b)))
+	}
+
+	opts = append(opts,
+		gitimpart.WithCommitMessage(f.commitSubject, commitBody),
+		gitimpart.WithTemplateVars(common.vars),
+		gitimpart.WithSourceFile(common.source()),
+	)
+
+	if f.authorName != "" || f.authorEmail != "" {
+		opts = append(opts, gitimpart.WithAuthor(f.authorName, f.authorEmail))
+	}
+
+	if f.committerName != "" || f.committerEmail != "" {
+		opts = append(opts, gitimpart.WithCommitter(f.committerName, f.committerEmail))
+	}
+
+	if len(f.coAuthors) > 0 {
+		opts = append(opts, gitimpart.WithCoAuthors(f.coAuthors...))
+	}
+
+	if f.signOff {
+		opts = append(opts, gitimpart.WithSignOff())
+	}
+
+	if f.sourceCommit != "" {
+		opts = append(opts, gitimpart.WithSourceCommit(f.sourceCommit))
+	}
+
+	opts = append(opts,
+		gitimpart.WithBranchTemplate(f.branchTemplate),
+		gitimpart.WithBranchCollision(f.onBranchCollision),
+	)
+
+	if f.depth > 0 {
+		opts = append(opts, gitimpart.WithShallowClone(f.depth))
+	}
+
+	if f.filter != "" {
+		opts = append(opts, gitimpart.WithPartialClone(f.filter))
+	}
+
+	if f.sparse || len(f.sparseDirs) > 0 {
+		opts = append(opts, gitimpart.WithSparseCheckout(f.sparseDirs...))
+	}
+
+	if f.pullRequest {
+		opts = append(opts, gitimpart.WithPullRequest())
+	}
+
+	if sc := common.schemaConfig(); sc != nil {
+		opts = append(opts, gitimpart.WithSchemaValidation(*sc))
+	}
+
+	cfg, err := common.config()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Policy != nil {
+		opts = append(opts, gitimpart.WithPolicy(*cfg.Policy, common.team))
+	}
+
+	if cfg.Encryption != nil {
+		opts = append(opts, gitimpart.WithEncryption(*cfg.Encryption))
+	}
+
+	if cfg.Redaction != nil {
+		opts = append(opts, gitimpart.WithRedaction(*cfg.Redaction))
+	}
+
+	return opts, nil
+}
+
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// useColor tells whether to colorize the output according to the -color flag.
+func useColor(v string) (bool, error) {
+	switch v {
+	case colorAlways:
+		return true, nil
+	case colorNever, "":
+		return false, nil
+	case colorAuto:
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		fi, err := os.Stdout.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return fi.Mode()&os.ModeCharDevice != 0, nil
+	default:
+		return false, fmt.Errorf("invalid -color %q: it must be one of %q, %q, and %q", v, colorAuto, colorAlways, colorNever)
+	}
+}
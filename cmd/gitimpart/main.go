@@ -1,90 +1,393 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
-	"strings"
+	"path/filepath"
+	"regexp"
 
 	"github.com/mumoshu/gitimpart"
+	"github.com/mumoshu/gitimpart/envvar"
+	"github.com/mumoshu/gitimpart/store"
 )
 
+// exitCodeNoChanges is the exit code when there is nothing to push,
+// so that scheduled jobs can tell it from both successful pushes and failures.
+const exitCodeNoChanges = 2
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// commands are the subcommands of gitimpart.
+// Running gitimpart without a subcommand is the same as running `gitimpart push`,
+// which additionally accepts -pull-request for backward compatibility.
+var commands = map[string]func(args []string) error{
+	"render":   runRender,
+	"diff":     runDiff,
+	"push":     runPush,
+	"pr":       runPullRequest,
+	"validate": runValidate,
+}
+
+// logRedactor masks the secrets in the errors logged by main.
+// The GitHub token read from the environment variable given by -github-token-env,
+// and the environment variables configured by the redaction section of the configuration file are added to it.
+var logRedactor = &store.Redactor{
+	Secrets:  []string{os.Getenv(envvar.GitHubToken)},
+	Patterns: append([]*regexp.Regexp{}, store.CredentialPatterns...),
+}
+
 func main() {
 	if err := run(os.Args[1:]); err != nil {
-		log.Printf("error: %v", err)
+		if errors.Is(err, gitimpart.ErrNoChanges) {
+			log.Printf("%v", logRedactor.Redact(err.Error()))
+			os.Exit(exitCodeNoChanges)
+		}
+
+		log.Printf("error: %v", logRedactor.Redact(err.Error()))
 		os.Exit(1)
 	}
 }
 
 func run(args []string) error {
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			return cmd(args[1:])
+		}
+	}
+
+	var (
+		common commonFlags
+		push   pushFlags
+	)
+
 	flagset := flag.NewFlagSet("gitimpart", flag.ContinueOnError)
+	flagset.Usage = func() {
+		fmt.Fprintf(flagset.Output(), "Usage: gitimpart [render|diff|push|pr|validate] [flags]\n\n")
+		fmt.Fprintf(flagset.Output(), "Without a subcommand, gitimpart renders and pushes the files as `gitimpart push` does.\n\n")
+		flagset.PrintDefaults()
+	}
+	common.register(flagset)
+	push.register(flagset)
+	push.registerDryRun(flagset)
+	push.registerPullRequest(flagset)
+
+	if err := flagset.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse the flags: %v", err)
+	}
+
+	return pushContents(&common, &push)
+}
+
+// Formats of the render subcommand.
+const (
+	formatDir  = "dir"
+	formatTar  = "tar"
+	formatZip  = "zip"
+	formatList = "list"
+	formatCat  = "cat"
+)
+
+// runRender renders the files to a directory, an archive, or stdout.
+// It does not access the remote repository.
+func runRender(args []string) error {
+	var common commonFlags
+
+	flagset := flag.NewFlagSet("gitimpart render", flag.ContinueOnError)
+	common.register(flagset)
+	out := flagset.String("out", "", "The directory to write the rendered files to with -format=dir, or the archive file with -format=tar or zip. The archive is written to stdout when empty or `-`")
+	format := flagset.String("format", "", "The output format. One of `dir`, `tar`, `zip`, `list` which prints the paths of the files, and `cat` which prints the files with headers. Defaults to dir when -out is set, or cat otherwise")
+	kustomizeBin := flagset.String("kustomize-bin", "", "The kustomize binary to add the files in $kustomize to the kustomization.yaml files with. Defaults to `kustomize`")
+
+	if err := flagset.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse the flags: %v", err)
+	}
+
+	if *format == "" {
+		*format = formatCat
+		if *out != "" {
+			*format = formatDir
+		}
+	}
+
+	var dir string
+	switch *format {
+	case formatDir:
+		if *out == "" || *out == "-" {
+			return fmt.Errorf("-out is required for -format=%s", formatDir)
+		}
+		dir = *out
+	case formatTar, formatZip, formatList, formatCat:
+		tmp, err := os.MkdirTemp("", "gitimpart-render")
+		if err != nil {
+			return fmt.Errorf("failed to create a temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+		dir = tmp
+	default:
+		return fmt.Errorf("invalid -format %q: it must be one of %q, %q, %q, %q, and %q", *format, formatDir, formatTar, formatZip, formatList, formatCat)
+	}
 
-	vars := make(map[string]string)
-
-	file := flagset.String("file", "gitimpart.jsonnet", "The configuration file for rendering and pushing files")
-	ghTokenEnv := flagset.String("github-token-env", "GITHUB_TOKEN", "The environment variable name that contains the GitHub token")
-	repo := flagset.String("repo", "", "The repository to push the changes to. It should be in the format of `https://github.com/USER/REPO.git`")
-	branch := flagset.String("branch", "main", "The branch to push the changes to")
-	dryRun := flagset.Bool("dry-run", false, "Print the changes that would be made without actually making them")
-	pullRequest := flagset.Bool("pull-request", false, "Send a pull request to the branch after pushing the changes, instead of pushing directly to the branch")
-
-	flagset.Func("var", "The variables to pass to the jsonnet file. Variables are available via std.extVar(name)", func(v string) error {
-		fields := strings.Split(v, ",")
-		for _, kv := range fields {
-			kv := strings.Split(kv, "=")
-			if len(kv) != 2 {
-				return fmt.Errorf("invalid format for -var: %s", v)
+	r, err := common.render()
+	if err != nil {
+		return err
+	}
+
+	l, err := store.NewLocal(dir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := gitimpart.RenderLocal(*r, l, gitimpart.WithKustomizeBin(*kustomizeBin)); err != nil {
+		return fmt.Errorf("failed to write the files to %s: %v", dir, err)
+	}
+
+	switch *format {
+	case formatTar, formatZip:
+		w := io.Writer(os.Stdout)
+		if *out != "" && *out != "-" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %v", *out, err)
 			}
-			vars[kv[0]] = kv[1]
+			defer f.Close()
+			w = f
+		}
+
+		if *format == formatTar {
+			err = l.WriteTar(w)
+		} else {
+			err = l.WriteZip(w)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write the %s archive: %v", *format, err)
+		}
+	case formatList:
+		files, err := l.Files()
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			fmt.Println(f)
+		}
+	case formatCat:
+		return printFiles(os.Stdout, l)
+	}
+
+	return nil
+}
+
+// printFiles prints the files sorted by path, each preceded by a `==> path <==` header like tail does.
+func printFiles(w io.Writer, l *store.Local) error {
+	files, err := l.Files()
+	if err != nil {
+		return err
+	}
+
+	for i, name := range files {
+		b, err := os.ReadFile(filepath.Join(l.Dir(), filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+
+		if i > 0 {
+			fmt.Fprintln(w)
 		}
-		return nil
-	})
+
+		fmt.Fprintf(w, "==> %s <==\n", name)
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			fmt.Fprintln(w)
+		}
+	}
+
+	return nil
+}
+
+// runDiff clones the repository and prints the patch against the branch, without committing or pushing.
+func runDiff(args []string) error {
+	var (
+		common commonFlags
+		push   pushFlags
+	)
+
+	flagset := flag.NewFlagSet("gitimpart diff", flag.ContinueOnError)
+	common.register(flagset)
+	push.register(flagset)
+
+	if err := flagset.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse the flags: %v", err)
+	}
+
+	push.dryRun = true
+
+	return pushContents(&common, &push)
+}
+
+// runPush renders the files and pushes them to the branch.
+func runPush(args []string) error {
+	var (
+		common commonFlags
+		push   pushFlags
+	)
+
+	flagset := flag.NewFlagSet("gitimpart push", flag.ContinueOnError)
+	common.register(flagset)
+	push.register(flagset)
+	push.registerDryRun(flagset)
 
 	if err := flagset.Parse(args); err != nil {
 		return fmt.Errorf("failed to parse the flags: %v", err)
 	}
 
-	ghtoken := os.Getenv(*ghTokenEnv)
-	if ghtoken == "" {
-		log.Printf("GITHUB_TOKEN is not set. Access to private repositories will be denied unless you configure other means of authentication")
+	return pushContents(&common, &push)
+}
+
+// runPullRequest renders the files, pushes them to a feature branch, and sends a pull request to the branch.
+func runPullRequest(args []string) error {
+	var (
+		common commonFlags
+		push   pushFlags
+	)
+
+	flagset := flag.NewFlagSet("gitimpart pr", flag.ContinueOnError)
+	common.register(flagset)
+	push.register(flagset)
+	push.registerDryRun(flagset)
+
+	if err := flagset.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse the flags: %v", err)
 	}
 
-	var loadOpts []gitimpart.LoadOption
+	push.pullRequest = true
+
+	return pushContents(&common, &push)
+}
+
+// runValidate renders the files and checks them without accessing the network.
+func runValidate(args []string) error {
+	var common commonFlags
 
-	if len(vars) > 0 {
-		loadOpts = append(loadOpts, gitimpart.Vars(vars))
+	flagset := flag.NewFlagSet("gitimpart validate", flag.ContinueOnError)
+	common.register(flagset)
+
+	if err := flagset.Parse(args); err != nil {
+		return fmt.Errorf("failed to parse the flags: %v", err)
 	}
 
-	r, err := gitimpart.RenderFile(*file, loadOpts...)
+	r, err := common.render()
 	if err != nil {
-		return fmt.Errorf("failed to render file %s: %v", *file, err)
+		return err
 	}
 
-	opts := []gitimpart.PushOptions{
-		gitimpart.WithGitHubToken(ghtoken),
+	if err := r.Validate(); err != nil {
+		return fmt.Errorf("invalid contents rendered from %s:\n%v", common.source(), err)
 	}
 
-	if *dryRun {
-		opts = append(opts, gitimpart.WithDryRun())
+	if sc := common.schemaConfig(); sc != nil {
+		if err := r.ValidateSchemas(*sc); err != nil {
+			return fmt.Errorf("invalid contents rendered from %s:\n%v", common.source(), err)
+		}
 	}
 
-	if *pullRequest {
-		opts = append(opts, gitimpart.WithPullRequest())
+	cfg, err := common.config()
+	if err != nil {
+		return err
 	}
 
-	err = gitimpart.Push(
-		*r,
-		*repo,
-		*branch,
-		opts...,
-	)
+	if p := cfg.Policy; p != nil {
+		// Without the repository, every file counts as changed, and the pull request requirement is left to push.
+		changed := make(map[string][]byte, len(r.Files))
+		for name := range r.Files {
+			b, err := r.FileContent(name)
+			if err != nil {
+				return fmt.Errorf("failed to render %s: %v", name, err)
+			}
+			changed[name] = b
+		}
+
+		if err := gitimpart.CheckPolicy(*p, gitimpart.PolicyInput{Team: common.team, PullRequest: true, Changed: changed}); err != nil {
+			return fmt.Errorf("contents rendered from %s: %v", common.source(), err)
+		}
+	}
+
+	log.Printf("%s is valid: %d file(s)", common.source(), len(r.Files))
+
+	return nil
+}
+
+func pushContents(common *commonFlags, push *pushFlags) error {
+	opts, err := push.options(common)
 	if err != nil {
+		return err
+	}
+
+	// The sources may be in the repository to push to, which the token is sent to anyway.
+	var tokenHosts []string
+	if u, err := url.Parse(push.repo); err == nil && u.Host != "" {
+		tokenHosts = append(tokenHosts, u.Hostname())
+	}
+
+	var result *gitimpart.PushResult
+
+	if push.cloneFirst {
+		paths, cleanup, ferr := common.fetch(tokenHosts...)
+		if ferr != nil {
+			return ferr
+		}
+		defer cleanup()
+
+		result, err = gitimpart.PushFunc(
+			gitimpart.RenderFilesFunc(paths, common.loadOptions()...),
+			push.repo,
+			push.branch,
+			opts...,
+		)
+	} else {
+		var r *gitimpart.Contents
+		r, err = common.render(tokenHosts...)
+		if err != nil {
+			return err
+		}
+
+		result, err = gitimpart.Push(
+			*r,
+			push.repo,
+			push.branch,
+			opts...,
+		)
+	}
+	if result != nil && push.output == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return fmt.Errorf("failed to write the result: %v", err)
+		}
+	}
+
+	if errors.Is(err, gitimpart.ErrNoChanges) {
+		return fmt.Errorf("skipped pushing to %s/%s: %w", push.repo, push.branch, err)
+	} else if err != nil {
 		return fmt.Errorf("failed to push the changes: %v", err)
 	}
 
-	if *dryRun {
-		log.Printf("successfully pushed the changes to %s/%s", *repo, *branch)
+	if push.dryRun {
+		log.Printf("successfully pushed the changes to %s/%s", push.repo, push.branch)
+	} else if push.output == outputText {
+		log.Printf("successfully pushed commit %s to %s", result.Commit, result.Ref)
+		if pr := result.PullRequest; pr != nil {
+			log.Printf("pull request #%d: %s", pr.Number, pr.URL)
+		}
 	}
 
 	return nil
@@ -1,9 +1,23 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/cgi"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mumoshu/gitimpart"
+	"github.com/mumoshu/gitimpart/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -86,6 +100,219 @@ index 0000000000000000000000000000000000000000..71bc41cb110a98401c3f27d0341e6945
 	assert.Empty(t, readAll(t, stderr))
 }
 
+func TestCommand_Render(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, run([]string{
+		"render",
+		"-file", "testdata/test.jsonnet",
+		"-out", dir,
+	}))
+
+	b, err := os.ReadFile(filepath.Join(dir, "b.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "b: B\n", string(b))
+
+	b, err = os.ReadFile(filepath.Join(dir, "d", "e", "f.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "d/e/f", string(b))
+
+	l, err := store.NewLocal(dir)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, printFiles(&buf, l))
+	assert.Equal(t, `==> a.txt <==
+a
+
+==> b.json <==
+{"b":"B"}
+
+==> b.yaml <==
+b: B
+
+==> c.json <==
+{"c":"C"}
+
+==> c.yaml <==
+c: C
+
+==> d/e/f.txt <==
+d/e/f
+`, buf.String())
+}
+
+func TestCommand_RenderOutsidePath(t *testing.T) {
+	parent := t.TempDir()
+	src := filepath.Join(parent, "escape.jsonnet")
+	require.NoError(t, os.WriteFile(src, []byte(`{"$files": {"../escaped.txt": "x"}}`), 0644))
+
+	err := run([]string{
+		"render",
+		"-file", src,
+		"-out", filepath.Join(parent, "out"),
+	})
+	require.ErrorContains(t, err, `$files["../escaped.txt"]: path must not point outside of the repository`)
+	require.NoFileExists(t, filepath.Join(parent, "escaped.txt"))
+}
+
+func TestCommand_RenderArchive(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.tar")
+
+	require.NoError(t, run([]string{
+		"render",
+		"-file", "testdata/test.jsonnet",
+		"-format", "tar",
+		"-out", out,
+	}))
+
+	f, err := os.Open(out)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, h.Name)
+	}
+	assert.Equal(t, []string{"a.txt", "b.json", "b.yaml", "c.json", "c.yaml", "d/e/f.txt"}, names)
+
+	err = run([]string{"render", "-file", "testdata/test.jsonnet", "-format", "dir"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-out is required")
+}
+
+func TestCommand_RenderTLAs(t *testing.T) {
+	dir := t.TempDir()
+
+	labels := filepath.Join(dir, "labels.libsonnet")
+	require.NoError(t, os.WriteFile(labels, []byte(`{team: "platform"}`), 0644))
+
+	out := filepath.Join(dir, "out")
+
+	require.NoError(t, run([]string{
+		"render",
+		"-file", "testdata/tla.jsonnet",
+		"-tla-str", "env=prod",
+		"-tla-code", "replicas=3",
+		"-tla-code", `labels={app: "a,b=c"}`,
+		"-ext-code-file", "extraLabels=" + labels,
+		"-out", out,
+	}))
+
+	b, err := os.ReadFile(filepath.Join(out, "prod", "deployment.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, `metadata:
+  labels:
+    app: a,b=c
+    team: platform
+spec:
+  replicas: 3
+`, string(b))
+
+	err = run([]string{"validate", "-file", "testdata/tla.jsonnet", "-tla-code", "replicas"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "it must be in the form of name=value")
+}
+
+func TestCommand_RenderJPath(t *testing.T) {
+	dir := t.TempDir()
+
+	lib := filepath.Join(dir, "lib")
+	require.NoError(t, os.MkdirAll(lib, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(lib, "name.libsonnet"), []byte(`"lib"`), 0644))
+
+	main := filepath.Join(dir, "main.jsonnet")
+	require.NoError(t, os.WriteFile(main, []byte(`{"$files": {"name.txt": import "name.libsonnet"}}`), 0644))
+
+	out := filepath.Join(dir, "out")
+
+	require.NoError(t, run([]string{"render", "-file", main, "-J", lib, "-out", out}))
+
+	b, err := os.ReadFile(filepath.Join(out, "name.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "lib", string(b))
+}
+
+func TestCommand_RenderRepoDir(t *testing.T) {
+	dir := t.TempDir()
+
+	repoDir := filepath.Join(dir, "repo")
+	require.NoError(t, os.MkdirAll(repoDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "VERSION"), []byte("1"), 0644))
+
+	main := filepath.Join(dir, "main.jsonnet")
+	require.NoError(t, os.WriteFile(main, []byte(`{"$files": {"VERSION": std.toString(std.parseInt(importstr "gitimpart://VERSION") + 1)}}`), 0644))
+
+	out := filepath.Join(dir, "out")
+
+	require.NoError(t, run([]string{"render", "-file", main, "-repo-dir", repoDir, "-out", out}))
+
+	b, err := os.ReadFile(filepath.Join(out, "VERSION"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(b))
+}
+
+func TestCommand_Validate(t *testing.T) {
+	require.NoError(t, run([]string{"validate", "-file", "testdata/test.jsonnet"}))
+
+	f := filepath.Join(t.TempDir(), "invalid.jsonnet")
+	require.NoError(t, os.WriteFile(f, []byte(`{"$files": {"../a.txt": "a"}}`), 0644))
+
+	err := run([]string{"validate", "-file", f})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "path must not point outside of the repository")
+
+	f = filepath.Join(t.TempDir(), "deploy.jsonnet")
+	require.NoError(t, os.WriteFile(f, []byte(`{"$files": {"deploy.yaml": {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "app"}, "spec": {"replicas": "2"}}}}`), 0644))
+
+	err = run([]string{"validate", "-file", f, "-schema-dir", "../../testdata/schemas"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `$files["deploy.yaml"]: Deployment/app: /spec/replicas: expected integer, but got string`)
+
+	cfg := filepath.Join(t.TempDir(), "gitimpart.yaml")
+	require.NoError(t, os.WriteFile(cfg, []byte("policy:\n  teams:\n    app-a:\n      allowedPaths: [\"apps/a/**\"]\n"), 0644))
+
+	err = run([]string{"validate", "-file", f, "-config", cfg, "-team", "app-a"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `$files["deploy.yaml"]: the path is not allowed for team "app-a"`)
+}
+
+func TestCommand_RenderMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	base := filepath.Join(dir, "base.jsonnet")
+	require.NoError(t, os.WriteFile(base, []byte(`{"$files": {"a.txt": "a", "app.yaml": {"spec": {"replicas": 1}}}}`), 0644))
+
+	overlay := filepath.Join(dir, "overlay.yaml")
+	require.NoError(t, os.WriteFile(overlay, []byte("$files:\n  app.yaml:\n    metadata:\n      name: app\n"), 0644))
+
+	out := filepath.Join(dir, "out")
+
+	require.NoError(t, run([]string{
+		"render",
+		"-file", base,
+		"-file", overlay,
+		"-out", out,
+	}))
+
+	b, err := os.ReadFile(filepath.Join(out, "app.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "metadata:\n  name: app\nspec:\n  replicas: 1\n", string(b))
+
+	conflict := filepath.Join(dir, "conflict.jsonnet")
+	require.NoError(t, os.WriteFile(conflict, []byte(`{"$files": {"a.txt": "b"}}`), 0644))
+
+	err = run([]string{"validate", "-file", base, "-file", conflict})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `$files["a.txt"]: defined differently by `+base+` and `+conflict)
+}
+
 func readAll(t *testing.T, f *os.File) string {
 	t.Helper()
 
@@ -94,3 +321,71 @@ func readAll(t *testing.T, f *os.File) string {
 
 	return string(b)
 }
+
+func TestCommand_PushJSON(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "dummy")
+
+	src := t.TempDir()
+	r, err := git.PlainInitWithOptions(src, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.Main},
+	})
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	_, err = w.Commit("initial commit", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "someone", Email: "someone@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	root := t.TempDir()
+	_, err = git.PlainClone(filepath.Join(root, "remote.git"), true, &git.CloneOptions{URL: src})
+	require.NoError(t, err)
+
+	// The remote is served over the smart HTTP protocol, and sends the messages to the client like GitHub does.
+	hook := filepath.Join(root, "remote.git", "hooks", "pre-receive")
+	require.NoError(t, os.MkdirAll(filepath.Dir(hook), 0755))
+	require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\necho 'Create a pull request for the branch' >&2\n"), 0755))
+
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	srv := httptest.NewServer(&cgi.Handler{
+		Path: gitBin,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + root,
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.receivepack",
+			"GIT_CONFIG_VALUE_0=true",
+		},
+	})
+	defer srv.Close()
+	remote := srv.URL + "/remote.git"
+
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	require.NoError(t, err)
+
+	pStdout := os.Stdout
+	defer func() { os.Stdout = pStdout }()
+	os.Stdout = stdout
+
+	require.NoError(t, run([]string{
+		"-file", "testdata/test.jsonnet",
+		"-repo", remote,
+		"-branch", "main",
+		"-cache-dir", t.TempDir(),
+		"-output", "json",
+	}))
+
+	os.Stdout = pStdout
+
+	// The progress of the push goes to stderr, so that stdout is a single JSON document.
+	var result gitimpart.PushResult
+	require.NoError(t, json.Unmarshal([]byte(readAll(t, stdout)), &result))
+	require.True(t, result.Pushed)
+	require.Regexp(t, `^refs/heads/gitimpart/main/[0-9a-f]{8}$`, result.Ref)
+	require.Len(t, result.Files, 6)
+}
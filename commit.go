@@ -0,0 +1,294 @@
+package gitimpart
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/mumoshu/gitimpart/envvar"
+	"github.com/mumoshu/gitimpart/store"
+)
+
+// Commit configures the commit made by Push.
+//
+// It is read from the `$commit` section of the Contents,
+// so that the jsonnet file can configure the commit it results in.
+// The fields set via PushOptions take precedence over it.
+type Commit struct {
+	// Subject is the template of the commit message subject.
+	// See CommitTemplateData for the data available to the template.
+	Subject string `json:"subject,omitempty"`
+	// Body is the template of the commit message body.
+	Body string `json:"body,omitempty"`
+	// Author is the author of the commit.
+	Author *Identity `json:"author,omitempty"`
+	// Committer is the committer of the commit.
+	Committer *Identity `json:"committer,omitempty"`
+	// CoAuthors are added to the commit message as Co-authored-by trailers,
+	// in the form of "Name <email>".
+	CoAuthors []string `json:"coAuthors,omitempty"`
+	// SignOff adds the Signed-off-by trailer of the committer to the commit message.
+	SignOff bool `json:"signOff,omitempty"`
+}
+
+// Identity is the name and the email of a commit author or committer.
+type Identity struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+func (i Identity) String() string {
+	return fmt.Sprintf("%s <%s>", i.Name, i.Email)
+}
+
+// CommitTemplateData is the data available to the commit subject and body templates.
+//
+// For example, `Update {{ len .Files }} files for {{ .Vars.env }}`.
+type CommitTemplateData struct {
+	// Repo is the repository the changes are pushed to.
+	Repo string
+	// Branch is the branch the changes are pushed to.
+	Branch string
+	// Files is the sorted list of the files added, modified, or deleted.
+	Files []string
+	// AddedOrModifiedFiles is the sorted list of the files added or modified.
+	AddedOrModifiedFiles []string
+	// DeletedFiles is the sorted list of the files deleted.
+	DeletedFiles []string
+	// Vars is the variables passed via WithTemplateVars, usually the same as the jsonnet ext vars.
+	Vars map[string]string
+	// SourceCommit is the commit of the source repository the contents were rendered from.
+	SourceCommit string
+	// Source is the jsonnet file the contents were rendered from, set via WithSourceFile.
+	Source string
+	// Dirs is Files grouped by the directories.
+	// The statuses of the files are known only in pull request bodies, as the commit is not made yet otherwise.
+	Dirs []DirChanges
+	// Provenance is the GitHub Actions workflow run that renders the contents, or nil outside of GitHub Actions.
+	Provenance *Provenance
+	// Diff is the unified diff of the changes, truncated when it is too large.
+	// It is empty in commit messages, as the commit is not made yet.
+	Diff string
+	// SemanticDiff is the Markdown section describing the changes to the values of the YAML and JSON files.
+	// It is empty in commit messages, as the commit is not made yet.
+	SemanticDiff string
+}
+
+const (
+	// DefaultCommitSubjectTemplate is the commit subject template used when none is configured.
+	DefaultCommitSubjectTemplate = `Update {{ len .Files }} file(s) in {{ .Branch }}`
+	// DefaultCommitBodyTemplate is the commit body template used when none is configured.
+	DefaultCommitBodyTemplate = `Files:
+{{ range .Files }}- {{ . }}
+{{ end }}{{ with .SourceCommit }}
+Source commit: {{ . }}
+{{ end }}`
+)
+
+// WithAuthor sets the author of the commit.
+// It defaults to $GITIMPART_COMMIT_AUTHOR_USER_NAME and $GITIMPART_COMMIT_AUTHOR_EMAIL.
+func WithAuthor(name, email string) PushOptions {
+	return func(c *PushConfig) {
+		c.AuthorName = name
+		c.AuthorEmail = email
+	}
+}
+
+// WithCommitter sets the committer of the commit.
+// It defaults to $GITIMPART_COMMIT_COMMITTER_USER_NAME and $GITIMPART_COMMIT_COMMITTER_EMAIL,
+// or the author.
+func WithCommitter(name, email string) PushOptions {
+	return func(c *PushConfig) {
+		c.CommitterName = name
+		c.CommitterEmail = email
+	}
+}
+
+// WithCoAuthors adds the Co-authored-by trailers to the commit message.
+// Each co-author is in the form of "Name <email>".
+func WithCoAuthors(coAuthors ...string) PushOptions {
+	return func(c *PushConfig) {
+		c.CoAuthors = append(c.CoAuthors, coAuthors...)
+	}
+}
+
+// WithSignOff adds the Signed-off-by trailer of the committer to the commit message.
+func WithSignOff() PushOptions {
+	return func(c *PushConfig) {
+		c.SignOff = true
+	}
+}
+
+// WithCommitMessage sets the templates of the commit message subject and body.
+// An empty template leaves the corresponding part as-is.
+func WithCommitMessage(subject, body string) PushOptions {
+	return func(c *PushConfig) {
+		if subject != "" {
+			c.Subject = subject
+		}
+		if body != "" {
+			c.Body = body
+		}
+	}
+}
+
+// WithTemplateVars makes the variables available to the commit message and branch name templates as .Vars.
+func WithTemplateVars(vars map[string]string) PushOptions {
+	return func(c *PushConfig) {
+		c.TemplateVars = vars
+	}
+}
+
+// WithSourceCommit sets the commit of the source repository available to the commit message templates.
+// It defaults to $GITHUB_SHA.
+func WithSourceCommit(sha string) PushOptions {
+	return func(c *PushConfig) {
+		c.SourceCommit = sha
+	}
+}
+
+// commitConfig merges the commit configuration from the PushConfig, the `$commit` section of the contents,
+// and the environment variables, in this order of precedence.
+func commitConfig(c PushConfig, r Contents) Commit {
+	var cc Commit
+	if r.Commit != nil {
+		cc = *r.Commit
+	}
+
+	if c.Subject != "" {
+		cc.Subject = c.Subject
+	}
+	if c.Body != "" {
+		cc.Body = c.Body
+	}
+
+	cc.Author = mergeIdentity(c.AuthorName, c.AuthorEmail, cc.Author, envvar.GitCommitAuthorUserName, envvar.GitCommitAuthorEmail)
+	cc.Committer = mergeIdentity(c.CommitterName, c.CommitterEmail, cc.Committer, envvar.GitCommitCommitterUserName, envvar.GitCommitCommitterEmail)
+
+	cc.CoAuthors = append(cc.CoAuthors, c.CoAuthors...)
+	cc.SignOff = cc.SignOff || c.SignOff
+
+	return cc
+}
+
+func mergeIdentity(name, email string, i *Identity, nameEnv, emailEnv string) *Identity {
+	var r Identity
+	if i != nil {
+		r = *i
+	}
+
+	if name != "" {
+		r.Name = name
+	} else if r.Name == "" {
+		r.Name = os.Getenv(nameEnv)
+	}
+
+	if email != "" {
+		r.Email = email
+	} else if r.Email == "" {
+		r.Email = os.Getenv(emailEnv)
+	}
+
+	return &r
+}
+
+// applyTo configures the git store to commit with the identities and the trailers.
+// It replaces the trailers set by the previous call, as it is called again on each re-render.
+func (c Commit) applyTo(g *store.Git) {
+	g.AuthorName = c.Author.Name
+	g.AuthorEmail = c.Author.Email
+	g.CommitterName = c.Committer.Name
+	g.CommitterEmail = c.Committer.Email
+	g.SignOff = c.SignOff
+
+	g.Trailers = nil
+	for _, a := range c.CoAuthors {
+		g.Trailers = append(g.Trailers, "Co-authored-by: "+a)
+	}
+}
+
+// message renders the subject and body templates.
+func (c Commit) message(data CommitTemplateData) (string, string, error) {
+	subjectTmpl := c.Subject
+	if subjectTmpl == "" {
+		subjectTmpl = DefaultCommitSubjectTemplate
+	}
+
+	bodyTmpl := c.Body
+	if bodyTmpl == "" {
+		bodyTmpl = DefaultCommitBodyTemplate
+	}
+
+	subject, err := renderTemplate("subject", subjectTmpl, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	body, err := renderTemplate("body", bodyTmpl, data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(subject), body, nil
+}
+
+func newCommitTemplateData(repo, branch string, r *store.RenderResult, vars map[string]string, sourceCommit string) CommitTemplateData {
+	d := CommitTemplateData{
+		Repo:         repo,
+		Branch:       branch,
+		Vars:         vars,
+		SourceCommit: sourceCommit,
+	}
+
+	if d.Vars == nil {
+		d.Vars = map[string]string{}
+	}
+
+	if r != nil {
+		d.AddedOrModifiedFiles = sortedCopy(r.AddedOrModifiedFiles)
+		d.DeletedFiles = sortedCopy(r.DeletedFiles)
+		d.Files = sortedCopy(append(append([]string{}, r.AddedOrModifiedFiles...), r.DeletedFiles...))
+
+		deleted := map[string]bool{}
+		for _, f := range r.DeletedFiles {
+			deleted[f] = true
+		}
+
+		var changes []store.FileChange
+		for _, f := range d.Files {
+			c := store.FileChange{Path: f}
+			if deleted[f] {
+				c.Status = store.FileDeleted
+			}
+			changes = append(changes, c)
+		}
+		d.Dirs = groupByDir(changes)
+	}
+
+	d.Provenance = provenanceFromEnv()
+
+	return d
+}
+
+func sortedCopy(s []string) []string {
+	c := append([]string{}, s...)
+	sort.Strings(c)
+	return c
+}
+
+func renderTemplate(name, text string, data interface{}) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse %s template: %w", name, err)
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("unable to render %s template: %w", name, err)
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mumoshu/gitimpart/envvar"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultFile is the configuration file gitimpart reads from the working directory.
+const DefaultFile = "gitimpart.yaml"
+
+// Config is the content of gitimpart.yaml.
+type Config struct {
+	// Policy is checked against the rendered changes before they are committed.
+	Policy *Policy `yaml:"policy,omitempty"`
+	// Encryption encrypts the rendered files with SOPS before they are committed.
+	Encryption *Encryption `yaml:"encryption,omitempty"`
+	// Redaction masks the secrets in the dry-run diffs, the logs, and the pull request bodies.
+	Redaction *Redaction `yaml:"redaction,omitempty"`
+}
+
+// Load reads the configuration from the content of envvar.RawConfig when it is set, or from the file otherwise.
+// It returns the empty configuration when the file does not exist.
+func Load(path string) (*Config, error) {
+	var (
+		b   []byte
+		err error
+	)
+
+	if raw := os.Getenv(envvar.RawConfig); raw != "" {
+		b = []byte(raw)
+		path = "$" + envvar.RawConfig
+	} else {
+		b, err = os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return &Config{}, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", path, err)
+		}
+	}
+
+	var c Config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	return &c, nil
+}
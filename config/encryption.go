@@ -0,0 +1,47 @@
+package config
+
+// Encryption configures the SOPS encryption of the rendered files.
+//
+// The files are selected by the rules, or by listing them in the `$encrypt` section of the contents,
+// and encrypted for the recipients of the first matching rule, or for the default recipients.
+type Encryption struct {
+	// SOPSOptions is the default recipients and the values to encrypt.
+	SOPSOptions `yaml:",inline"`
+	// Rules selects the files to encrypt by their paths. The first matching rule wins.
+	Rules []EncryptionRule `yaml:"rules,omitempty"`
+}
+
+// EncryptionRule encrypts the files matching the path glob.
+type EncryptionRule struct {
+	// Path is the glob of the files relative to the repository root, like "apps/**/secrets.yaml".
+	// "**" matches zero or more directories.
+	Path string `yaml:"path"`
+	// SOPSOptions overrides the default recipients and the values to encrypt.
+	SOPSOptions `yaml:",inline"`
+}
+
+// SOPSOptions is the recipients the data key of a SOPS file is encrypted for,
+// and the values encrypted with the data key.
+type SOPSOptions struct {
+	// Age is the age recipients, like "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p".
+	Age []string `json:"age,omitempty" yaml:"age,omitempty"`
+	// PGP is the armored OpenPGP public keys, or the paths to the files containing them.
+	PGP []string `json:"pgp,omitempty" yaml:"pgp,omitempty"`
+	// EncryptedRegex encrypts only the values under the keys matching the regular expression,
+	// like `^(data|stringData)$` for Kubernetes Secrets.
+	// If empty, all the values are encrypted except the ones under the keys with the "_unencrypted" suffix.
+	EncryptedRegex string `json:"encryptedRegex,omitempty" yaml:"encryptedRegex,omitempty"`
+}
+
+// Override returns the options with the non-empty fields of the other options taking precedence.
+func (o SOPSOptions) Override(other SOPSOptions) SOPSOptions {
+	if len(other.Age) > 0 || len(other.PGP) > 0 {
+		o.Age, o.PGP = other.Age, other.PGP
+	}
+
+	if other.EncryptedRegex != "" {
+		o.EncryptedRegex = other.EncryptedRegex
+	}
+
+	return o
+}
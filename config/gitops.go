@@ -35,9 +35,27 @@ type Git struct {
 	// If false, gitimpart just clones the repository, may or may not update the gitops config locally,
 	// and runs necessary commands to apply the changes (like kubectl-apply and terraform-apply).
 	Push bool `yaml:"push,omitempty"`
+
+	// Depth limits the clone to the specified number of commits from the tip of the branch.
+	// If non-zero, only the branch is cloned.
+	Depth int `yaml:"depth,omitempty"`
+
+	// Sparse specifies whether only the directory specified by Path is checked out.
+	Sparse bool `yaml:"sparse,omitempty"`
 }
 
-type PullRequest struct{}
+type PullRequest struct {
+	// Branch is the Go template of the name of the feature branch the pull request is created from.
+	// It can refer to .ID, .Target, .ContentHash, .Timestamp, and .Vars.
+	// Defaults to "gitimpart/{{ .Target }}/{{ .ContentHash }}".
+	Branch string `yaml:"branch,omitempty"`
+
+	// OnBranchCollision is what to do when the feature branch already exists on the remote.
+	// It is either "suffix", which appends "-2", "-3", and so on to the branch name, or
+	// "reuse", which overwrites the branch and updates the pull request if any.
+	// Defaults to "suffix".
+	OnBranchCollision string `yaml:"onBranchCollision,omitempty"`
+}
 
 // RepositoryDispatch specifies whether the gitimpart run is triggered via GitHub repository_dispatch.
 type RepositoryDispatch struct {
@@ -0,0 +1,47 @@
+package config
+
+// Policy restricts the changes gitimpart commits to the shared gitops repository.
+//
+// All the paths are globs relative to the repository root, where "**" matches zero or more directories,
+// like "apps/**" or "**/*.secret.yaml".
+type Policy struct {
+	// AllowedPaths is the paths every team can change. If empty, any path is allowed unless denied.
+	AllowedPaths []string `yaml:"allowedPaths,omitempty"`
+	// DeniedPaths is the paths no team can change. It takes precedence over the allowed paths.
+	DeniedPaths []string `yaml:"deniedPaths,omitempty"`
+
+	// Teams is the paths each team can change, keyed by the team names.
+	// When set, the team must be specified on every run, and only the paths allowed for both
+	// the team and everyone can be changed.
+	Teams map[string]TeamPolicy `yaml:"teams,omitempty"`
+
+	// MaxChangedFiles is the maximum number of the files added or modified by a run. 0 means no limit.
+	MaxChangedFiles int `yaml:"maxChangedFiles,omitempty"`
+	// MaxDeletedFiles is the maximum number of the files deleted by a run. 0 means no limit.
+	MaxDeletedFiles int `yaml:"maxDeletedFiles,omitempty"`
+
+	// ForbiddenPatterns is the content that must not be committed, like plaintext secrets.
+	ForbiddenPatterns []ForbiddenPattern `yaml:"forbiddenPatterns,omitempty"`
+
+	// ProtectedPaths is the paths that can be changed only via pull requests.
+	ProtectedPaths []string `yaml:"protectedPaths,omitempty"`
+}
+
+// TeamPolicy is the paths a team can change.
+type TeamPolicy struct {
+	// AllowedPaths is the paths the team can change. If empty, the team can change any path unless denied.
+	AllowedPaths []string `yaml:"allowedPaths,omitempty"`
+	// DeniedPaths is the paths the team cannot change. It takes precedence over the allowed paths.
+	DeniedPaths []string `yaml:"deniedPaths,omitempty"`
+}
+
+// ForbiddenPattern is the regular expression that the changed files must not match.
+type ForbiddenPattern struct {
+	// Name describes the pattern in the violations, like "AWS access key".
+	// The matched content is never printed, as it may be a secret.
+	Name string `yaml:"name"`
+	// Pattern is the RE2 regular expression, like `AKIA[0-9A-Z]{16}`.
+	Pattern string `yaml:"pattern"`
+	// Paths limits the pattern to the files matching any of the globs. If empty, all files are checked.
+	Paths []string `yaml:"paths,omitempty"`
+}
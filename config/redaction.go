@@ -0,0 +1,22 @@
+package config
+
+// Redaction configures how the secrets are masked in the dry-run diffs, the logs, and the pull request bodies.
+//
+// The GitHub token and the credentials of the known formats are always masked.
+type Redaction struct {
+	// SecretData masks the values of `data` and `stringData` of Kubernetes Secrets. Defaults to true.
+	SecretData *bool `yaml:"secretData,omitempty"`
+	// Keys masks the values under the keys matching any of the regular expressions in YAML and JSON files,
+	// like `(?i)(password|token)$`.
+	Keys []string `yaml:"keys,omitempty"`
+	// Patterns masks the strings matching any of the regular expressions wherever they appear.
+	Patterns []string `yaml:"patterns,omitempty"`
+	// Env masks the values of the environment variables wherever they appear, like the credentials given to the job.
+	// The values shorter than 8 bytes are not masked, as they are likely to appear in the unrelated text.
+	Env []string `yaml:"env,omitempty"`
+}
+
+// MaskSecretData tells whether to mask the values of Kubernetes Secrets.
+func (r Redaction) MaskSecretData() bool {
+	return r.SecretData == nil || *r.SecretData
+}
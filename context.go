@@ -0,0 +1,231 @@
+package gitimpart
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/mumoshu/gitimpart/envvar"
+)
+
+// ContextExtVar is the ext var the RunContext is available to the `.template.` jsonnet files via,
+// like `std.extVar("context").repo`.
+const ContextExtVar = "context"
+
+const (
+	// ContextProviderGitHubActions and the other providers are the values of RunContext.Provider.
+	ContextProviderGitHubActions = "github-actions"
+	ContextProviderGitLabCI      = "gitlab-ci"
+	ContextProviderGit           = "git"
+)
+
+// RunContext describes where and why gitimpart runs, like the repository and the commit of the source
+// and the CI run, so that a template can render the contents for the repository it is used in.
+//
+// The fields are empty when unknown.
+type RunContext struct {
+	// Provider is where the values are gathered from, either "github-actions", "gitlab-ci", or "git".
+	Provider string `json:"provider"`
+	// Repo is the repository in the form of OWNER/NAME, like "mumoshu/gitimpart".
+	// For GitLab, OWNER is the namespace of the project, which may contain slashes.
+	Repo string `json:"repo"`
+	// RepoOwner and RepoName are the parts of Repo.
+	RepoOwner string `json:"repoOwner"`
+	RepoName  string `json:"repoName"`
+	// Ref is the full name of the ref, like "refs/heads/main" or "refs/pull/1/merge".
+	Ref string `json:"ref"`
+	// SHA is the hash of the commit.
+	SHA string `json:"sha"`
+	// Actor is the user who triggered the run.
+	Actor string `json:"actor"`
+	// Event is the name of the event that triggered the run, like "push" or "pull_request" for GitHub Actions,
+	// or "push" or "merge_request_event" for GitLab CI.
+	Event string `json:"event"`
+	// PullRequest is the number of the pull request or the merge request, or 0 when the run is not for one.
+	PullRequest int `json:"pullRequest"`
+	// RunURL is the URL of the workflow run or the pipeline.
+	RunURL string `json:"runURL"`
+}
+
+// Context makes the `.template.` jsonnet files render with the RunContext
+// instead of the one detected by DetectRunContext.
+func Context(c RunContext) LoadOption {
+	return func(cfg *LoadConfig) {
+		cfg.Context = &c
+	}
+}
+
+// DetectRunContext gathers the RunContext from the environment variables of GitHub Actions or GitLab CI,
+// or from the git repository the directory is in otherwise.
+func DetectRunContext(dir string) RunContext {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true" || os.Getenv(envvar.GitHubRepository) != "":
+		return githubActionsContext()
+	case os.Getenv("GITLAB_CI") == "true":
+		return gitlabCIContext()
+	default:
+		return gitContext(dir)
+	}
+}
+
+func githubActionsContext() RunContext {
+	c := RunContext{
+		Provider: ContextProviderGitHubActions,
+		Ref:      os.Getenv("GITHUB_REF"),
+		SHA:      os.Getenv("GITHUB_SHA"),
+		Actor:    os.Getenv("GITHUB_ACTOR"),
+		Event:    os.Getenv("GITHUB_EVENT_NAME"),
+	}
+
+	c.setRepo(os.Getenv(envvar.GitHubRepository))
+
+	if p := provenanceFromEnv(); p != nil {
+		c.RunURL = p.RunURL
+	}
+
+	if m := pullRefPattern.FindStringSubmatch(c.Ref); m != nil {
+		c.PullRequest, _ = strconv.Atoi(m[1])
+	} else if path := os.Getenv(envvar.GitHubEventPath); path != "" {
+		c.PullRequest = pullRequestNumberFromEvent(path)
+	}
+
+	return c
+}
+
+var pullRefPattern = regexp.MustCompile(`^refs/pull/(\d+)/`)
+
+// pullRequestNumberFromEvent reads the number of the pull request from the event payload,
+// for the pull_request_target and the other events whose ref is not the pull request ref.
+func pullRequestNumberFromEvent(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	var event struct {
+		PullRequest *struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+	}
+	if err := json.Unmarshal(b, &event); err != nil || event.PullRequest == nil {
+		return 0
+	}
+
+	return event.PullRequest.Number
+}
+
+func gitlabCIContext() RunContext {
+	c := RunContext{
+		Provider: ContextProviderGitLabCI,
+		SHA:      os.Getenv("CI_COMMIT_SHA"),
+		Actor:    os.Getenv("GITLAB_USER_LOGIN"),
+		Event:    os.Getenv("CI_PIPELINE_SOURCE"),
+		RunURL:   os.Getenv("CI_PIPELINE_URL"),
+	}
+
+	c.setRepo(os.Getenv("CI_PROJECT_PATH"))
+
+	switch {
+	case os.Getenv("CI_MERGE_REQUEST_REF_PATH") != "":
+		c.Ref = os.Getenv("CI_MERGE_REQUEST_REF_PATH")
+	case os.Getenv("CI_COMMIT_TAG") != "":
+		c.Ref = "refs/tags/" + os.Getenv("CI_COMMIT_TAG")
+	case os.Getenv("CI_COMMIT_BRANCH") != "":
+		c.Ref = "refs/heads/" + os.Getenv("CI_COMMIT_BRANCH")
+	}
+
+	c.PullRequest, _ = strconv.Atoi(os.Getenv("CI_MERGE_REQUEST_IID"))
+
+	return c
+}
+
+// gitContext reads the HEAD and the origin of the git repository the directory is in.
+func gitContext(dir string) RunContext {
+	c := RunContext{Provider: ContextProviderGit}
+
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return RunContext{}
+	}
+
+	if head, err := repo.Head(); err == nil {
+		c.SHA = head.Hash().String()
+		if head.Name().IsBranch() {
+			c.Ref = head.Name().String()
+		}
+	}
+
+	if remote, err := repo.Remote(git.DefaultRemoteName); err == nil && len(remote.Config().URLs) > 0 {
+		c.setRepo(repoFromURL(remote.Config().URLs[0]))
+	}
+
+	return c
+}
+
+var repoURLPattern = regexp.MustCompile(`^(?:[a-z+]+://[^/]+/|[^@/]+@[^:/]+:)(.+?)(?:\.git)?/?$`)
+
+// repoFromURL returns OWNER/NAME of the remote URL like "https://github.com/OWNER/NAME.git"
+// or "git@github.com:OWNER/NAME.git".
+func repoFromURL(u string) string {
+	m := repoURLPattern.FindStringSubmatch(u)
+	if m == nil {
+		return ""
+	}
+
+	return m[1]
+}
+
+// setRepo sets the repository and its owner and name when it is in the form of OWNER/NAME.
+func (c *RunContext) setRepo(repo string) {
+	c.Repo = repo
+	c.RepoOwner, c.RepoName = "", ""
+
+	if i := strings.LastIndex(repo, "/"); i > 0 && i < len(repo)-1 {
+		c.RepoOwner, c.RepoName = repo[:i], repo[i+1:]
+	}
+}
+
+// Override returns the context with the non-empty fields of the other context taking precedence.
+// The owner and the name of the repository are derived from the repository when it is overridden.
+func (c RunContext) Override(o RunContext) RunContext {
+	if o.Provider != "" {
+		c.Provider = o.Provider
+	}
+	if o.Repo != "" {
+		c.setRepo(o.Repo)
+	}
+	if o.Ref != "" {
+		c.Ref = o.Ref
+	}
+	if o.SHA != "" {
+		c.SHA = o.SHA
+	}
+	if o.Actor != "" {
+		c.Actor = o.Actor
+	}
+	if o.Event != "" {
+		c.Event = o.Event
+	}
+	if o.PullRequest != 0 {
+		c.PullRequest = o.PullRequest
+	}
+	if o.RunURL != "" {
+		c.RunURL = o.RunURL
+	}
+
+	return c
+}
+
+// extCode returns the context as the jsonnet code for ContextExtVar.
+func (c RunContext) extCode() (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal run context: %w", err)
+	}
+
+	return string(b), nil
+}
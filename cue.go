@@ -0,0 +1,95 @@
+package gitimpart
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/load"
+	"cuelang.org/go/cue/parser"
+)
+
+// renderCUE evaluates the CUE file that describes the contents, like `cue export`.
+//
+// The variables given via Vars are injected into the fields with the matching `@tag(name)` attributes,
+// like `cue export -t name=value`.
+// The variables not referred by any tag are ignored, as all the variables are given to every input.
+func renderCUE(path string, cfg LoadConfig) (*Contents, error) {
+	tags, err := cueTags(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for _, name := range tags {
+		if v, ok := cfg.Vars[name]; ok {
+			args = append(args, name+"="+v)
+		}
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get absolute path to %s: %w", path, err)
+	}
+
+	insts := load.Instances([]string{abs}, &load.Config{
+		Dir:  filepath.Dir(abs),
+		Tags: args,
+	})
+	if err := insts[0].Err; err != nil {
+		return nil, fmt.Errorf("unable to load %s: %w", path, err)
+	}
+
+	v := cuecontext.New().BuildInstance(insts[0])
+	if err := v.Validate(cue.Concrete(true)); err != nil {
+		return nil, fmt.Errorf("unable to evaluate %s: %w", path, err)
+	}
+
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("unable to evaluate %s: %w", path, err)
+	}
+
+	return unmarshalContents(b)
+}
+
+// cueTags returns the names of the `@tag(name)` attributes in the CUE file.
+func cueTags(path string) ([]string, error) {
+	f, err := parser.ParseFile(path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	seen := map[string]bool{}
+
+	ast.Walk(f, func(n ast.Node) bool {
+		a, ok := n.(*ast.Attribute)
+		if !ok {
+			return true
+		}
+
+		key, body := a.Split()
+		if key != "tag" {
+			return true
+		}
+
+		name, _, _ := strings.Cut(body, ",")
+		if name = strings.TrimSpace(name); name != "" {
+			seen[name] = true
+		}
+
+		return true
+	}, nil)
+
+	tags := make([]string, 0, len(seen))
+	for name := range seen {
+		tags = append(tags, name)
+	}
+	sort.Strings(tags)
+
+	return tags, nil
+}
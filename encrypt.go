@@ -0,0 +1,314 @@
+package gitimpart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getsops/sops/v3/aes"
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/mumoshu/gitimpart/config"
+	"github.com/mumoshu/gitimpart/store"
+	"gopkg.in/yaml.v2"
+)
+
+// FileEncryption is how a file listed in the `$encrypt` section of the contents is encrypted.
+// The empty fields default to the ones of config.Encryption.
+//
+// It is either `true` for the defaults, or an object like `{"encryptedRegex": "^(data|stringData)$"}`.
+type FileEncryption config.SOPSOptions
+
+// UnmarshalJSON accepts `true` in addition to the object.
+func (e *FileEncryption) UnmarshalJSON(b []byte) error {
+	switch strings.TrimSpace(string(b)) {
+	case "true":
+		*e = FileEncryption{}
+		return nil
+	case "false", "null":
+		return errors.New("it must be either true or an object")
+	}
+
+	var o config.SOPSOptions
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+
+	*e = FileEncryption(o)
+
+	return nil
+}
+
+// WithEncryption makes Push encrypt the files selected by the rules, or listed in the `$encrypt` section of the contents,
+// in the format of SOPS before writing them into the repository.
+//
+// A file whose content is unchanged from the SOPS file on the branch is kept as it is,
+// when the file can be decrypted with the keys given by WithSOPSKeys or read by SOPSKeysFromEnv.
+func WithEncryption(e config.Encryption) PushOptions {
+	return func(c *PushConfig) {
+		c.Encryption = &e
+	}
+}
+
+// WithSOPSKeys makes Push decrypt the SOPS files on the branch with the keys instead of the ones read by SOPSKeysFromEnv,
+// to keep the unchanged files as they are and to show the diffs of the encrypted files.
+// PushFunc also renders with the keys, for the `sopsDecrypt` native function.
+func WithSOPSKeys(keys SOPSKeys) PushOptions {
+	return func(c *PushConfig) {
+		c.SOPSKeys = &keys
+	}
+}
+
+func (c PushConfig) sopsKeys() (*SOPSKeys, error) {
+	if c.SOPSKeys != nil {
+		return c.SOPSKeys, nil
+	}
+
+	return SOPSKeysFromEnv()
+}
+
+// encryption returns the options to encrypt the file with, or nil when the file is not encrypted.
+func (c Contents) encryption(name string, e *config.Encryption) (*config.SOPSOptions, error) {
+	var defaults config.SOPSOptions
+	if e != nil {
+		defaults = e.SOPSOptions
+	}
+
+	if fe, ok := c.Encrypt[name]; ok {
+		o := defaults.Override(config.SOPSOptions(*fe))
+		return &o, nil
+	}
+
+	if e == nil {
+		return nil, nil
+	}
+
+	for _, r := range e.Rules {
+		ok, err := matchGlob(r.Path, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption rule path %q: %w", r.Path, err)
+		}
+		if ok {
+			o := defaults.Override(r.SOPSOptions)
+			return &o, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// encryptContents returns the contents with the files to encrypt replaced with their SOPS files.
+// The files unchanged from the SOPS files in the directory are replaced with the existing ones.
+func encryptContents(r Contents, e *config.Encryption, dir string, keys *SOPSKeys) (Contents, error) {
+	files := make(map[string]interface{}, len(r.Files))
+	now := time.Now()
+
+	var errs []error
+
+	for name, content := range r.Files {
+		files[name] = content
+
+		o, err := r.encryption(name, e)
+		if err != nil {
+			return Contents{}, err
+		}
+		if o == nil {
+			continue
+		}
+
+		recipients, err := parseSOPSRecipients(*o)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("$files[%q]: %w", name, err))
+			continue
+		}
+
+		if old, ok := unchangedSOPSFile(name, content, filepath.Join(dir, name), recipients, keys); ok {
+			files[name] = old
+			continue
+		}
+
+		b, err := encryptSOPS(name, content, recipients, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("$files[%q]: unable to encrypt: %w", name, err))
+			continue
+		}
+		files[name] = string(b)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return Contents{}, err
+	}
+
+	r.Files = files
+	r.Encrypt = nil
+
+	return r, nil
+}
+
+// unchangedSOPSFile returns the content of the SOPS file at the path
+// when it decrypts to the content for the same recipients.
+func unchangedSOPSFile(name string, content interface{}, path string, r *sopsRecipients, keys *SOPSKeys) (string, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+
+	old, err := loadSOPSFile(name, b)
+	if err != nil || old == nil || !r.matches(old.Metadata) {
+		return "", false
+	}
+
+	key, err := sopsDataKey(old, keys)
+	if err != nil {
+		return "", false
+	}
+
+	if err := decryptSOPS(old, key); err != nil {
+		return "", false
+	}
+
+	tree, err := sopsTree(name, content)
+	if err != nil || !equalJSON(plainValue(orderedSOPS(old.Branches[0])), plainValue(orderedSOPS(tree))) {
+		return "", false
+	}
+
+	return string(b), true
+}
+
+// DecryptSOPS decrypts the YAML or JSON file encrypted by sops with the keys,
+// returning the content in the same format without the SOPS metadata.
+func DecryptSOPS(name string, b []byte, keys SOPSKeys) ([]byte, error) {
+	tree, err := loadSOPSFile(name, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if tree == nil {
+		return nil, fmt.Errorf("%s is not a SOPS file", name)
+	}
+
+	key, err := sopsDataKey(tree, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decryptSOPS(tree, key); err != nil {
+		return nil, err
+	}
+
+	return marshalOrdered(name, orderedSOPS(tree.Branches[0]).(yaml.MapSlice))
+}
+
+// sopsTextConv shows the SOPS files in the diffs decrypted with the keys,
+// with each encrypted value masked, like `<encrypted:0123abcd>`.
+//
+// The files that cannot be decrypted are shown as they are.
+func sopsTextConv(keys func() (*SOPSKeys, error), values valueMasker) store.TextConv {
+	var (
+		loaded  bool
+		sopsKey *SOPSKeys
+	)
+
+	return func(name string, b []byte) ([]byte, error) {
+		tree, err := loadSOPSFile(name, b)
+		if err != nil || tree == nil {
+			return nil, nil
+		}
+
+		if !loaded {
+			loaded = true
+			sopsKey, _ = keys()
+		}
+
+		key, err := sopsDataKey(tree, sopsKey)
+		if err != nil {
+			return nil, nil
+		}
+
+		if err := decryptSOPS(tree, key); err != nil {
+			return nil, nil
+		}
+
+		// The MAC is verified above, as it cannot be with the values masked.
+		masked, err := loadSOPSFile(name, b)
+		if err != nil {
+			return nil, nil
+		}
+
+		if _, err := masked.Decrypt(key, sopsMaskingCipher{
+			Cipher: aes.NewCipher(),
+			mask: func(path []string, v interface{}) interface{} {
+				return values.mask("encrypted", path, v)
+			},
+		}); err != nil {
+			return nil, nil
+		}
+
+		return marshalOrdered(name, orderedSOPS(masked.Branches[0]).(yaml.MapSlice))
+	}
+}
+
+// sopsDecryptFunc is the `sopsDecrypt` native function,
+// so that the secrets in the target repository can be merged into the rendered contents.
+func sopsDecryptFunc(keys *SOPSKeys) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "sopsDecrypt",
+		Params: ast.Identifiers{"path", "str"},
+		Func: func(args []interface{}) (interface{}, error) {
+			name, err := stringArg("sopsDecrypt", "path", args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			s, err := stringArg("sopsDecrypt", "str", args[1])
+			if err != nil {
+				return nil, err
+			}
+
+			if keys == nil {
+				keys, err = SOPSKeysFromEnv()
+				if err != nil {
+					return nil, fmt.Errorf("sopsDecrypt: %w", err)
+				}
+			}
+
+			b, err := DecryptSOPS(name, []byte(s), *keys)
+			if err != nil {
+				return nil, fmt.Errorf("sopsDecrypt: %w", err)
+			}
+
+			return string(b), nil
+		},
+	}
+}
+
+// validateEncrypt checks that the files listed in the `$encrypt` section are YAML or JSON files in `$files`.
+func (c Contents) validateEncrypt() []error {
+	names := make([]string, 0, len(c.Encrypt))
+	for name := range c.Encrypt {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+
+	for _, name := range names {
+		if _, ok := c.Files[name]; !ok {
+			errs = append(errs, fmt.Errorf("$encrypt[%q]: the file is not in $files", name))
+			continue
+		}
+
+		switch filepath.Ext(name) {
+		case ".yaml", ".yml", ".json":
+		default:
+			errs = append(errs, fmt.Errorf("$encrypt[%q]: only YAML and JSON files can be encrypted", name))
+		}
+	}
+
+	return errs
+}
@@ -28,6 +28,39 @@ const (
 	GitCommitAuthorUserName = Prefix + "COMMIT_AUTHOR_USER_NAME"
 	GitCommitAuthorEmail    = Prefix + "COMMIT_AUTHOR_EMAIL"
 
+	// GitCommitCommitterUserName and GitCommitCommitterEmail are the committer of the commits.
+	// They default to the author.
+	GitCommitCommitterUserName = Prefix + "COMMIT_COMMITTER_USER_NAME"
+	GitCommitCommitterEmail    = Prefix + "COMMIT_COMMITTER_EMAIL"
+
+	// CacheDir is the directory to cache the clones of the git repositories across runs.
+	CacheDir = Prefix + "CACHE_DIR"
+
+	// GitBackend is the git backend to use, either "go-git" or "exec".
+	GitBackend = Prefix + "GIT_BACKEND"
+
+	// SigningKey is the armored OpenPGP private key or the SSH private key to sign the commits with.
+	SigningKey = Prefix + "SIGNING_KEY"
+	// SigningKeyFile is the path to the file that contains the key to sign the commits with.
+	// SigningKey takes precedence over it.
+	SigningKeyFile = Prefix + "SIGNING_KEY_FILE"
+	// SigningKeyPassphrase is the passphrase to decrypt the signing key.
+	SigningKeyPassphrase = Prefix + "SIGNING_KEY_PASSPHRASE"
+
+	// SOPSPGPKey is the armored OpenPGP private key to decrypt the SOPS files with,
+	// as gitimpart does not use the GnuPG keyring sops does.
+	SOPSPGPKey = Prefix + "SOPS_PGP_KEY"
+	// SOPSPGPKeyFile is the path to the file that contains the key to decrypt the SOPS files with.
+	// SOPSPGPKey takes precedence over it.
+	SOPSPGPKeyFile = Prefix + "SOPS_PGP_KEY_FILE"
+	// SOPSPGPKeyPassphrase is the passphrase to decrypt the OpenPGP private key.
+	SOPSPGPKeyPassphrase = Prefix + "SOPS_PGP_KEY_PASSPHRASE"
+
+	// SOPSAgeKey and SOPSAgeKeyFile are the age identities to decrypt the SOPS files with,
+	// read the same way sops does.
+	SOPSAgeKey     = "SOPS_AGE_KEY"
+	SOPSAgeKeyFile = "SOPS_AGE_KEY_FILE"
+
 	GitHubToken = "GITHUB_TOKEN"
 
 	// StateFilePath is the path to the file that stores the state of the environment.
@@ -1,7 +1,7 @@
 package gitimpart
 
 import (
-	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,7 +11,19 @@ import (
 
 // LoadFile loads a json or jsonnet file and returns the content as a byte slice.
 // In case it is a jsonnet file, it evaluates the jsonnet file and returns the resulting json as a byte slice.
+//
+// A jsonnet file whose name contains ".template." is rendered with the RunContext available via
+// `std.extVar("context")`, which is given via Context or detected by DetectRunContext in the working directory.
 func LoadFile(path string, opts ...LoadOption) ([]byte, error) {
+	var cfg LoadConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return loadFile(path, cfg)
+}
+
+func loadFile(path string, cfg LoadConfig) ([]byte, error) {
 	file, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -23,25 +35,55 @@ func LoadFile(path string, opts ...LoadOption) ([]byte, error) {
 		if strings.Contains(filepath.Base(path), ".template.") {
 			vm.ExtVar("template", "true")
 
-			if v := os.Getenv("GITHUB_REPOSITORY"); v != "" {
-				splits := strings.Split(v, "/")
-				vm.ExtVar("github_repo_owner", splits[0])
-				vm.ExtVar("github_repo_name", splits[1])
-			} else {
-				return nil, errors.New(".template.jsonnet requires GITHUB_REPOSITORY to be set to OWNER/REPO_NAME for the template to access `std.extVar(\"github_repo_name\")` and `std.extVar(\"github_repo_owner\")`")
+			rc := cfg.Context
+			if rc == nil {
+				detected := DetectRunContext(".")
+				rc = &detected
+			}
+
+			code, err := rc.extCode()
+			if err != nil {
+				return nil, err
+			}
+			vm.ExtCode(ContextExtVar, code)
+
+			// Kept for the templates written before the context, which are available only when the repository is known.
+			if rc.RepoOwner != "" {
+				vm.ExtVar("github_repo_owner", rc.RepoOwner)
+				vm.ExtVar("github_repo_name", rc.RepoName)
 			}
 		}
 
-		var cfg LoadConfig
-		for _, opt := range opts {
-			opt(&cfg)
+		jpaths, err := libraryPaths(path, cfg.JPaths)
+		if err != nil {
+			return nil, err
+		}
+
+		vm.Importer(&repoImporter{dir: cfg.RepoDir, file: &jsonnet.FileImporter{JPaths: jpaths}})
+
+		for _, f := range append(nativeFunctions(path, cfg.RepoDir, cfg.SOPSKeys), cfg.NativeFunctions...) {
+			vm.NativeFunction(f)
 		}
 
 		for k, v := range cfg.Vars {
 			vm.ExtVar(k, v)
 		}
 
-		json, err := vm.EvaluateAnonymousSnippet(path, string(file))
+		for k, v := range cfg.ExtCode {
+			vm.ExtCode(k, v)
+		}
+
+		for k, v := range cfg.TLAs {
+			vm.TLAVar(k, v)
+		}
+
+		for k, v := range cfg.TLACode {
+			vm.TLACode(k, v)
+		}
+
+		// Evaluate the file rather than its content so that the imports are resolved relative to the file,
+		// as the jsonnet command does, which matters for the files fetched into temporary directories.
+		json, err := vm.EvaluateFile(path)
 		if err != nil {
 			return nil, err
 		}
@@ -51,3 +93,56 @@ func LoadFile(path string, opts ...LoadOption) ([]byte, error) {
 
 	return file, nil
 }
+
+// jsonnetfile is the file jsonnet-bundler manages the dependencies with,
+// which are installed into the vendor directory next to it.
+const jsonnetfile = "jsonnetfile.json"
+
+// libraryPaths returns the library search directories in the order jsonnet.FileImporter expects,
+// that is, the last one wins.
+func libraryPaths(path string, jpaths []string) ([]string, error) {
+	var dirs []string
+
+	vendor, err := findVendorDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if vendor != "" {
+		dirs = append(dirs, vendor)
+	}
+
+	// The left-most directory in JSONNET_PATH wins, as the jsonnet command does.
+	env := filepath.SplitList(os.Getenv("JSONNET_PATH"))
+	for i := len(env) - 1; i >= 0; i-- {
+		if env[i] != "" {
+			dirs = append(dirs, env[i])
+		}
+	}
+
+	return append(dirs, jpaths...), nil
+}
+
+// findVendorDir returns the vendor directory next to the nearest jsonnetfile.json
+// found in the directory of the jsonnet file or its ancestors.
+// It returns an empty string when there is no jsonnetfile.json or no vendor directory.
+func findVendorDir(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to get absolute path to %s: %w", path, err)
+	}
+
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, jsonnetfile)); err == nil {
+			vendor := filepath.Join(dir, "vendor")
+			if fi, err := os.Stat(vendor); err == nil && fi.IsDir() {
+				return vendor, nil
+			}
+			return "", nil
+		}
+
+		if parent := filepath.Dir(dir); parent == dir {
+			return "", nil
+		}
+	}
+}
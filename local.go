@@ -0,0 +1,23 @@
+package gitimpart
+
+import (
+	"github.com/mumoshu/gitimpart/store"
+)
+
+// RenderLocal writes the contents into the local store the same way Push writes them into the clone of the repository,
+// without cloning, committing, or accessing the network.
+//
+// It is useful for testing jsonnet files and feeding the rendered files to linters.
+// Use the store to list the files or archive them as a tar or zip.
+//
+// Only WithKustomizeBin among the options is taken into account.
+func RenderLocal(r Contents, l *store.Local, opts ...PushOptions) (*store.RenderResult, error) {
+	var c PushConfig
+	for _, o := range opts {
+		o(&c)
+	}
+
+	return l.Transact(func(dir string) (*store.RenderResult, error) {
+		return writeContents(r, dir, c.KustomizeBin)
+	})
+}
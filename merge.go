@@ -0,0 +1,177 @@
+package gitimpart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// RenderFiles renders each file with RenderFile and deep-merges the contents in order.
+// See MergeContents for how they are merged.
+func RenderFiles(paths []string, opts ...LoadOption) (*Contents, error) {
+	contents := make([]*Contents, 0, len(paths))
+
+	for _, p := range paths {
+		c, err := RenderFile(p, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to render %s: %w", p, err)
+		}
+		contents = append(contents, c)
+	}
+
+	return MergeContents(paths, contents)
+}
+
+// RenderFilesFunc is RenderFileFunc for RenderFiles.
+func RenderFilesFunc(paths []string, opts ...LoadOption) RenderFunc {
+	return func(repoDir string, extra ...LoadOption) (*Contents, error) {
+		return RenderFiles(paths, append(append(append([]LoadOption{}, opts...), extra...), RepoDir(repoDir))...)
+	}
+}
+
+// MergeContents deep-merges the contents rendered from the sources, like the file paths, in order.
+//
+// A file in `$files` whose contents are objects in more than one source is deep-merged,
+// with the later source winning for the same key.
+// Any other file defined by more than one source must have the same content in all of them,
+// and every such conflict is reported along with the sources that define the file.
+// The `$commit` sections are deep-merged the same way, and the `$kustomize` directories are combined.
+// A file in `$encrypt` is encrypted as the last source listing it says.
+func MergeContents(sources []string, contents []*Contents) (*Contents, error) {
+	if len(sources) != len(contents) {
+		return nil, fmt.Errorf("unable to merge contents: %d sources for %d contents", len(sources), len(contents))
+	}
+
+	merged := &Contents{Files: map[string]interface{}{}}
+	// origins is the source that defined each file first.
+	origins := map[string]string{}
+
+	var (
+		errs   []error
+		commit interface{}
+	)
+
+	for i, c := range contents {
+		src := sources[i]
+
+		names := make([]string, 0, len(c.Files))
+		for name := range c.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			content := c.Files[name]
+
+			existing, ok := merged.Files[name]
+			if !ok {
+				merged.Files[name] = content
+				origins[name] = src
+				continue
+			}
+
+			m, ok := mergeValues(existing, content)
+			if !ok {
+				errs = append(errs, fmt.Errorf("$files[%q]: defined differently by %s and %s", name, origins[name], src))
+				continue
+			}
+			merged.Files[name] = m
+		}
+
+		for dir, files := range c.Kustomize {
+			if merged.Kustomize == nil {
+				merged.Kustomize = map[string]map[string]interface{}{}
+			}
+			if merged.Kustomize[dir] == nil {
+				merged.Kustomize[dir] = map[string]interface{}{}
+			}
+			for name, content := range files {
+				merged.Kustomize[dir][name] = content
+			}
+		}
+
+		for name, e := range c.Encrypt {
+			if merged.Encrypt == nil {
+				merged.Encrypt = map[string]*FileEncryption{}
+			}
+			merged.Encrypt[name] = e
+		}
+
+		if c.Commit != nil {
+			v, err := toJSONValue(c.Commit)
+			if err != nil {
+				return nil, fmt.Errorf("unable to merge $commit of %s: %w", src, err)
+			}
+			if commit == nil {
+				commit = v
+			} else {
+				commit, _ = mergeValues(commit, v)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("conflicting files: %w", errors.Join(errs...))
+	}
+
+	if commit != nil {
+		b, err := json.Marshal(commit)
+		if err != nil {
+			return nil, fmt.Errorf("unable to merge $commit: %w", err)
+		}
+		if err := json.Unmarshal(b, &merged.Commit); err != nil {
+			return nil, fmt.Errorf("unable to merge $commit: %w", err)
+		}
+	}
+
+	return merged, nil
+}
+
+// mergeValues deep-merges the objects with the src winning for the same key.
+// It returns false when the values are not both objects and differ.
+func mergeValues(dst, src interface{}) (interface{}, bool) {
+	d, dok := dst.(map[string]interface{})
+	s, sok := src.(map[string]interface{})
+	if !dok || !sok {
+		return src, equalJSON(dst, src)
+	}
+
+	m := make(map[string]interface{}, len(d)+len(s))
+	for k, v := range d {
+		m[k] = v
+	}
+
+	for k, v := range s {
+		if dv, ok := m[k]; ok {
+			if merged, ok := mergeValues(dv, v); ok {
+				m[k] = merged
+				continue
+			}
+		}
+		m[k] = v
+	}
+
+	return m, true
+}
+
+func equalJSON(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+func toJSONValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var r interface{}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
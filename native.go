@@ -0,0 +1,419 @@
+package gitimpart
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"gopkg.in/yaml.v2"
+)
+
+// NativeFunctions registers the Go functions callable from the jsonnet file via `std.native(name)`.
+//
+// They are registered after the built-in native functions, so a function with the same name
+// as a built-in one replaces it.
+// The arguments and the return values are the JSON values as decoded by encoding/json,
+// that is, nil, bool, float64, string, []interface{}, and map[string]interface{}.
+func NativeFunctions(fns ...*jsonnet.NativeFunction) LoadOption {
+	return func(c *LoadConfig) {
+		c.NativeFunctions = append(c.NativeFunctions, fns...)
+	}
+}
+
+// nativeFunctions returns the built-in native functions available to the jsonnet file at path:
+//
+//   - parseYaml(str): the YAML document, or the array of the documents when there are more than one
+//   - manifestYamlStream(docs): the documents as a YAML stream, formatted the same as the .yaml files written by Push
+//   - sha256(str): the hex-encoded SHA-256 of the string, useful for ConfigMap name suffixes
+//   - regexMatch(pattern, str): whether the string contains a match of the RE2 pattern
+//   - regexReplace(pattern, str, replacement): the string with the matches replaced, where $1 refers to the submatch
+//   - regexSubmatch(pattern, str): the leftmost match followed by the submatches, or null when there is no match
+//   - semverCompare(a, b): -1, 0, or 1 depending on whether the version a is less than, equal to, or greater than b
+//   - semverBump(version, part): the version with "major", "minor", or "patch" incremented
+//   - base64File(path): the base64-encoded content of the file relative to the jsonnet file,
+//     or in the target repository when the path starts with gitimpart://
+//   - repoFile(path): see RepoDir
+//   - sopsDecrypt(path, str): the content of the SOPS file decrypted with the keys given by DecryptionKeys
+//     or read by SOPSKeysFromEnv, where the extension of the path tells YAML from JSON, like `sopsDecrypt(p, repoFile(p))`
+func nativeFunctions(path, repoDir string, keys *SOPSKeys) []*jsonnet.NativeFunction {
+	return []*jsonnet.NativeFunction{
+		{
+			Name:   "parseYaml",
+			Params: ast.Identifiers{"str"},
+			Func: func(args []interface{}) (interface{}, error) {
+				s, err := stringArg("parseYaml", "str", args[0])
+				if err != nil {
+					return nil, err
+				}
+				v, err := parseYAML(s)
+				if err != nil {
+					return nil, fmt.Errorf("parseYaml: %w", err)
+				}
+				return v, nil
+			},
+		},
+		{
+			Name:   "manifestYamlStream",
+			Params: ast.Identifiers{"docs"},
+			Func: func(args []interface{}) (interface{}, error) {
+				docs, ok := args[0].([]interface{})
+				if !ok {
+					return nil, fmt.Errorf("manifestYamlStream: docs must be an array, but got %T", args[0])
+				}
+				return manifestYAMLStream(docs)
+			},
+		},
+		{
+			Name:   "sha256",
+			Params: ast.Identifiers{"str"},
+			Func: func(args []interface{}) (interface{}, error) {
+				s, err := stringArg("sha256", "str", args[0])
+				if err != nil {
+					return nil, err
+				}
+				sum := sha256.Sum256([]byte(s))
+				return hex.EncodeToString(sum[:]), nil
+			},
+		},
+		{
+			Name:   "regexMatch",
+			Params: ast.Identifiers{"pattern", "str"},
+			Func: func(args []interface{}) (interface{}, error) {
+				re, s, err := regexArgs("regexMatch", args)
+				if err != nil {
+					return nil, err
+				}
+				return re.MatchString(s), nil
+			},
+		},
+		{
+			Name:   "regexReplace",
+			Params: ast.Identifiers{"pattern", "str", "replacement"},
+			Func: func(args []interface{}) (interface{}, error) {
+				re, s, err := regexArgs("regexReplace", args)
+				if err != nil {
+					return nil, err
+				}
+				repl, err := stringArg("regexReplace", "replacement", args[2])
+				if err != nil {
+					return nil, err
+				}
+				return re.ReplaceAllString(s, repl), nil
+			},
+		},
+		{
+			Name:   "regexSubmatch",
+			Params: ast.Identifiers{"pattern", "str"},
+			Func: func(args []interface{}) (interface{}, error) {
+				re, s, err := regexArgs("regexSubmatch", args)
+				if err != nil {
+					return nil, err
+				}
+				m := re.FindStringSubmatch(s)
+				if m == nil {
+					return nil, nil
+				}
+				result := make([]interface{}, len(m))
+				for i, v := range m {
+					result[i] = v
+				}
+				return result, nil
+			},
+		},
+		{
+			Name:   "semverCompare",
+			Params: ast.Identifiers{"a", "b"},
+			Func: func(args []interface{}) (interface{}, error) {
+				var vs [2]*semver
+				for i, name := range []string{"a", "b"} {
+					s, err := stringArg("semverCompare", name, args[i])
+					if err != nil {
+						return nil, err
+					}
+					if vs[i], err = parseSemver(s); err != nil {
+						return nil, fmt.Errorf("semverCompare: %w", err)
+					}
+				}
+				return float64(vs[0].compare(vs[1])), nil
+			},
+		},
+		{
+			Name:   "semverBump",
+			Params: ast.Identifiers{"version", "part"},
+			Func: func(args []interface{}) (interface{}, error) {
+				s, err := stringArg("semverBump", "version", args[0])
+				if err != nil {
+					return nil, err
+				}
+				part, err := stringArg("semverBump", "part", args[1])
+				if err != nil {
+					return nil, err
+				}
+				v, err := parseSemver(s)
+				if err != nil {
+					return nil, fmt.Errorf("semverBump: %w", err)
+				}
+				bumped, err := v.bump(part)
+				if err != nil {
+					return nil, fmt.Errorf("semverBump: %w", err)
+				}
+				return bumped.String(), nil
+			},
+		},
+		{
+			Name:   "base64File",
+			Params: ast.Identifiers{"path"},
+			Func: func(args []interface{}) (interface{}, error) {
+				p, err := stringArg("base64File", "path", args[0])
+				if err != nil {
+					return nil, err
+				}
+
+				var b []byte
+				if strings.HasPrefix(p, RepoURLScheme) {
+					b, err = readRepoFile(repoDir, strings.TrimPrefix(p, RepoURLScheme))
+				} else {
+					if !filepath.IsAbs(p) {
+						p = filepath.Join(filepath.Dir(path), p)
+					}
+					b, err = os.ReadFile(p)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("base64File: %w", err)
+				}
+
+				return base64.StdEncoding.EncodeToString(b), nil
+			},
+		},
+		repoFileFunc(repoDir),
+		sopsDecryptFunc(keys),
+	}
+}
+
+func stringArg(fn, name string, v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: %s must be a string, but got %T", fn, name, v)
+	}
+
+	return s, nil
+}
+
+func regexArgs(fn string, args []interface{}) (*regexp.Regexp, string, error) {
+	pattern, err := stringArg(fn, "pattern", args[0])
+	if err != nil {
+		return nil, "", err
+	}
+
+	s, err := stringArg(fn, "str", args[1])
+	if err != nil {
+		return nil, "", err
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", fn, err)
+	}
+
+	return re, s, nil
+}
+
+// parseYAML parses the YAML stream into the JSON values jsonnet accepts from native functions.
+// It returns the document, or the array of the documents when there are more than one.
+func parseYAML(s string) (interface{}, error) {
+	docs, err := parseYAMLDocuments(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(docs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return docs[0], nil
+	default:
+		return docs, nil
+	}
+}
+
+// parseYAMLDocuments parses the YAML stream into the documents of the JSON values.
+func parseYAMLDocuments(s string) ([]interface{}, error) {
+	var docs []interface{}
+
+	dec := yaml.NewDecoder(strings.NewReader(s))
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Convert the integers and the other YAML types into the JSON ones.
+		b, err := json.Marshal(plainValue(doc))
+		if err != nil {
+			return nil, err
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, v)
+	}
+
+	return docs, nil
+}
+
+func manifestYAMLStream(docs []interface{}) (string, error) {
+	var b bytes.Buffer
+
+	for _, doc := range docs {
+		y, err := yaml.Marshal(doc)
+		if err != nil {
+			return "", fmt.Errorf("manifestYamlStream: %w", err)
+		}
+
+		b.WriteString("---\n")
+		b.Write(y)
+	}
+
+	return b.String(), nil
+}
+
+// semver is a semantic version like "v1.2.3-rc.1+build.5".
+// The "v" prefix is kept as is when bumped.
+type semver struct {
+	prefix              string
+	major, minor, patch int
+	pre                 []string
+	build               string
+}
+
+var semverPattern = regexp.MustCompile(`^(v?)(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+
+func parseSemver(s string) (*semver, error) {
+	m := semverPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid semantic version %q", s)
+	}
+
+	v := &semver{prefix: m[1], build: m[6]}
+
+	for i, p := range []*int{&v.major, &v.minor, &v.patch} {
+		n, err := strconv.Atoi(m[i+2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version %q: %w", s, err)
+		}
+		*p = n
+	}
+
+	if m[5] != "" {
+		v.pre = strings.Split(m[5], ".")
+	}
+
+	return v, nil
+}
+
+// compare compares the versions by the precedence defined in the Semantic Versioning spec,
+// ignoring the build metadata.
+func (v *semver) compare(o *semver) int {
+	for _, p := range [][2]int{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if c := compareInts(p[0], p[1]); c != 0 {
+			return c
+		}
+	}
+
+	// A pre-release version has lower precedence than the normal version.
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, errA := strconv.Atoi(v.pre[i])
+		b, errB := strconv.Atoi(o.pre[i])
+
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = compareInts(a, b)
+		case errA == nil:
+			// Numeric identifiers have lower precedence than alphanumeric ones.
+			c = -1
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(v.pre[i], o.pre[i])
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return compareInts(len(v.pre), len(o.pre))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// bump increments the part of the version and drops the pre-release and the build metadata,
+// except that bumping the patch of a pre-release version releases it, like "1.2.3-rc.1" to "1.2.3".
+func (v *semver) bump(part string) (*semver, error) {
+	b := &semver{prefix: v.prefix, major: v.major, minor: v.minor, patch: v.patch}
+
+	switch part {
+	case "major":
+		b.major, b.minor, b.patch = v.major+1, 0, 0
+	case "minor":
+		b.minor, b.patch = v.minor+1, 0
+	case "patch":
+		if len(v.pre) == 0 {
+			b.patch = v.patch + 1
+		}
+	default:
+		return nil, fmt.Errorf("unknown part %q: it must be one of \"major\", \"minor\", and \"patch\"", part)
+	}
+
+	return b, nil
+}
+
+func (v *semver) String() string {
+	s := fmt.Sprintf("%s%d.%d.%d", v.prefix, v.major, v.minor, v.patch)
+
+	if len(v.pre) > 0 {
+		s += "-" + strings.Join(v.pre, ".")
+	}
+
+	if v.build != "" {
+		s += "+" + v.build
+	}
+
+	return s
+}
@@ -0,0 +1,226 @@
+package gitimpart
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// orderedValue converts the value rendered from jsonnet into the tree with the keys sorted,
+// the same order as the files written by Push, and the integral numbers as ints.
+func orderedValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		m := make(yaml.MapSlice, 0, len(t))
+		for _, k := range keys {
+			m = append(m, yaml.MapItem{Key: k, Value: orderedValue(t[k])})
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, item := range t {
+			s[i] = orderedValue(item)
+		}
+		return s
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < 1<<53 {
+			return int(t)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// plainValue converts the ordered tree back into the maps, for comparison.
+func plainValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		m := make(map[string]interface{}, len(t))
+		for _, item := range t {
+			m[fmt.Sprint(item.Key)] = plainValue(item.Value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, item := range t {
+			s[i] = plainValue(item)
+		}
+		return s
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			m[fmt.Sprint(k)] = plainValue(item)
+		}
+		return m
+	default:
+		return v
+	}
+}
+
+// parseOrderedJSON parses the JSON keeping the order of the keys.
+func parseOrderedJSON(b []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	v, err := decodeOrderedJSON(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("unexpected content after the JSON value")
+	}
+
+	return v, nil
+}
+
+func decodeOrderedJSON(d *json.Decoder) (interface{}, error) {
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			m := yaml.MapSlice{}
+			for d.More() {
+				k, err := d.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := decodeOrderedJSON(d)
+				if err != nil {
+					return nil, err
+				}
+				m = append(m, yaml.MapItem{Key: k, Value: v})
+			}
+			_, err := d.Token()
+			return m, err
+		case '[':
+			s := []interface{}{}
+			for d.More() {
+				v, err := decodeOrderedJSON(d)
+				if err != nil {
+					return nil, err
+				}
+				s = append(s, v)
+			}
+			_, err := d.Token()
+			return s, err
+		default:
+			return nil, fmt.Errorf("unexpected %s", t)
+		}
+	case json.Number:
+		if i, err := strconv.Atoi(t.String()); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	default:
+		return t, nil
+	}
+}
+
+// marshalOrdered marshals the ordered tree into YAML or JSON by the extension of the file.
+func marshalOrdered(name string, tree yaml.MapSlice) ([]byte, error) {
+	if filepath.Ext(name) != ".json" {
+		b, err := yaml.Marshal(tree)
+		if err != nil {
+			return nil, fmt.Errorf("marshal error: %w", err)
+		}
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeOrderedJSON(&buf, tree, ""); err != nil {
+		return nil, fmt.Errorf("marshal error: %w", err)
+	}
+	buf.WriteString("\n")
+
+	return buf.Bytes(), nil
+}
+
+func writeOrderedJSON(buf *bytes.Buffer, v interface{}, indent string) error {
+	inner := indent + "\t"
+
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		if len(t) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i, item := range t {
+			k, err := marshalJSONValue(fmt.Sprint(item.Key))
+			if err != nil {
+				return err
+			}
+			buf.WriteString(inner)
+			buf.Write(k)
+			buf.WriteString(": ")
+			if err := writeOrderedJSON(buf, item.Value, inner); err != nil {
+				return err
+			}
+			if i < len(t)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case []interface{}:
+		if len(t) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range t {
+			buf.WriteString(inner)
+			if err := writeOrderedJSON(buf, item, inner); err != nil {
+				return err
+			}
+			if i < len(t)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "]")
+	default:
+		b, err := marshalJSONValue(plainValue(v))
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+
+	return nil
+}
+
+// marshalJSONValue is json.Marshal without escaping the HTML characters,
+// so that the values like `<encrypted:0123abcd>` are written as they are.
+func marshalJSONValue(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
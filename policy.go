@@ -0,0 +1,205 @@
+package gitimpart
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/mumoshu/gitimpart/config"
+)
+
+// ErrPolicyViolation is returned by Push and CheckPolicy when the changes violate the policy.
+var ErrPolicyViolation = errors.New("policy violation")
+
+// PolicyInput is the changes checked against the policy.
+type PolicyInput struct {
+	// Team is the team making the changes, which selects config.Policy.Teams.
+	Team string
+	// PullRequest is true when the changes are proposed via a pull request rather than pushed to the branch.
+	PullRequest bool
+	// Changed is the contents of the files added or modified, keyed by the paths.
+	Changed map[string][]byte
+	// Deleted is the paths of the files deleted.
+	// They are checked against the paths like the changed files, but not against the forbidden patterns.
+	Deleted []string
+}
+
+// WithPolicy makes Push check the changes against the policy on behalf of the team before committing them,
+// failing with ErrPolicyViolation without committing anything when they violate it.
+//
+// Only the files whose contents differ from the branch count as changed.
+func WithPolicy(p config.Policy, team string) PushOptions {
+	return func(c *PushConfig) {
+		c.Policy = &p
+		c.Team = team
+	}
+}
+
+// CheckPolicy reports every violation of the policy by the changes.
+func CheckPolicy(p config.Policy, in PolicyInput) error {
+	var errs []error
+
+	var team *config.TeamPolicy
+	if len(p.Teams) > 0 {
+		t, ok := p.Teams[in.Team]
+		switch {
+		case in.Team == "":
+			errs = append(errs, errors.New("team is required, as the policy restricts the paths per team"))
+		case !ok:
+			errs = append(errs, fmt.Errorf("unknown team %q", in.Team))
+		default:
+			team = &t
+		}
+	}
+
+	changed := make([]string, 0, len(in.Changed))
+	for name := range in.Changed {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+
+	if p.MaxChangedFiles > 0 && len(changed) > p.MaxChangedFiles {
+		errs = append(errs, fmt.Errorf("too many changed files: %d, while the maximum is %d", len(changed), p.MaxChangedFiles))
+	}
+
+	deleted := append([]string{}, in.Deleted...)
+	sort.Strings(deleted)
+
+	if p.MaxDeletedFiles > 0 && len(deleted) > p.MaxDeletedFiles {
+		errs = append(errs, fmt.Errorf("too many deleted files: %d, while the maximum is %d", len(deleted), p.MaxDeletedFiles))
+	}
+
+	patterns := make([]*regexp.Regexp, len(p.ForbiddenPatterns))
+	for i, fp := range p.ForbiddenPatterns {
+		re, err := regexp.Compile(fp.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid forbidden pattern %q: %w", fp.Name, err)
+		}
+		patterns[i] = re
+	}
+
+	checkPath := func(name string) error {
+		if err := checkPaths(name, p.AllowedPaths, p.DeniedPaths, "everyone"); err != nil {
+			return err
+		}
+
+		if team != nil {
+			if err := checkPaths(name, team.AllowedPaths, team.DeniedPaths, fmt.Sprintf("team %q", in.Team)); err != nil {
+				return err
+			}
+		}
+
+		if !in.PullRequest {
+			protected, err := matchAny(p.ProtectedPaths, name)
+			if err != nil {
+				return err
+			}
+			if protected {
+				return errors.New("the path is protected and must be changed via a pull request")
+			}
+		}
+
+		return nil
+	}
+
+	for _, name := range deleted {
+		if err := checkPath(name); err != nil {
+			errs = append(errs, fmt.Errorf("$files[%q] (deleted): %w", name, err))
+		}
+	}
+
+	for _, name := range changed {
+		if err := checkPath(name); err != nil {
+			errs = append(errs, fmt.Errorf("$files[%q]: %w", name, err))
+		}
+
+		for i, fp := range p.ForbiddenPatterns {
+			if len(fp.Paths) > 0 {
+				ok, err := matchAny(fp.Paths, name)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+			}
+
+			// Never print the match, which may be a secret.
+			if loc := patterns[i].FindIndex(in.Changed[name]); loc != nil {
+				line := bytes.Count(in.Changed[name][:loc[0]], []byte("\n")) + 1
+				errs = append(errs, fmt.Errorf("$files[%q]: line %d matches the forbidden pattern %q", name, line, fp.Name))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w:\n%w", ErrPolicyViolation, errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func checkPaths(name string, allowed, denied []string, who string) error {
+	ok, err := matchAny(denied, name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("the path is denied for %s", who)
+	}
+
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	ok, err = matchAny(allowed, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("the path is not allowed for %s", who)
+	}
+
+	return nil
+}
+
+func matchAny(globs []string, name string) (bool, error) {
+	for _, g := range globs {
+		ok, err := matchGlob(g, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid path glob %q: %w", g, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// changedFiles returns the contents of the files whose contents differ from the ones in the directory.
+func changedFiles(r Contents, dir string) (map[string][]byte, error) {
+	changed := map[string][]byte{}
+
+	for name, content := range r.Files {
+		b, err := marshalFile(name, content)
+		if err != nil {
+			return nil, err
+		}
+
+		old, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil && bytes.Equal(old, b) {
+			continue
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("unable to read %s: %w", name, err)
+		}
+
+		changed[name] = b
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,179 @@
+package gitimpart
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mumoshu/gitimpart/store"
+)
+
+// DefaultPullRequestBodyTemplate is the template of the pull request body used when none is configured.
+// See CommitTemplateData for the data available to the template.
+//
+// It can also be used for commit bodies via WithCommitMessage,
+// in which case the diff and the statuses of the files are omitted.
+const DefaultPullRequestBodyTemplate = `This pull request was generated by gitimpart{{ with .Source }} from ` + "`{{ . }}`" + `{{ end }}.
+
+### Changed files
+{{ range .Dirs }}
+**{{ .Dir }}**
+{{ range .Files }}- ` + "`{{ .Path }}`" + `{{ with .Status }} ({{ . }}){{ end }}
+{{ end }}{{ end }}{{ with .Vars }}
+### Variables
+
+| Name | Value |
+| --- | --- |
+{{ range $k, $v := . }}| ` + "`{{ $k }}`" + ` | ` + "`{{ $v }}`" + ` |
+{{ end }}{{ end }}{{ with .SemanticDiff }}
+{{ . }}{{ end }}{{ with .Diff }}
+<details>
+<summary>Diff</summary>
+
+` + "```diff" + `
+{{ . }}` + "```" + `
+
+</details>
+{{ end }}{{ with .Provenance }}
+### Provenance
+
+- Repository: {{ .RepositoryURL }}
+- Commit: {{ .CommitURL }}{{ with .Ref }}
+- Ref: ` + "`{{ . }}`" + `{{ end }}{{ with .Workflow }}
+- Workflow: {{ . }}{{ end }}{{ with .RunURL }}
+- Run: {{ . }}{{ end }}{{ with .Actor }}
+- Triggered by: @{{ . }}{{ with $.Provenance.Event }} on {{ . }}{{ end }}{{ end }}
+{{ else }}{{ with .SourceCommit }}
+Source commit: {{ . }}
+{{ end }}{{ end }}`
+
+// maxPullRequestDiffBytes keeps the pull request body within the limit of GitHub, which is 65536 characters.
+const maxPullRequestDiffBytes = 40000
+
+// DirChanges is the files changed in a directory.
+type DirChanges struct {
+	// Dir is the directory, like "path/to/dir/", or "/" for the root directory of the repository.
+	Dir   string
+	Files []store.FileChange
+}
+
+// Provenance is the GitHub Actions workflow run that renders the contents.
+type Provenance struct {
+	// RepositoryURL is the URL of the source repository, like "https://github.com/org/repo".
+	RepositoryURL string
+	// Commit is the commit of the source repository, and CommitURL is the URL of it.
+	Commit    string
+	CommitURL string
+	// Ref is the ref that triggered the workflow, like "refs/heads/main".
+	Ref      string
+	Workflow string
+	// RunURL is the URL of the workflow run.
+	RunURL string
+	Actor  string
+	// Event is the event that triggered the workflow, like "push".
+	Event string
+}
+
+// WithSourceFile sets the jsonnet file the contents were rendered from, which is shown in the pull request body.
+func WithSourceFile(path string) PushOptions {
+	return func(c *PushConfig) {
+		c.SourceFile = path
+	}
+}
+
+// WithPullRequestBody sets the template of the pull request body.
+// It defaults to DefaultPullRequestBodyTemplate.
+func WithPullRequestBody(tmpl string) PushOptions {
+	return func(c *PushConfig) {
+		c.PullRequestBody = tmpl
+	}
+}
+
+// provenanceFromEnv reads the provenance from the environment variables set by GitHub Actions.
+// It returns nil outside of GitHub Actions.
+func provenanceFromEnv() *Provenance {
+	repo := os.Getenv("GITHUB_REPOSITORY")
+	if repo == "" {
+		return nil
+	}
+
+	server := os.Getenv("GITHUB_SERVER_URL")
+	if server == "" {
+		server = "https://github.com"
+	}
+
+	p := &Provenance{
+		RepositoryURL: server + "/" + repo,
+		Commit:        os.Getenv("GITHUB_SHA"),
+		Ref:           os.Getenv("GITHUB_REF"),
+		Workflow:      os.Getenv("GITHUB_WORKFLOW"),
+		Actor:         os.Getenv("GITHUB_ACTOR"),
+		Event:         os.Getenv("GITHUB_EVENT_NAME"),
+	}
+
+	if p.Commit != "" {
+		p.CommitURL = p.RepositoryURL + "/commit/" + p.Commit
+	}
+
+	if id := os.Getenv("GITHUB_RUN_ID"); id != "" {
+		p.RunURL = p.RepositoryURL + "/actions/runs/" + id
+		if attempt := os.Getenv("GITHUB_RUN_ATTEMPT"); attempt != "" && attempt != "1" {
+			p.RunURL += "/attempts/" + attempt
+		}
+	}
+
+	return p
+}
+
+// groupByDir groups the files by the directories, sorted by the directories and then the paths.
+func groupByDir(files []store.FileChange) []DirChanges {
+	byDir := map[string][]store.FileChange{}
+	for _, f := range files {
+		dir := path.Dir(f.Path)
+		if dir == "." {
+			dir = "/"
+		} else {
+			dir += "/"
+		}
+		byDir[dir] = append(byDir[dir], f)
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for d := range byDir {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+
+	result := make([]DirChanges, 0, len(dirs))
+	for _, d := range dirs {
+		fs := byDir[d]
+		sort.Slice(fs, func(i, j int) bool { return fs[i].Path < fs[j].Path })
+		result = append(result, DirChanges{Dir: d, Files: fs})
+	}
+
+	return result
+}
+
+// pullRequestBody renders the pull request body template with the commit,
+// which adds the statuses of the files and the diffs to the data.
+func pullRequestBody(tmpl string, data CommitTemplateData, cr *store.CommitResult) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultPullRequestBodyTemplate
+	}
+
+	if cr != nil {
+		data.Dirs = groupByDir(cr.Files)
+
+		if cr.Diff != nil {
+			data.SemanticDiff = cr.Diff.SemanticMarkdown()
+
+			data.Diff = cr.Diff.Unified()
+			if len(data.Diff) > maxPullRequestDiffBytes {
+				data.Diff = strings.ToValidUTF8(data.Diff[:maxPullRequestDiffBytes], "") + "\n... (truncated)\n"
+			}
+		}
+	}
+
+	return renderTemplate("pull request body", tmpl, data)
+}
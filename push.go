@@ -2,16 +2,17 @@ package gitimpart
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/mumoshu/gitimpart/config"
 	"github.com/mumoshu/gitimpart/store"
-	"gopkg.in/yaml.v2"
 )
 
 type PushConfig struct {
@@ -20,20 +21,135 @@ type PushConfig struct {
 	// Dir is the directory to store the git repository.
 	// If provided, the caller needs to clean up the directory after the commit.
 	Dir string
-	// Subject is the commit message subject.
+	// CacheDir is the directory to cache the git repositories across runs.
+	// If provided, the repository is cloned into the directory keyed by the repository URL,
+	// and subsequent runs reuse it after fetching and resetting it to the remote branch.
+	// It takes precedence over Dir.
+	CacheDir string
+	// Subject is the template of the commit message subject.
+	// See CommitTemplateData for the data available to the template.
 	Subject string
-	// Body is the commit message body.
+	// Body is the template of the commit message body.
 	Body string
+	// AuthorName and AuthorEmail are the author of the commit.
+	AuthorName  string
+	AuthorEmail string
+	// CommitterName and CommitterEmail are the committer of the commit.
+	CommitterName  string
+	CommitterEmail string
+	// CoAuthors are added to the commit message as Co-authored-by trailers.
+	CoAuthors []string
+	// SignOff adds the Signed-off-by trailer of the committer to the commit message.
+	SignOff bool
+	// BranchTemplate is the Go template of the feature branch name.
+	// See store.BranchTemplateData for the data available to the template.
+	// Defaults to store.DefaultBranchTemplate.
+	BranchTemplate string
+	// BranchCollision is what to do when the feature branch already exists on the remote,
+	// either store.BranchCollisionSuffix or store.BranchCollisionReuse.
+	BranchCollision string
+	// TemplateVars is available to the commit message and branch name templates as .Vars.
+	TemplateVars map[string]string
+	// SourceCommit is available to the commit message templates as .SourceCommit.
+	SourceCommit string
+	// SourceFile is the jsonnet file the contents were rendered from,
+	// available to the commit message and pull request body templates as .Source.
+	SourceFile string
+	// PullRequestBody is the template of the pull request body. Defaults to DefaultPullRequestBodyTemplate.
+	PullRequestBody string
 	// DryRun is a flag to print the changes that would be made without actually making them.
 	DryRun bool
+	// DryRunOutput is where the changes are printed to in dry-run mode. Defaults to os.Stdout.
+	DryRunOutput io.Writer
+	// DiffFormat is the format of the changes printed in dry-run mode,
+	// one of store.DiffFormatPatch, store.DiffFormatStat, store.DiffFormatPatchWithStat, store.DiffFormatSemantic, and store.DiffFormatJSON.
+	// Defaults to store.DiffFormatPatch.
+	DiffFormat string
+	// DiffColor colorizes the unified diff printed in dry-run mode.
+	DiffColor bool
 	// SendPullRequest is a flag to send a pull request after the commit-push.
 	SendPullRequest bool
 	// KustomizeBin is the path to the kustomize binary.
 	KustomizeBin string
+	// Depth limits the clone to the specified number of commits from the tip of the branch.
+	// 0 means the full history.
+	Depth int
+	// SingleBranch is a flag to clone only the branch to push the changes to.
+	SingleBranch bool
+	// Filter is the partial clone filter spec, like "blob:none". It is ignored by the go-git backend. See WithPartialClone.
+	Filter string
+	// SparseCheckout is a flag to check out only the directories that the contents touch.
+	SparseCheckout bool
+	// SparseCheckoutDirectories is the list of directories to check out.
+	// If empty and SparseCheckout is true, the directories are derived from the contents.
+	SparseCheckoutDirectories []string
+	// MaxRetries is the number of times to retry the push when it is rejected
+	// because the branch has been updated by someone else after the clone.
+	// The files are re-rendered on top of the updated branch on each retry.
+	MaxRetries int
+	// RetryBackoff is the duration to wait before the first retry.
+	// It doubles on each subsequent retry.
+	RetryBackoff time.Duration
+	// GitBackend is the git backend to use, either store.BackendGoGit or store.BackendExec.
+	// Defaults to store.BackendGoGit.
+	GitBackend string
+	// SigningKey is the armored OpenPGP private key or the SSH private key to sign the commit with.
+	// If empty, the commit is not signed.
+	SigningKey []byte
+	// SigningKeyPassphrase is the passphrase to decrypt the SigningKey.
+	SigningKeyPassphrase string
+	// Schemas validates the rendered files before they are written into the repository. See WithSchemaValidation.
+	Schemas *SchemaConfig
+	// Policy is checked against the changes before they are committed. See WithPolicy.
+	Policy *config.Policy
+	// Team is the team making the changes, which selects the paths the Policy allows.
+	Team string
+	// Encryption selects the files encrypted with SOPS before they are written. See WithEncryption.
+	Encryption *config.Encryption
+	// SOPSKeys decrypts the SOPS files on the branch. Defaults to the keys read by SOPSKeysFromEnv.
+	SOPSKeys *SOPSKeys
+	// Redaction configures how the secrets are masked in the diffs and the pull request. See WithRedaction.
+	Redaction *config.Redaction
 }
 
 type PushOptions func(*PushConfig)
 
+// PushResult describes what Push did.
+type PushResult struct {
+	// Repo is the repository the changes are pushed to.
+	Repo string `json:"repo"`
+	// Branch is the branch the changes are pushed to, or the base branch of the pull request.
+	Branch string `json:"branch"`
+	// Ref is the ref the commit is pushed to, like "refs/heads/gitimpart/main/0123abcd".
+	Ref string `json:"ref,omitempty"`
+	// Commit is the hash of the commit.
+	Commit string `json:"commit,omitempty"`
+	// Base is the hash of the commit on Branch the commit is made on top of.
+	Base string `json:"base,omitempty"`
+	// Pushed is false when the commit is not pushed because of the dry-run mode.
+	Pushed bool `json:"pushed"`
+	// DryRun is true when Push is run in dry-run mode.
+	DryRun bool `json:"dryRun,omitempty"`
+	// NoChanges is true when there is nothing to push. Push returns ErrNoChanges along with the result in that case.
+	NoChanges bool `json:"noChanges,omitempty"`
+	// PullRequest is the pull request created or updated, if any.
+	PullRequest *PullRequestResult `json:"pullRequest,omitempty"`
+	// Files is the files added, modified, or deleted by the commit, sorted by path.
+	Files []store.FileChange `json:"files"`
+	// Diff is the changes made by the commit.
+	Diff *store.Diff `json:"diff,omitempty"`
+}
+
+// PullRequestResult is the pull request created or updated by Push.
+type PullRequestResult struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+}
+
+// ErrNoChanges is returned by Push when the rendered contents are identical to the branch.
+// Nothing is committed, pushed, or proposed as a pull request in that case.
+var ErrNoChanges = store.ErrNoChanges
+
 func WithGitHubToken(token string) PushOptions {
 	return func(c *PushConfig) {
 		c.Auth = &http.BasicAuth{
@@ -49,6 +165,28 @@ func WithDryRun() PushOptions {
 	}
 }
 
+// WithDryRunOutput makes Push print the changes to the writer instead of os.Stdout in dry-run mode.
+func WithDryRunOutput(w io.Writer) PushOptions {
+	return func(c *PushConfig) {
+		c.DryRunOutput = w
+	}
+}
+
+// WithDiffFormat sets the format of the changes printed in dry-run mode.
+// See store.DiffFormatPatch and the other formats.
+func WithDiffFormat(format string) PushOptions {
+	return func(c *PushConfig) {
+		c.DiffFormat = format
+	}
+}
+
+// WithDiffColor colorizes the unified diff printed in dry-run mode.
+func WithDiffColor() PushOptions {
+	return func(c *PushConfig) {
+		c.DiffColor = true
+	}
+}
+
 func WithPullRequest() PushOptions {
 	return func(c *PushConfig) {
 		c.SendPullRequest = true
@@ -61,6 +199,81 @@ func WithKustomizeBin(bin string) PushOptions {
 	}
 }
 
+// WithShallowClone makes Push clone only the branch to push the changes to,
+// with the history truncated to the specified number of commits.
+func WithShallowClone(depth int) PushOptions {
+	return func(c *PushConfig) {
+		c.Depth = depth
+		c.SingleBranch = true
+	}
+}
+
+// WithPartialClone makes Push do a partial clone with the specified filter spec, like "blob:none".
+// It takes effect with store.BackendExec only. The default go-git backend does not support partial clones,
+// and clones the whole repository with a warning.
+func WithPartialClone(filter string) PushOptions {
+	return func(c *PushConfig) {
+		c.Filter = filter
+	}
+}
+
+// WithSparseCheckout makes Push check out only the specified directories.
+// When no directories are specified, the directories that the contents touch are checked out.
+func WithSparseCheckout(dirs ...string) PushOptions {
+	return func(c *PushConfig) {
+		c.SparseCheckout = true
+		c.SparseCheckoutDirectories = dirs
+	}
+}
+
+// WithCacheDir makes Push reuse the clone of the repository cached in the directory across runs.
+func WithCacheDir(dir string) PushOptions {
+	return func(c *PushConfig) {
+		c.CacheDir = dir
+	}
+}
+
+func WithRetries(maxRetries int, backoff time.Duration) PushOptions {
+	return func(c *PushConfig) {
+		c.MaxRetries = maxRetries
+		c.RetryBackoff = backoff
+	}
+}
+
+// WithGitBackend makes Push use the specified git backend, either store.BackendGoGit or store.BackendExec.
+// store.BackendExec shells out to the git binary, so that partial clones, credential helpers, LFS,
+// and everything else the installed git supports are available.
+func WithGitBackend(backend string) PushOptions {
+	return func(c *PushConfig) {
+		c.GitBackend = backend
+	}
+}
+
+// WithSigningKey makes Push sign the commit with the key,
+// which is either an OpenPGP private key or an SSH private key.
+// The passphrase is used to decrypt the key when it is encrypted.
+func WithSigningKey(key []byte, passphrase string) PushOptions {
+	return func(c *PushConfig) {
+		c.SigningKey = key
+		c.SigningKeyPassphrase = passphrase
+	}
+}
+
+// WithBranchTemplate sets the Go template of the feature branch name, like store.DefaultBranchTemplate.
+func WithBranchTemplate(tmpl string) PushOptions {
+	return func(c *PushConfig) {
+		c.BranchTemplate = tmpl
+	}
+}
+
+// WithBranchCollision sets what to do when the feature branch already exists on the remote,
+// either store.BranchCollisionSuffix or store.BranchCollisionReuse.
+func WithBranchCollision(strategy string) PushOptions {
+	return func(c *PushConfig) {
+		c.BranchCollision = strategy
+	}
+}
+
 // Push pushes the contents to the specified repository and branch.
 //
 // When the Dir field is not provided, it creates a temporary directory to store the git repository.
@@ -68,36 +281,88 @@ func WithKustomizeBin(bin string) PushOptions {
 //
 // The Auth field is required. Set a valid GitHub token to the Password field.
 //
-// The Subject field is the commit message subject. If not provided, it uses the branch name.
-func Push(r Contents, repo, branch string, opts ...PushOptions) error {
+// It returns the result describing the commit, the pull request, and the changed files.
+// When the contents are identical to the branch, it returns the result with NoChanges set,
+// along with an error that wraps ErrNoChanges. Use errors.Is to tell it from the other errors.
+//
+// The Subject and Body fields are the templates of the commit message.
+// If not provided, they are read from the `$commit` section of the contents,
+// or DefaultCommitSubjectTemplate and DefaultCommitBodyTemplate are used.
+func Push(r Contents, repo, branch string, opts ...PushOptions) (*PushResult, error) {
+	return push(func(string, ...LoadOption) (*Contents, error) { return &r, nil }, &r, repo, branch, opts...)
+}
+
+// RenderFunc renders the contents given the worktree of the target branch,
+// so that the contents can be computed from the files in the repository.
+// opts is the load options derived from the PushOptions, like DecryptionKeys with the keys given by WithSOPSKeys.
+type RenderFunc func(repoDir string, opts ...LoadOption) (*Contents, error)
+
+// PushFunc is like Push, but renders the contents after cloning the repository.
+//
+// The render function is called with the worktree of the target branch,
+// and called again on top of the updated branch when the push is retried.
+// Use RenderFileFunc to render a jsonnet file that imports files from the repository via `gitimpart://path`.
+//
+// As the directories the contents touch are unknown before the clone,
+// WithSparseCheckout without directories checks out the whole repository.
+func PushFunc(render RenderFunc, repo, branch string, opts ...PushOptions) (*PushResult, error) {
+	return push(render, nil, repo, branch, opts...)
+}
+
+// push renders the contents in the clone of the repository, and commits and pushes them.
+// known is the contents rendered in advance, if any, which is used to derive the sparse checkout directories.
+func push(render RenderFunc, known *Contents, repo, branch string, opts ...PushOptions) (*PushResult, error) {
 	var c PushConfig
 	for _, o := range opts {
 		o(&c)
 	}
 
 	if c.Auth.Password == "" {
-		return fmt.Errorf("CommitConfig.Auth.Password is required. Set a valid GitHub token to CommitConfig.Auth.Password")
+		return nil, fmt.Errorf("CommitConfig.Auth.Password is required. Set a valid GitHub token to CommitConfig.Auth.Password")
 	}
 
-	tm := time.Now()
+	redactor, err := c.redactor()
+	if err != nil {
+		return nil, err
+	}
 
-	name := tm.Format("20060102150405")
-	newBranch := fmt.Sprintf("gitimpart-%s", name)
+	values, err := newValueMasker()
+	if err != nil {
+		return nil, err
+	}
+
+	masker, err := newSecretMasker(c.Redaction, values)
+	if err != nil {
+		return nil, err
+	}
 
-	dir := c.Dir
-	if dir == "" {
+	var signingKey *store.SigningKey
+	if len(c.SigningKey) > 0 {
 		var err error
-		dir, err = os.MkdirTemp("", newBranch)
+		signingKey, err = store.ParseSigningKey(c.SigningKey, c.SigningKeyPassphrase)
 		if err != nil {
-			return fmt.Errorf("unable to create temp dir: %w", err)
+			return nil, err
+		}
+	}
+
+	gitRoot := c.CacheDir
+	if gitRoot == "" {
+		dir := c.Dir
+		if dir == "" {
+			var err error
+			dir, err = os.MkdirTemp("", "gitimpart")
+			if err != nil {
+				return nil, fmt.Errorf("unable to create temp dir: %w", err)
+			}
+
+			defer os.RemoveAll(dir)
 		}
 
-		defer os.RemoveAll(dir)
+		gitRoot = filepath.Join(dir, ".gitimpart", "gitroot")
 	}
 
-	gitRoot := filepath.Join(dir, ".gitimpart", "gitroot")
 	if err := os.MkdirAll(gitRoot, 0755); err != nil {
-		return fmt.Errorf("unable to create git root: %w", err)
+		return nil, fmt.Errorf("unable to create git root: %w", err)
 	}
 
 	var s store.Store
@@ -105,13 +370,42 @@ func Push(r Contents, repo, branch string, opts ...PushOptions) error {
 	g := store.NewGit(
 		c.Auth,
 		branch,
-		newBranch,
+		"",
 		repo,
-		"test author", "test@example.com",
+		"", "",
 		gitRoot,
 		true,
 	)
+	defer g.Close()
 	g.DryRun = c.DryRun
+	g.DryRunOutput = c.DryRunOutput
+	g.DiffFormat = c.DiffFormat
+	g.DiffColor = c.DiffColor
+	g.Backend = c.GitBackend
+	g.SigningKey = signingKey
+	g.MaxRetries = c.MaxRetries
+	g.Depth = c.Depth
+	g.SingleBranch = c.SingleBranch
+	g.Filter = c.Filter
+	if c.SparseCheckout {
+		g.SparseCheckoutDirectories = c.SparseCheckoutDirectories
+		if len(g.SparseCheckoutDirectories) == 0 && known != nil {
+			g.SparseCheckoutDirectories = known.Dirs()
+		}
+	}
+	g.RetryBackoff = c.RetryBackoff
+	g.TextConv = sopsTextConv(c.sopsKeys, values)
+	if masker != nil {
+		g.TextConv = chainTextConv(g.TextConv, masker.textConv)
+	}
+	g.Redactor = redactor
+
+	g.BranchTemplate = c.BranchTemplate
+	if g.BranchTemplate == "" {
+		g.BranchTemplate = store.DefaultBranchTemplate
+	}
+	g.BranchVars = c.TemplateVars
+	g.BranchCollision = c.BranchCollision
 
 	if c.SendPullRequest {
 		s = &store.PullRequest{
@@ -123,125 +417,232 @@ func Push(r Contents, repo, branch string, opts ...PushOptions) error {
 		s = g
 	}
 
-	_, err := s.Transact(func(dir string) (*store.RenderResult, error) {
-		var updates []string
-		for name, content := range r.Files {
-			p := filepath.Join(dir, name)
-			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
-				return nil, fmt.Errorf("mkdir error: %w", err)
-			}
+	var loadOpts []LoadOption
+	if c.SOPSKeys != nil {
+		loadOpts = append(loadOpts, DecryptionKeys(*c.SOPSKeys))
+	}
 
-			switch content := content.(type) {
-			case string:
-				if err := os.WriteFile(p, []byte(content), 0644); err != nil {
-					return nil, fmt.Errorf("write error: %w", err)
-				}
-			default:
-				switch filepath.Ext(name) {
-				case ".json":
-					b, err := json.Marshal(content)
-					if err != nil {
-						return nil, fmt.Errorf("marshal error: %w", err)
-					}
-					if err := os.WriteFile(p, b, 0644); err != nil {
-						return nil, fmt.Errorf("write error: %w", err)
-					}
-				case ".yaml", ".yml":
-					b, err := yaml.Marshal(content)
-					if err != nil {
-						return nil, fmt.Errorf("marshal error: %w", err)
-					}
-					if err := os.WriteFile(p, b, 0644); err != nil {
-						return nil, fmt.Errorf("write error: %w", err)
-					}
-				default:
-					return nil, fmt.Errorf("unsupported file type: %s", name)
-				}
-			}
+	var (
+		r      *Contents
+		commit Commit
+	)
 
-			updates = append(updates, name)
+	rendered, err := s.Transact(func(dir string) (*store.RenderResult, error) {
+		var err error
+		r, err = render(dir, loadOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to render contents: %w", err)
 		}
 
-		var kustomizeBinAbs string
+		// The paths are checked before anything is read from or written into the clone.
+		if err := r.validatePaths(); err != nil {
+			return nil, fmt.Errorf("invalid contents:\n%w", err)
+		}
 
-		for kDir, files := range r.Kustomize {
-			if kustomizeBinAbs == "" {
-				kustomizeBin := "kustomize"
-				if c.KustomizeBin != "" {
-					kustomizeBin = c.KustomizeBin
-				}
-				kustomizeBin, err := filepath.Abs(kustomizeBin)
-				if err != nil {
-					return nil, fmt.Errorf("unable to get absolute path to kustomize binary %s: %w", kustomizeBin, err)
-				}
-				kustomizeBinAbs, err = exec.LookPath(kustomizeBin)
-				if err != nil {
-					return nil, fmt.Errorf("unable to find kustomize binary: %w", err)
-				}
+		if c.Schemas != nil {
+			if err := r.ValidateSchemas(*c.Schemas); err != nil {
+				return nil, fmt.Errorf("invalid contents:\n%w", err)
 			}
+		}
 
-			thisDir := filepath.Join(dir, kDir)
-
-			// Executes kustomize edit add resource for each file in files and adds the kustomization.yaml as updated
-			// file.
-			for name := range files {
-				// Create the directory that should contain the kustomization.yaml file,
-				// if it does not exist.
-				if stat, err := os.Stat(thisDir); err != nil {
-					if os.IsNotExist(err) {
-						if err := os.MkdirAll(thisDir, 0755); err != nil {
-							return nil, fmt.Errorf("mkdir error: %w", err)
-						}
-					} else {
-						return nil, fmt.Errorf("stat error: %w", err)
-					}
-				} else if !stat.IsDir() {
-					return nil, fmt.Errorf("not a directory: %s", thisDir)
-				}
+		// The identities and the trailers are configured on each render,
+		// so that the `$commit` section of the contents re-rendered on retry takes effect.
+		commit = commitConfig(c, *r)
+		commit.applyTo(g)
 
-				// Create the kustomization.yaml file, if it does not exist.
-				// Otherwise kustomize-edit-add-resource fails with:
-				//   Error: Missing kustomization file 'kustomization.yaml'.
-				if _, err := os.Stat(filepath.Join(thisDir, "kustomization.yaml")); err != nil {
-					if os.IsNotExist(err) {
-						if err := os.WriteFile(filepath.Join(thisDir, "kustomization.yaml"), []byte("resources:\n"), 0644); err != nil {
-							return nil, fmt.Errorf("write error: %w", err)
-						}
-					} else {
-						return nil, fmt.Errorf("stat error: %w", err)
-					}
-				}
+		// The policy checks the files as they are committed, that is, encrypted.
+		files := *r
+		if c.Encryption != nil || len(r.Encrypt) > 0 {
+			keys, err := c.sopsKeys()
+			if err != nil {
+				return nil, err
+			}
 
-				kustomizeEdit := exec.Command(kustomizeBinAbs, "edit", "add", "resource", name)
-				kustomizeEdit.Dir = thisDir
-				combined, err := kustomizeEdit.CombinedOutput()
-				if err != nil {
-					return nil, fmt.Errorf("kustomize edit error: %w, %s", err, combined)
-				}
+			files, err = encryptContents(*r, c.Encryption, dir, keys)
+			if err != nil {
+				return nil, fmt.Errorf("unable to encrypt contents:\n%w", err)
 			}
-			updates = append(updates, filepath.Join(kDir, "kustomization.yaml"))
 		}
 
-		return &store.RenderResult{
-			AddedOrModifiedFiles: updates,
-		}, nil
+		if c.Policy == nil {
+			return writeContents(files, dir, c.KustomizeBin)
+		}
+
+		changed, err := changedFiles(files, dir)
+		if err != nil {
+			return nil, err
+		}
+
+		written, err := writeContents(files, dir, c.KustomizeBin)
+		if err != nil {
+			return nil, err
+		}
+
+		// The files written by kustomize, like kustomization.yaml, are checked as changed, too.
+		for _, f := range written.AddedOrModifiedFiles {
+			name := filepath.ToSlash(f)
+			if _, ok := files.Files[name]; ok {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(dir, f))
+			if err != nil {
+				return nil, fmt.Errorf("unable to read %s: %w", f, err)
+			}
+			changed[name] = b
+		}
+
+		// The files already written are discarded by the store when the policy rejects them.
+		if err := CheckPolicy(*c.Policy, PolicyInput{
+			Team:        c.Team,
+			PullRequest: c.SendPullRequest,
+			Changed:     changed,
+			Deleted:     written.DeletedFiles,
+		}); err != nil {
+			return nil, err
+		}
+
+		return written, nil
 	})
 	if err != nil {
-		return fmt.Errorf("unable to transact: %w", err)
+		return nil, fmt.Errorf("unable to transact: %w", err)
+	}
+
+	sourceCommit := c.SourceCommit
+	if sourceCommit == "" {
+		sourceCommit = os.Getenv("GITHUB_SHA")
+	}
+
+	data := newCommitTemplateData(repo, branch, rendered, c.TemplateVars, sourceCommit)
+	data.Source = c.SourceFile
+
+	subject, body, err := commit.message(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render commit message: %w", err)
+	}
+
+	if pr, ok := s.(*store.PullRequest); ok {
+		pr.BodyFunc = func(cr *store.CommitResult) (string, error) {
+			return pullRequestBody(c.PullRequestBody, data, cr)
+		}
+	}
+
+	result := &PushResult{
+		Repo:   repo,
+		Branch: branch,
+		DryRun: c.DryRun,
 	}
 
 	ctx := context.Background()
-	subject := c.Subject
-	if subject == "" {
-		subject = newBranch
+	if err := s.Commit(ctx, subject, body); errors.Is(err, ErrNoChanges) {
+		result.NoChanges = true
+		return result, fmt.Errorf("unable to commit: %w", err)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to commit: %w", err)
 	}
-	body := c.Body
-	if body == "" {
-		body = "test"
+
+	if cr := g.LastCommit(); cr != nil {
+		result.Ref = cr.Ref
+		result.Commit = cr.Hash
+		result.Base = cr.Base
+		result.Pushed = cr.Pushed
+		result.Files = cr.Files
+		result.Diff = cr.Diff
 	}
-	if err := s.Commit(ctx, subject, body); err != nil {
-		return fmt.Errorf("unable to commit: %w", err)
+
+	if pr, ok := s.(*store.PullRequest); ok && pr.Number != 0 {
+		result.PullRequest = &PullRequestResult{
+			Number: pr.Number,
+			URL:    pr.URL,
+		}
+	}
+
+	return result, nil
+}
+
+func writeContents(r Contents, dir, kustomizeBin string) (*store.RenderResult, error) {
+	if err := r.validatePaths(); err != nil {
+		return nil, fmt.Errorf("invalid contents:\n%w", err)
+	}
+
+	var updates []string
+	for name, content := range r.Files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return nil, fmt.Errorf("mkdir error: %w", err)
+		}
+
+		b, err := marshalFile(name, content)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.WriteFile(p, b, 0644); err != nil {
+			return nil, fmt.Errorf("write error: %w", err)
+		}
+
+		updates = append(updates, name)
+	}
+
+	var kustomizeBinAbs string
+
+	for kDir, files := range r.Kustomize {
+		if kustomizeBinAbs == "" {
+			if kustomizeBin == "" {
+				kustomizeBin = "kustomize"
+			}
+			kustomizeBin, err := filepath.Abs(kustomizeBin)
+			if err != nil {
+				return nil, fmt.Errorf("unable to get absolute path to kustomize binary %s: %w", kustomizeBin, err)
+			}
+			kustomizeBinAbs, err = exec.LookPath(kustomizeBin)
+			if err != nil {
+				return nil, fmt.Errorf("unable to find kustomize binary: %w", err)
+			}
+		}
+
+		thisDir := filepath.Join(dir, kDir)
+
+		// Executes kustomize edit add resource for each file in files and adds the kustomization.yaml as updated
+		// file.
+		for name := range files {
+			// Create the directory that should contain the kustomization.yaml file,
+			// if it does not exist.
+			if stat, err := os.Stat(thisDir); err != nil {
+				if os.IsNotExist(err) {
+					if err := os.MkdirAll(thisDir, 0755); err != nil {
+						return nil, fmt.Errorf("mkdir error: %w", err)
+					}
+				} else {
+					return nil, fmt.Errorf("stat error: %w", err)
+				}
+			} else if !stat.IsDir() {
+				return nil, fmt.Errorf("not a directory: %s", thisDir)
+			}
+
+			// Create the kustomization.yaml file, if it does not exist.
+			// Otherwise kustomize-edit-add-resource fails with:
+			//   Error: Missing kustomization file 'kustomization.yaml'.
+			if _, err := os.Stat(filepath.Join(thisDir, "kustomization.yaml")); err != nil {
+				if os.IsNotExist(err) {
+					if err := os.WriteFile(filepath.Join(thisDir, "kustomization.yaml"), []byte("resources:\n"), 0644); err != nil {
+						return nil, fmt.Errorf("write error: %w", err)
+					}
+				} else {
+					return nil, fmt.Errorf("stat error: %w", err)
+				}
+			}
+
+			kustomizeEdit := exec.Command(kustomizeBinAbs, "edit", "add", "resource", name)
+			kustomizeEdit.Dir = thisDir
+			combined, err := kustomizeEdit.CombinedOutput()
+			if err != nil {
+				return nil, fmt.Errorf("kustomize edit error: %w, %s", err, combined)
+			}
+		}
+		updates = append(updates, filepath.Join(kDir, "kustomization.yaml"))
 	}
 
-	return nil
+	return &store.RenderResult{
+		AddedOrModifiedFiles: updates,
+	}, nil
 }
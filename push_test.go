@@ -0,0 +1,694 @@
+package gitimpart_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mumoshu/gitimpart"
+	"github.com/mumoshu/gitimpart/config"
+	"github.com/mumoshu/gitimpart/store"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestGitimpartPush_Commit(t *testing.T) {
+	r, err := gitimpart.RenderFile("testdata/commit.jsonnet")
+	require.NoError(t, err)
+
+	remote := newRemote(t)
+
+	result, err := gitimpart.Push(
+		*r,
+		remote,
+		"main",
+		gitimpart.WithGitHubToken("dummy"),
+		gitimpart.WithCacheDir(t.TempDir()),
+		gitimpart.WithCommitter("test committer", "committer@example.com"),
+		gitimpart.WithSignOff(),
+		gitimpart.WithTemplateVars(map[string]string{"app": "myapp"}),
+		gitimpart.WithSourceCommit("0123abcd"),
+	)
+	require.NoError(t, err)
+
+	c := headCommit(t, remote)
+
+	require.Equal(t, c.Hash.String(), result.Commit)
+	require.Regexp(t, `^refs/heads/gitimpart/main/[0-9a-f]{8}$`, result.Ref)
+	require.True(t, result.Pushed)
+	require.Nil(t, result.PullRequest)
+	require.Equal(t, []store.FileChange{{Path: "a.txt", Status: store.FileAdded}}, result.Files)
+
+	require.Equal(t, "jsonnet author", c.Author.Name)
+	require.Equal(t, "jsonnet@example.com", c.Author.Email)
+	require.Equal(t, "test committer", c.Committer.Name)
+	require.Equal(t, "committer@example.com", c.Committer.Email)
+	require.Equal(t, `Update myapp (1 files)
+
+Files:
+- a.txt
+
+Source commit: 0123abcd
+
+Co-authored-by: Co Author <co@example.com>
+Signed-off-by: test committer <committer@example.com>
+`, c.Message)
+}
+
+func TestGitimpartPush_PullRequestBody(t *testing.T) {
+	t.Setenv("GITHUB_SERVER_URL", "https://github.com")
+	t.Setenv("GITHUB_REPOSITORY", "org/src")
+	t.Setenv("GITHUB_SHA", "0123abcd")
+	t.Setenv("GITHUB_REF", "refs/heads/main")
+	t.Setenv("GITHUB_WORKFLOW", "deploy")
+	t.Setenv("GITHUB_RUN_ID", "42")
+	t.Setenv("GITHUB_RUN_ATTEMPT", "1")
+	t.Setenv("GITHUB_ACTOR", "someone")
+	t.Setenv("GITHUB_EVENT_NAME", "push")
+
+	r, err := gitimpart.RenderFile("testdata/commit.jsonnet")
+	require.NoError(t, err)
+
+	remote := newRemote(t)
+
+	var out bytes.Buffer
+
+	_, err = gitimpart.Push(
+		*r,
+		remote,
+		"main",
+		gitimpart.WithGitHubToken("dummy"),
+		gitimpart.WithCacheDir(t.TempDir()),
+		gitimpart.WithDryRun(),
+		gitimpart.WithDryRunOutput(&out),
+		gitimpart.WithDiffFormat(store.DiffFormatStat),
+		gitimpart.WithPullRequest(),
+		gitimpart.WithSourceFile("testdata/commit.jsonnet"),
+		gitimpart.WithTemplateVars(map[string]string{"app": "myapp"}),
+	)
+	require.NoError(t, err)
+
+	require.Contains(t, out.String(), "with the following title and body:\n\nUpdate myapp (1 files)\n\n"+`This pull request was generated by gitimpart from `+"`testdata/commit.jsonnet`"+`.
+
+### Changed files
+
+**/**
+- `+"`a.txt`"+` (added)
+
+### Variables
+
+| Name | Value |
+| --- | --- |
+| `+"`app`"+` | `+"`myapp`"+` |
+
+<details>
+<summary>Diff</summary>
+
+`+"```diff"+`
+diff --git a/a.txt b/a.txt
+--- /dev/null
++++ b/a.txt
+@@ -0,0 +1 @@
++a
+`+"```"+`
+
+</details>
+
+### Provenance
+
+- Repository: https://github.com/org/src
+- Commit: https://github.com/org/src/commit/0123abcd
+- Ref: `+"`refs/heads/main`"+`
+- Workflow: deploy
+- Run: https://github.com/org/src/actions/runs/42
+- Triggered by: @someone on push
+`)
+}
+
+func TestGitimpartPush_OutsidePaths(t *testing.T) {
+	abs := filepath.Join(t.TempDir(), "abs.txt")
+
+	for name, msg := range map[string]string{
+		"../escaped.txt": "path must not point outside of the repository",
+		abs:              "path must be relative to the repository root",
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			_, err := gitimpart.Push(
+				gitimpart.Contents{Files: map[string]interface{}{name: "x"}},
+				newRemote(t),
+				"main",
+				gitimpart.WithGitHubToken("dummy"),
+				func(c *gitimpart.PushConfig) { c.Dir = dir },
+			)
+			require.ErrorContains(t, err, fmt.Sprintf("$files[%q]: %s", name, msg))
+
+			require.NoFileExists(t, filepath.Join(dir, ".gitimpart", "escaped.txt"))
+			require.NoFileExists(t, abs)
+		})
+	}
+}
+
+func TestGitimpartPushFunc_RepoImport(t *testing.T) {
+	remote := newRemote(t, map[string]string{
+		"app/version.txt": "41\n",
+		"app/config.json": `{"replicas": 2}`,
+	})
+
+	result, err := gitimpart.PushFunc(
+		gitimpart.RenderFileFunc("testdata/repo.jsonnet"),
+		remote,
+		"main",
+		gitimpart.WithGitHubToken("dummy"),
+		gitimpart.WithCacheDir(t.TempDir()),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []store.FileChange{
+		{Path: "app/config.json", Status: store.FileModified},
+		{Path: "app/version.txt", Status: store.FileModified},
+	}, result.Files)
+
+	c := headCommit(t, remote)
+
+	f, err := c.File("app/version.txt")
+	require.NoError(t, err)
+	content, err := f.Contents()
+	require.NoError(t, err)
+	require.Equal(t, "42\n", content)
+
+	f, err = c.File("app/config.json")
+	require.NoError(t, err)
+	content, err = f.Contents()
+	require.NoError(t, err)
+	require.Equal(t, `{"missing":null,"replicas":3}`, content)
+
+	// The target repository is not available without cloning it first.
+	_, err = gitimpart.RenderFile("testdata/repo.jsonnet")
+	require.ErrorContains(t, err, "the target repository is not available")
+}
+
+func TestGitimpartPushFunc_SOPSDecrypt(t *testing.T) {
+	// The keys are not read from the environment when they are given.
+	t.Setenv("SOPS_AGE_KEY", "")
+	t.Setenv("SOPS_AGE_KEY_FILE", "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	f, err := os.Open("testdata/sops/keys.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	ids, err := age.ParseIdentities(f)
+	require.NoError(t, err)
+
+	remote := newRemote(t)
+
+	_, err = gitimpart.PushFunc(
+		gitimpart.RenderFileFunc("testdata/sops.jsonnet"),
+		remote,
+		"main",
+		gitimpart.WithGitHubToken("dummy"),
+		gitimpart.WithCacheDir(t.TempDir()),
+		gitimpart.WithSOPSKeys(gitimpart.SOPSKeys{Age: ids}),
+	)
+	require.NoError(t, err)
+
+	c, err := headCommit(t, remote).File("app/password.txt")
+	require.NoError(t, err)
+	content, err := c.Contents()
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", content)
+
+	_, err = gitimpart.RenderFile("testdata/sops.jsonnet")
+	require.ErrorContains(t, err, "sopsDecrypt")
+
+	_, err = gitimpart.RenderFile("testdata/sops.jsonnet", gitimpart.DecryptionKeys(gitimpart.SOPSKeys{Age: ids}))
+	require.NoError(t, err)
+}
+
+func TestGitimpartPush_SchemaValidation(t *testing.T) {
+	remote := newRemote(t)
+
+	r := gitimpart.Contents{
+		Files: map[string]interface{}{
+			"deploy.yaml": map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"name": "app"},
+				"spec":       map[string]interface{}{"replicas": -1},
+			},
+		},
+	}
+
+	_, err := gitimpart.Push(
+		r,
+		remote,
+		"main",
+		gitimpart.WithGitHubToken("dummy"),
+		gitimpart.WithCacheDir(t.TempDir()),
+		gitimpart.WithSchemaValidation(gitimpart.SchemaConfig{Dir: "testdata/schemas"}),
+	)
+	require.ErrorContains(t, err, `$files["deploy.yaml"]: Deployment/app: /spec/replicas: must be >= 0 but found -1`)
+
+	repo, err := git.PlainOpen(remote)
+	require.NoError(t, err)
+
+	refs, err := repo.References()
+	require.NoError(t, err)
+	require.NoError(t, refs.ForEach(func(ref *plumbing.Reference) error {
+		require.NotContains(t, ref.Name().String(), "gitimpart/")
+		return nil
+	}))
+}
+
+func TestGitimpartPush_Policy(t *testing.T) {
+	remote := newRemote(t, map[string]string{
+		"apps/a/prod/config.yaml": "replicas: 1\n",
+	})
+
+	p := config.Policy{
+		MaxChangedFiles: 1,
+		ProtectedPaths:  []string{"apps/*/prod/**"},
+	}
+
+	push := func(r gitimpart.Contents, opts ...gitimpart.PushOptions) (*gitimpart.PushResult, error) {
+		return gitimpart.Push(r, remote, "main", append([]gitimpart.PushOptions{
+			gitimpart.WithGitHubToken("dummy"),
+			gitimpart.WithCacheDir(t.TempDir()),
+			gitimpart.WithDryRun(),
+			gitimpart.WithDryRunOutput(io.Discard),
+			gitimpart.WithPolicy(p, ""),
+		}, opts...)...)
+	}
+
+	// The protected file is unchanged, so only the new file counts.
+	_, err := push(gitimpart.Contents{Files: map[string]interface{}{
+		"apps/a/prod/config.yaml": map[string]interface{}{"replicas": 1},
+		"apps/a/dev/config.yaml":  map[string]interface{}{"replicas": 1},
+	}})
+	require.NoError(t, err)
+
+	_, err = push(gitimpart.Contents{Files: map[string]interface{}{
+		"apps/a/prod/config.yaml": map[string]interface{}{"replicas": 2},
+	}})
+	require.ErrorIs(t, err, gitimpart.ErrPolicyViolation)
+	require.ErrorContains(t, err, `$files["apps/a/prod/config.yaml"]: the path is protected and must be changed via a pull request`)
+
+	_, err = push(gitimpart.Contents{Files: map[string]interface{}{
+		"apps/a/prod/config.yaml": map[string]interface{}{"replicas": 2},
+	}}, gitimpart.WithPullRequest())
+	require.NoError(t, err)
+}
+
+func TestGitimpartPush_Encryption(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	e, err := openpgp.NewEntity("gitimpart", "", "gitimpart@example.com", nil)
+	require.NoError(t, err)
+
+	var pub bytes.Buffer
+	w, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, e.Serialize(w))
+	require.NoError(t, w.Close())
+
+	enc := config.Encryption{
+		SOPSOptions: config.SOPSOptions{Age: []string{id.Recipient().String()}},
+		Rules: []config.EncryptionRule{
+			{Path: "**/secret.yaml", SOPSOptions: config.SOPSOptions{EncryptedRegex: "^(data|stringData)$"}},
+		},
+	}
+	ageKeys := gitimpart.SOPSKeys{Age: []age.Identity{id}}
+
+	contents := func(password string) gitimpart.Contents {
+		return gitimpart.Contents{
+			Files: map[string]interface{}{
+				"app/secret.yaml": map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "Secret",
+					"metadata":   map[string]interface{}{"name": "db"},
+					"stringData": map[string]interface{}{"password": password, "port": float64(5432)},
+				},
+				"app/config.json": `{"token": "t0ken", "debug": true}`,
+				"app/plain.yaml":  map[string]interface{}{"replicas": 1},
+			},
+			Encrypt: map[string]*gitimpart.FileEncryption{
+				"app/config.json": {PGP: []string{pub.String()}},
+			},
+		}
+	}
+
+	remote := newRemote(t)
+
+	_, err = gitimpart.Push(
+		contents("s3cret"),
+		remote,
+		"main",
+		gitimpart.WithGitHubToken("dummy"),
+		gitimpart.WithCacheDir(t.TempDir()),
+		gitimpart.WithEncryption(enc),
+		gitimpart.WithSOPSKeys(ageKeys),
+	)
+	require.NoError(t, err)
+
+	c := headCommit(t, remote)
+
+	files := map[string]string{}
+	for _, name := range []string{"app/secret.yaml", "app/config.json", "app/plain.yaml"} {
+		f, err := c.File(name)
+		require.NoError(t, err)
+		files[name], err = f.Contents()
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, "replicas: 1\n", files["app/plain.yaml"])
+
+	secret := files["app/secret.yaml"]
+	require.NotContains(t, secret, "s3cret")
+	require.Contains(t, secret, "\n    name: db\n")
+	require.Regexp(t, `password: ENC\[AES256_GCM,data:[^,]+,iv:[^,]+,tag:[^,]+,type:str\]`, secret)
+	require.Regexp(t, `port: ENC\[AES256_GCM,data:[^,]+,iv:[^,]+,tag:[^,]+,type:int\]`, secret)
+	require.Contains(t, secret, "\n    encrypted_regex: ^(data|stringData)$\n")
+	require.Contains(t, secret, "\n        - recipient: "+id.Recipient().String()+"\n")
+
+	b, err := gitimpart.DecryptSOPS("app/secret.yaml", []byte(secret), ageKeys)
+	require.NoError(t, err)
+	require.Equal(t, `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: s3cret
+  port: 5432
+`, string(b))
+
+	require.NotContains(t, files["app/config.json"], "t0ken")
+	require.Contains(t, files["app/config.json"], `"fp": "`+fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)+`"`)
+
+	_, err = gitimpart.DecryptSOPS("app/config.json", []byte(files["app/config.json"]), ageKeys)
+	require.ErrorContains(t, err, "no key for any of the recipients is available")
+
+	b, err = gitimpart.DecryptSOPS("app/config.json", []byte(files["app/config.json"]), gitimpart.SOPSKeys{PGP: openpgp.EntityList{e}})
+	require.NoError(t, err)
+	require.Equal(t, "{\n\t\"token\": \"t0ken\",\n\t\"debug\": true\n}\n", string(b))
+
+	// The MAC covers the unencrypted values, too.
+	_, err = gitimpart.DecryptSOPS("app/secret.yaml", []byte(strings.Replace(secret, "name: db", "name: other", 1)), ageKeys)
+	require.ErrorContains(t, err, "the MAC does not match")
+
+	// The files encrypted on the branch are kept as they are when their contents are unchanged.
+	remote = newRemote(t, files)
+
+	dryRun := func(password string) (string, error) {
+		var out bytes.Buffer
+		_, err := gitimpart.Push(
+			contents(password),
+			remote,
+			"main",
+			gitimpart.WithGitHubToken("dummy"),
+			gitimpart.WithCacheDir(t.TempDir()),
+			gitimpart.WithDryRun(),
+			gitimpart.WithDryRunOutput(&out),
+			gitimpart.WithEncryption(enc),
+			gitimpart.WithSOPSKeys(gitimpart.SOPSKeys{Age: []age.Identity{id}, PGP: openpgp.EntityList{e}}),
+		)
+		return out.String(), err
+	}
+
+	_, err = dryRun("s3cret")
+	require.ErrorIs(t, err, gitimpart.ErrNoChanges)
+
+	// The diff shows the changed values masked.
+	out, err := dryRun("n3w")
+	require.NoError(t, err)
+	require.NotContains(t, out, "s3cret")
+	require.NotContains(t, out, "n3w")
+	require.Regexp(t, `(?m)^-  password: <encrypted:[0-9a-f]{8}>\n\+  password: <encrypted:[0-9a-f]{8}>$`, out)
+	require.NotContains(t, out, "-  port:")
+	require.NotContains(t, out, "ENC[")
+}
+
+func TestGitimpartPush_Redaction(t *testing.T) {
+	t.Setenv("DEPLOY_PASSWORD", "hunter2-password")
+
+	ghToken := "ghp_" + strings.Repeat("a", 36)
+
+	remote := newRemote(t, map[string]string{
+		"app/secret.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: old-password
+`,
+	})
+
+	r := gitimpart.Contents{
+		Files: map[string]interface{}{
+			"app/secret.yaml": map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]interface{}{"name": "db"},
+				"stringData": map[string]interface{}{"password": "new-password"},
+			},
+			"app/config.json": map[string]interface{}{
+				"api": map[string]interface{}{"apiToken": "t0ken-value", "url": "https://example.com"},
+			},
+			"app/env.txt": "TOKEN=" + ghToken + "\nPASSWORD=hunter2-password\n",
+		},
+	}
+
+	var out bytes.Buffer
+
+	_, err := gitimpart.Push(
+		r,
+		remote,
+		"main",
+		gitimpart.WithGitHubToken("dummy-github-token"),
+		gitimpart.WithCacheDir(t.TempDir()),
+		gitimpart.WithDryRun(),
+		gitimpart.WithDryRunOutput(&out),
+		gitimpart.WithPullRequest(),
+		gitimpart.WithTemplateVars(map[string]string{"token": "dummy-github-token"}),
+		gitimpart.WithRedaction(config.Redaction{
+			Keys: []string{`(?i)token$`},
+			Env:  []string{"DEPLOY_PASSWORD"},
+		}),
+	)
+	require.NoError(t, err)
+
+	s := out.String()
+	for _, secret := range []string{"old-password", "new-password", "t0ken-value", ghToken, "hunter2-password", "dummy-github-token"} {
+		require.NotContains(t, s, secret)
+	}
+
+	// The diff and the pull request body both show the masked values.
+	require.Regexp(t, `(?m)^-  password: <redacted:[0-9a-f]{8}>\n\+  password: <redacted:[0-9a-f]{8}>$`, s)
+	require.Regexp(t, `(?m)^\+\t\t"apiToken": "<redacted:[0-9a-f]{8}>",$`, s)
+	require.Contains(t, s, `"url": "https://example.com"`)
+	require.Contains(t, s, "+TOKEN=<redacted>\n+PASSWORD=<redacted>\n")
+	require.Contains(t, s, "| `token` | `<redacted>` |")
+	require.Equal(t, 2, strings.Count(s, "-  password: <redacted:"))
+	require.Regexp(t, `stringData.password: "<redacted:[0-9a-f]{8}>" → "<redacted:[0-9a-f]{8}>"`, s)
+}
+
+func TestGitimpartPush_EncryptionCompatibility(t *testing.T) {
+	// The fixtures are encrypted by sops 3.8.1 for the age key in testdata/sops/keys.txt:
+	//   sops --encrypt --age RECIPIENT --encrypted-regex '^(data|stringData)$' secret.yaml > secret.enc.yaml
+	//   sops --encrypt --age RECIPIENT config.json > config.enc.json
+	f, err := os.Open("testdata/sops/keys.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	ids, err := age.ParseIdentities(f)
+	require.NoError(t, err)
+	keys := gitimpart.SOPSKeys{Age: ids}
+
+	plain := map[string]string{
+		"secret.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+stringData:
+  password: s3cret
+  port: 5432
+  tls: true
+`,
+		"config.json": "{\n\t\"token\": \"t0ken\",\n\t\"retries\": 3,\n\t\"ratio\": 0.5,\n\t\"debug\": true,\n\t\"hosts\": [\n\t\t\"a\",\n\t\t\"b\"\n\t]\n}\n",
+	}
+
+	fixtures := map[string]string{}
+	for name, want := range plain {
+		enc := strings.Replace(name, ".", ".enc.", 1)
+
+		b, err := os.ReadFile(filepath.Join("testdata", "sops", enc))
+		require.NoError(t, err)
+		fixtures[name] = string(b)
+
+		got, err := gitimpart.DecryptSOPS(enc, b, keys)
+		require.NoError(t, err)
+		require.Equal(t, want, string(got))
+	}
+
+	// The MAC computed by sops covers the unencrypted values, too.
+	_, err = gitimpart.DecryptSOPS("secret.enc.yaml", []byte(strings.Replace(fixtures["secret.yaml"], "name: db", "name: other", 1)), keys)
+	require.ErrorContains(t, err, "the MAC does not match")
+
+	remote := newRemote(t)
+
+	_, err = gitimpart.Push(
+		gitimpart.Contents{Files: map[string]interface{}{
+			"app/secret.yaml": plain["secret.yaml"],
+			"app/config.json": plain["config.json"],
+		}},
+		remote,
+		"main",
+		gitimpart.WithGitHubToken("dummy"),
+		gitimpart.WithCacheDir(t.TempDir()),
+		gitimpart.WithEncryption(config.Encryption{
+			SOPSOptions: config.SOPSOptions{Age: []string{ids[0].(*age.X25519Identity).Recipient().String()}},
+			Rules: []config.EncryptionRule{
+				{Path: "**/secret.yaml", SOPSOptions: config.SOPSOptions{EncryptedRegex: "^(data|stringData)$"}},
+				{Path: "**/config.json"},
+			},
+		}),
+		gitimpart.WithSOPSKeys(keys),
+	)
+	require.NoError(t, err)
+
+	c := headCommit(t, remote)
+
+	for name, want := range plain {
+		f, err := c.File("app/" + name)
+		require.NoError(t, err)
+		ours, err := f.Contents()
+		require.NoError(t, err)
+
+		got, err := gitimpart.DecryptSOPS(name, []byte(ours), keys)
+		require.NoError(t, err)
+		require.Equal(t, want, string(got))
+
+		// The same values are encrypted with the same types, and the metadata has the same keys in the same order.
+		require.Equal(t, sopsShape(t, fixtures[name]), sopsShape(t, ours), name)
+	}
+}
+
+var sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:[^,]*,iv:[^,]+,tag:[^,]+,type:(\w+)\]$`)
+
+// sopsShape returns the SOPS file with the encrypted values, the encrypted data keys, and the timestamp masked,
+// and without the empty key groups sops writes, so that the files encrypted by different runs can be compared.
+func sopsShape(t *testing.T, s string) yaml.MapSlice {
+	t.Helper()
+
+	var doc yaml.MapSlice
+	require.NoError(t, yaml.Unmarshal([]byte(strings.ReplaceAll(s, "\t", "  ")), &doc))
+
+	var mask func(v interface{}) interface{}
+	mask = func(v interface{}) interface{} {
+		switch t := v.(type) {
+		case yaml.MapSlice:
+			out := yaml.MapSlice{}
+			for _, item := range t {
+				switch item.Key {
+				case "enc":
+					item.Value = "<enc>"
+				case "lastmodified":
+					item.Value = "<lastmodified>"
+				}
+				if l, ok := item.Value.([]interface{}); item.Value == nil || ok && len(l) == 0 {
+					continue
+				}
+				out = append(out, yaml.MapItem{Key: item.Key, Value: mask(item.Value)})
+			}
+			return out
+		case []interface{}:
+			out := make([]interface{}, len(t))
+			for i, item := range t {
+				out[i] = mask(item)
+			}
+			return out
+		case string:
+			return sopsValuePattern.ReplaceAllString(t, "ENC[type:$1]")
+		default:
+			return v
+		}
+	}
+
+	return mask(doc).(yaml.MapSlice)
+}
+
+// newRemote creates a bare repository with a commit of the files on the main branch.
+func newRemote(t *testing.T, files ...map[string]string) string {
+	t.Helper()
+
+	src := t.TempDir()
+
+	r, err := git.PlainInitWithOptions(src, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.Main},
+	})
+	require.NoError(t, err)
+
+	w, err := r.Worktree()
+	require.NoError(t, err)
+
+	for _, fs := range files {
+		for name, content := range fs {
+			p := filepath.Join(src, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+			require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+			_, err := w.Add(name)
+			require.NoError(t, err)
+		}
+	}
+
+	_, err = w.Commit("initial commit", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "someone", Email: "someone@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+
+	_, err = git.PlainClone(remote, true, &git.CloneOptions{URL: src})
+	require.NoError(t, err)
+
+	return remote
+}
+
+// headCommit returns the commit at the head of the branch Push created on the remote.
+func headCommit(t *testing.T, remote string) *object.Commit {
+	t.Helper()
+
+	r, err := git.PlainOpen(remote)
+	require.NoError(t, err)
+
+	branches, err := r.Branches()
+	require.NoError(t, err)
+
+	var c *object.Commit
+	require.NoError(t, branches.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() == plumbing.Main {
+			return nil
+		}
+
+		var err error
+		c, err = r.CommitObject(ref.Hash())
+		return err
+	}))
+	require.NotNil(t, c, "no branch has been pushed")
+
+	return c
+}
@@ -0,0 +1,307 @@
+package gitimpart
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mumoshu/gitimpart/config"
+	"github.com/mumoshu/gitimpart/store"
+	"gopkg.in/yaml.v2"
+)
+
+// WithRedaction configures how Push masks the secrets in the dry-run diffs, the diffs in PushResult, and the pull request.
+//
+// Without it, the values of Kubernetes Secrets, the GitHub token, and the credentials of the known formats are masked.
+func WithRedaction(r config.Redaction) PushOptions {
+	return func(c *PushConfig) {
+		c.Redaction = &r
+	}
+}
+
+// redactor returns the redactor masking the GitHub token, the credentials of the known formats,
+// and the strings selected by the Redaction wherever they appear.
+func (c PushConfig) redactor() (*store.Redactor, error) {
+	r := &store.Redactor{
+		Patterns: append([]*regexp.Regexp{}, store.CredentialPatterns...),
+	}
+
+	if c.Auth != nil {
+		r.Secrets = append(r.Secrets, c.Auth.Password)
+	}
+
+	if c.Redaction == nil {
+		return r, nil
+	}
+
+	for _, name := range c.Redaction.Env {
+		if v := os.Getenv(name); v != "" {
+			r.Secrets = append(r.Secrets, v)
+		}
+	}
+
+	for _, p := range c.Redaction.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		r.Patterns = append(r.Patterns, re)
+	}
+
+	return r, nil
+}
+
+// valueMasker masks the values in the diffs by their keyed hashes, like `<redacted:0123abcd>`,
+// so that the changed values can be told without revealing them.
+// The key is generated for each push, so that the masks cannot be compared across runs.
+type valueMasker []byte
+
+func newValueMasker() (valueMasker, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("unable to generate the masking key: %w", err)
+	}
+
+	return key, nil
+}
+
+// mask returns the mask of the value at the path in the document, labeled like `<label:0123abcd>`.
+func (k valueMasker) mask(label string, path []string, v interface{}) string {
+	h := hmac.New(sha256.New, k)
+	fmt.Fprintf(h, "%q %T %v", path, v, v)
+
+	return fmt.Sprintf("<%s:%x>", label, h.Sum(nil)[:4])
+}
+
+// secretMasker masks the values of Kubernetes Secrets and the values under the keys matching the patterns
+// in YAML and JSON files, like `<redacted:0123abcd>`.
+type secretMasker struct {
+	secretData bool
+	keys       []*regexp.Regexp
+	values     valueMasker
+}
+
+// newSecretMasker returns the masker configured by the Redaction, or nil when there is nothing to mask.
+func newSecretMasker(r *config.Redaction, values valueMasker) (*secretMasker, error) {
+	m := &secretMasker{secretData: true, values: values}
+
+	if r != nil {
+		m.secretData = r.MaskSecretData()
+
+		for _, k := range r.Keys {
+			re, err := regexp.Compile(k)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redaction key pattern %q: %w", k, err)
+			}
+			m.keys = append(m.keys, re)
+		}
+	}
+
+	if !m.secretData && len(m.keys) == 0 {
+		return nil, nil
+	}
+
+	return m, nil
+}
+
+// textConv shows the YAML and JSON files in the diffs with the secrets masked.
+// The files without any secret, and the ones that cannot be parsed, are shown as they are.
+func (m *secretMasker) textConv(name string, b []byte) ([]byte, error) {
+	switch filepath.Ext(name) {
+	case ".json":
+		v, err := parseOrderedJSON(b)
+		if err != nil {
+			return nil, nil
+		}
+
+		doc, ok := v.(yaml.MapSlice)
+		if !ok || !m.redactDocument(doc) {
+			return nil, nil
+		}
+
+		return marshalOrdered(name, doc)
+	case ".yaml", ".yml":
+		var (
+			docs   []yaml.MapSlice
+			masked bool
+		)
+
+		d := yaml.NewDecoder(bytes.NewReader(b))
+		for {
+			var doc yaml.MapSlice
+			if err := d.Decode(&doc); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, nil
+			}
+
+			if m.redactDocument(doc) {
+				masked = true
+			}
+			docs = append(docs, doc)
+		}
+
+		if !masked {
+			return nil, nil
+		}
+
+		var buf bytes.Buffer
+		for i, doc := range docs {
+			if i > 0 {
+				buf.WriteString("---\n")
+			}
+
+			out, err := yaml.Marshal(doc)
+			if err != nil {
+				return nil, fmt.Errorf("marshal error: %w", err)
+			}
+			buf.Write(out)
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	return nil, nil
+}
+
+// redactDocument masks the secrets in the document in place, returning whether any value is masked.
+func (m *secretMasker) redactDocument(doc yaml.MapSlice) bool {
+	secret := m.secretData && isKubernetesSecret(doc)
+
+	var masked bool
+
+	for i, item := range doc {
+		k := fmt.Sprint(item.Key)
+
+		var ok bool
+		if secret && (k == "data" || k == "stringData") || m.matchKey(k) {
+			doc[i].Value, ok = m.mask([]string{k}, item.Value)
+		} else {
+			doc[i].Value, ok = m.walk([]string{k}, item.Value)
+		}
+		masked = masked || ok
+	}
+
+	return masked
+}
+
+// walk masks the values under the keys matching the patterns.
+func (m *secretMasker) walk(path []string, v interface{}) (interface{}, bool) {
+	var masked bool
+
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		for i, item := range t {
+			k := fmt.Sprint(item.Key)
+			p := append(path[:len(path):len(path)], k)
+
+			var ok bool
+			if m.matchKey(k) {
+				t[i].Value, ok = m.mask(p, item.Value)
+			} else {
+				t[i].Value, ok = m.walk(p, item.Value)
+			}
+			masked = masked || ok
+		}
+	case []interface{}:
+		for i, item := range t {
+			var ok bool
+			t[i], ok = m.walk(append(path[:len(path):len(path)], strconv.Itoa(i)), item)
+			masked = masked || ok
+		}
+	}
+
+	return v, masked
+}
+
+// mask masks all the values in v.
+// The empty strings and the values already masked by sopsTextConv are kept, as they reveal nothing.
+func (m *secretMasker) mask(path []string, v interface{}) (interface{}, bool) {
+	var masked bool
+
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		for i, item := range t {
+			var ok bool
+			t[i].Value, ok = m.mask(append(path[:len(path):len(path)], fmt.Sprint(item.Key)), item.Value)
+			masked = masked || ok
+		}
+		return t, masked
+	case []interface{}:
+		for i, item := range t {
+			var ok bool
+			t[i], ok = m.mask(append(path[:len(path):len(path)], strconv.Itoa(i)), item)
+			masked = masked || ok
+		}
+		return t, masked
+	case nil:
+		return v, false
+	case string:
+		if t == "" || strings.HasPrefix(t, "<encrypted:") {
+			return v, false
+		}
+	}
+
+	return m.values.mask("redacted", path, v), true
+}
+
+func (m *secretMasker) matchKey(k string) bool {
+	for _, re := range m.keys {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isKubernetesSecret(doc yaml.MapSlice) bool {
+	var apiVersion, kind interface{}
+	for _, item := range doc {
+		switch item.Key {
+		case "apiVersion":
+			apiVersion = item.Value
+		case "kind":
+			kind = item.Value
+		}
+	}
+
+	return apiVersion == "v1" && kind == "Secret"
+}
+
+// chainTextConv converts the contents with the TextConvs in order, each converting the output of the previous one.
+// The nil TextConvs are skipped.
+func chainTextConv(convs ...store.TextConv) store.TextConv {
+	return func(name string, b []byte) ([]byte, error) {
+		var converted bool
+
+		for _, conv := range convs {
+			if conv == nil {
+				continue
+			}
+
+			out, err := conv(name, b)
+			if err != nil {
+				return nil, err
+			}
+			if out != nil {
+				b, converted = out, true
+			}
+		}
+
+		if !converted {
+			return nil, nil
+		}
+
+		return b, nil
+	}
+}
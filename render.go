@@ -2,16 +2,72 @@ package gitimpart
 
 import (
 	"encoding/json"
+	"fmt"
 	"path/filepath"
+	"sort"
+
+	"github.com/google/go-jsonnet"
+	"gopkg.in/yaml.v2"
 )
 
 type Contents struct {
 	Files     map[string]interface{}            `json:"$files"`
 	Kustomize map[string]map[string]interface{} `json:"$kustomize"`
+	// Commit configures the commit made by Push.
+	Commit *Commit `json:"$commit,omitempty"`
+	// Encrypt lists the files Push encrypts with SOPS, in addition to the ones selected by WithEncryption.
+	Encrypt map[string]*FileEncryption `json:"$encrypt,omitempty"`
+}
+
+// Dirs returns the directories that contain the files to be written by the contents,
+// with "." for the root directory, which checks out the files at the root of the repository
+// as git's cone-mode sparse checkout does.
+func (c Contents) Dirs() []string {
+	seen := map[string]bool{}
+
+	add := func(dir string) {
+		seen[filepath.ToSlash(filepath.Clean(dir))] = true
+	}
+
+	for name := range c.Files {
+		add(filepath.Dir(name))
+	}
+
+	for dir := range c.Kustomize {
+		add(dir)
+	}
+
+	dirs := make([]string, 0, len(seen))
+	for dir := range seen {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	return dirs
 }
 
 type LoadConfig struct {
 	Vars map[string]string
+	// ExtCode is the ext vars whose values are jsonnet code, like `[1, 2]` or `{a: 1}`.
+	// They are available via std.extVar(name) as the values the code evaluates to.
+	ExtCode map[string]string
+	// TLAs is the top-level arguments passed as strings to the function the jsonnet file evaluates to.
+	TLAs map[string]string
+	// TLACode is the top-level arguments passed as the values the jsonnet code evaluates to.
+	TLACode map[string]string
+	// JPaths is the library search directories for the imports, like `jsonnet -J`.
+	// The right-most directory wins, and they take precedence over JSONNET_PATH and the vendor directory.
+	JPaths []string
+	// RepoDir is the directory `gitimpart://path` imports are resolved against. See RepoDir.
+	RepoDir string
+	// NativeFunctions is the Go functions callable via `std.native(name)`. See NativeFunctions.
+	NativeFunctions []*jsonnet.NativeFunction
+	// Context is the RunContext of the `.template.` jsonnet files. See Context.
+	Context *RunContext
+	// Renderers is the renderers by the extensions of the inputs. See RenderWith.
+	Renderers map[string]Renderer
+	// SOPSKeys is the keys the `sopsDecrypt` native function decrypts with. See DecryptionKeys.
+	SOPSKeys *SOPSKeys
 }
 
 type LoadOption func(*LoadConfig)
@@ -22,16 +78,83 @@ func Vars(vars map[string]string) LoadOption {
 	}
 }
 
-// RenderFile loads contents from a json or jsonnet file and returns it as a Contents struct.
+// ExtCode adds the ext vars whose values are jsonnet code,
+// like `jsonnet --ext-code`.
+func ExtCode(code map[string]string) LoadOption {
+	return func(c *LoadConfig) {
+		c.ExtCode = mergeVars(c.ExtCode, code)
+	}
+}
+
+// TLAs adds the string top-level arguments, like `jsonnet --tla-str`.
+// The jsonnet file is expected to evaluate to a function that takes the arguments as parameters.
+func TLAs(args map[string]string) LoadOption {
+	return func(c *LoadConfig) {
+		c.TLAs = mergeVars(c.TLAs, args)
+	}
+}
+
+// TLACode adds the top-level arguments whose values are jsonnet code, like `jsonnet --tla-code`.
+// It allows passing arrays, objects, numbers, and booleans to the function the jsonnet file evaluates to.
+func TLACode(code map[string]string) LoadOption {
+	return func(c *LoadConfig) {
+		c.TLACode = mergeVars(c.TLACode, code)
+	}
+}
+
+// JPaths adds the library search directories for the imports, like `jsonnet -J`.
+//
+// The imports are resolved relative to the importing file first, then in the directories
+// with the right-most one winning, then in the directories listed in $JSONNET_PATH with the left-most one winning,
+// and finally in the vendor directory next to the nearest jsonnetfile.json of jsonnet-bundler.
+func JPaths(dirs ...string) LoadOption {
+	return func(c *LoadConfig) {
+		c.JPaths = append(c.JPaths, dirs...)
+	}
+}
+
+// DecryptionKeys makes the `sopsDecrypt` native function decrypt with the keys instead of the ones read by SOPSKeysFromEnv.
+// PushFunc adds it with the keys given by WithSOPSKeys.
+func DecryptionKeys(keys SOPSKeys) LoadOption {
+	return func(c *LoadConfig) {
+		c.SOPSKeys = &keys
+	}
+}
+
+func mergeVars(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string, len(src))
+	}
+
+	for k, v := range src {
+		dst[k] = v
+	}
+
+	return dst
+}
+
+// RenderFile renders the contents from the input at the path with the renderer for its kind:
+//
+//   - a .jsonnet file is evaluated, and a .json file is read as is, as LoadFile does
+//   - a .yaml or .yml file is read as the YAML representation of the contents
+//   - a .tmpl file is rendered as a Go template into the YAML or JSON representation of the contents
+//   - a .cue file is evaluated into the contents, like `cue export`
+//   - a directory is copied as is, with the .tmpl files in it rendered as Go templates
+//
+// Use RenderWith to add or replace the renderers.
 func RenderFile(path string, opts ...LoadOption) (*Contents, error) {
-	file, err := LoadFile(path, opts...)
+	var cfg LoadConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	r, err := cfg.renderer(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var c Contents
-
-	if err := json.Unmarshal(file, &c); err != nil {
+	c, err := r.Render(path, cfg)
+	if err != nil {
 		return nil, err
 	}
 
@@ -44,5 +167,39 @@ func RenderFile(path string, opts ...LoadOption) (*Contents, error) {
 		}
 	}
 
-	return &c, nil
+	return c, nil
+}
+
+// FileContent returns the content of the file as written by Push.
+// A non-string content is marshaled into JSON or YAML depending on the file extension.
+func (c Contents) FileContent(name string) ([]byte, error) {
+	content, ok := c.Files[name]
+	if !ok {
+		return nil, fmt.Errorf("file not found: %s", name)
+	}
+
+	return marshalFile(name, content)
+}
+
+func marshalFile(name string, content interface{}) ([]byte, error) {
+	if s, ok := content.(string); ok {
+		return []byte(s), nil
+	}
+
+	switch filepath.Ext(name) {
+	case ".json":
+		b, err := json.Marshal(content)
+		if err != nil {
+			return nil, fmt.Errorf("marshal error: %w", err)
+		}
+		return b, nil
+	case ".yaml", ".yml":
+		b, err := yaml.Marshal(content)
+		if err != nil {
+			return nil, fmt.Errorf("marshal error: %w", err)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unsupported file type: %s", name)
+	}
 }
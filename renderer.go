@@ -0,0 +1,203 @@
+package gitimpart
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Renderer renders the input at the path, like a jsonnet file or a directory, into the contents.
+type Renderer interface {
+	Render(path string, cfg LoadConfig) (*Contents, error)
+}
+
+// RendererFunc is the function that implements Renderer.
+type RendererFunc func(path string, cfg LoadConfig) (*Contents, error)
+
+func (f RendererFunc) Render(path string, cfg LoadConfig) (*Contents, error) {
+	return f(path, cfg)
+}
+
+// DirExtension is the extension the renderer of the directories is registered for. See RenderWith.
+const DirExtension = "/"
+
+// defaultRenderers is the renderers by the extensions of the inputs.
+// An input with any other extension is read as the JSON representation of the contents.
+var defaultRenderers = map[string]Renderer{
+	DirExtension: RendererFunc(renderDir),
+	".jsonnet":   RendererFunc(renderJsonnet),
+	".json":      RendererFunc(renderJsonnet),
+	".yaml":      RendererFunc(renderYAML),
+	".yml":       RendererFunc(renderYAML),
+	".tmpl":      RendererFunc(renderTemplateFile),
+	".cue":       RendererFunc(renderCUE),
+}
+
+// RenderWith makes RenderFile render the inputs with the extension, like ".star", with the renderer.
+// It replaces the built-in renderer for the extension, if any.
+// Use DirExtension to replace the renderer of the directories.
+func RenderWith(ext string, r Renderer) LoadOption {
+	return func(c *LoadConfig) {
+		if c.Renderers == nil {
+			c.Renderers = map[string]Renderer{}
+		}
+		c.Renderers[ext] = r
+	}
+}
+
+// renderer returns the renderer for the input at the path.
+func (c LoadConfig) renderer(path string) (Renderer, error) {
+	ext := filepath.Ext(path)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		ext = DirExtension
+	}
+
+	if r, ok := c.Renderers[ext]; ok {
+		return r, nil
+	}
+
+	if r, ok := defaultRenderers[ext]; ok {
+		return r, nil
+	}
+
+	if ext == DirExtension {
+		return nil, fmt.Errorf("no renderer for directory %s", path)
+	}
+
+	return RendererFunc(renderJsonnet), nil
+}
+
+// renderJsonnet evaluates the jsonnet file, or reads the JSON file as is, as LoadFile does.
+func renderJsonnet(path string, cfg LoadConfig) (*Contents, error) {
+	file, err := loadFile(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalContents(file)
+}
+
+// renderYAML reads the YAML file that describes the contents, like:
+//
+//	$files:
+//	  config.yaml:
+//	    replicas: 2
+func renderYAML(path string, cfg LoadConfig) (*Contents, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return yamlContents(path, file)
+}
+
+func yamlContents(path string, b []byte) (*Contents, error) {
+	v, err := parseYAML(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	if _, ok := v.([]interface{}); ok {
+		return nil, fmt.Errorf("unable to parse %s: it must contain a single YAML document", path)
+	}
+
+	// Convert to JSON so that the contents are the same as the ones rendered from jsonnet files.
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	c, err := unmarshalContents(j)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+func unmarshalContents(b []byte) (*Contents, error) {
+	var c Contents
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// renderDir copies the files in the directory as they are, keeping their paths relative to the directory.
+// The files with the .tmpl extension are rendered as Go templates, and written without the extension.
+func renderDir(dir string, cfg LoadConfig) (*Contents, error) {
+	c := Contents{Files: map[string]interface{}{}}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(name, ".tmpl") {
+			s, err := executeTemplate(path, string(b), cfg)
+			if err != nil {
+				return err
+			}
+			c.Files[strings.TrimSuffix(name, ".tmpl")] = s
+			return nil
+		}
+
+		c.Files[name] = string(b)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to render directory %s: %w", dir, err)
+	}
+
+	return &c, nil
+}
+
+// renderTemplateFile renders the Go template file into the YAML or JSON document that describes the contents.
+func renderTemplateFile(path string, cfg LoadConfig) (*Contents, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := executeTemplate(path, string(file), cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is also YAML.
+	return yamlContents(path, []byte(s))
+}
@@ -0,0 +1,96 @@
+package gitimpart
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// RepoURLScheme is the prefix of the imports resolved against the target repository,
+// like `import "gitimpart://path/to/file.json"` or `importstr "gitimpart://VERSION"`.
+const RepoURLScheme = "gitimpart://"
+
+// RepoDir makes the files in the directory, usually the worktree of the target branch,
+// available to the jsonnet file via `gitimpart://path` imports and the `repoFile` native function.
+//
+// `std.native("repoFile")(path)` returns the content of the file as a string, or null when it does not exist,
+// so that the jsonnet file can compute the new content from the old one, if any.
+func RepoDir(dir string) LoadOption {
+	return func(c *LoadConfig) {
+		c.RepoDir = dir
+	}
+}
+
+// RenderFileFunc returns the RenderFunc that renders the jsonnet file
+// with the worktree of the target branch available via RepoDir.
+func RenderFileFunc(path string, opts ...LoadOption) RenderFunc {
+	return func(repoDir string, extra ...LoadOption) (*Contents, error) {
+		return RenderFile(path, append(append(append([]LoadOption{}, opts...), extra...), RepoDir(repoDir))...)
+	}
+}
+
+// repoImporter resolves the `gitimpart://path` imports against the target repository,
+// and the other imports as jsonnet.FileImporter does.
+type repoImporter struct {
+	dir  string
+	file *jsonnet.FileImporter
+}
+
+func (i *repoImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	if !strings.HasPrefix(importedPath, RepoURLScheme) {
+		return i.file.Import(importedFrom, importedPath)
+	}
+
+	b, err := readRepoFile(i.dir, strings.TrimPrefix(importedPath, RepoURLScheme))
+	if err != nil {
+		return jsonnet.Contents{}, "", err
+	}
+
+	return jsonnet.MakeContentsRaw(b), importedPath, nil
+}
+
+// repoFileFunc is the `repoFile` native function that returns the content of the file in the target repository,
+// or null when it does not exist.
+func repoFileFunc(dir string) *jsonnet.NativeFunction {
+	return &jsonnet.NativeFunction{
+		Name:   "repoFile",
+		Params: ast.Identifiers{"path"},
+		Func: func(args []interface{}) (interface{}, error) {
+			p, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("repoFile: path must be a string, but got %T", args[0])
+			}
+
+			b, err := readRepoFile(dir, p)
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+
+			return string(b), nil
+		},
+	}
+}
+
+func readRepoFile(dir, p string) ([]byte, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("unable to read %s%s: the target repository is not available. Render the file after cloning the repository, like `gitimpart push -clone-first`", RepoURLScheme, p)
+	}
+
+	if err := validatePath(p); err != nil {
+		return nil, fmt.Errorf("invalid path %s%s: %w", RepoURLScheme, p, err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(p)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s%s: %w", RepoURLScheme, p, err)
+	}
+
+	return b, nil
+}
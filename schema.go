@@ -0,0 +1,300 @@
+package gitimpart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaConfig configures the JSON Schema validation of the rendered files. See Contents.ValidateSchemas.
+type SchemaConfig struct {
+	// Rules maps the files to the schemas by their paths. The first matching rule wins.
+	Rules []SchemaRule
+	// Dir is the directory containing the schemas of the Kubernetes objects,
+	// which are looked up by the apiVersion and the kind of each object in a file not matching any rule.
+	//
+	// The schema of the object is read from either `KIND-GROUP-VERSION.json`, or `KIND-VERSION.json` for the core group,
+	// as generated by kubeconform's openapi2jsonschema and used by kubeval, like `deployment-apps-v1.json`,
+	// where GROUP is the first component of the group, or `GROUP/KIND_VERSION.json`, as in the CRDs catalog,
+	// like `cert-manager.io/certificate_v1.json`. The kind is lowercased.
+	Dir string
+	// Strict fails the validation of the YAML and JSON files whose objects have no schema.
+	// Otherwise, they are only checked to parse.
+	Strict bool
+}
+
+// SchemaRule validates the files matching the path glob against the JSON Schema file.
+type SchemaRule struct {
+	// Path is the glob of the files relative to the repository root, like "apps/**/values.yaml".
+	// "**" matches zero or more directories.
+	Path string `json:"path" yaml:"path"`
+	// Schema is the path to the JSON Schema file.
+	Schema string `json:"schema" yaml:"schema"`
+}
+
+// WithSchemaValidation makes Push validate the rendered files against the JSON Schemas
+// before writing them into the repository, failing without committing anything when any of them is invalid.
+func WithSchemaValidation(cfg SchemaConfig) PushOptions {
+	return func(c *PushConfig) {
+		c.Schemas = &cfg
+	}
+}
+
+// ValidateSchemas validates every YAML and JSON file against the JSON Schema found by SchemaConfig.
+// Each document in a multi-document YAML file is validated on its own.
+//
+// It reports every file that does not parse, and every field that violates the schema,
+// like `$files["app/deployment.yaml"]: Deployment/default/app: /spec/replicas: expected integer, but got string`.
+func (c Contents) ValidateSchemas(cfg SchemaConfig) error {
+	v := &schemaValidator{
+		cfg:      cfg,
+		compiler: jsonschema.NewCompiler(),
+		schemas:  map[string]*jsonschema.Schema{},
+	}
+
+	names := make([]string, 0, len(c.Files))
+	for name := range c.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+
+	for _, name := range names {
+		for _, err := range v.validateFile(name, c.Files[name]) {
+			errs = append(errs, fmt.Errorf("$files[%q]: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+type schemaValidator struct {
+	cfg      SchemaConfig
+	compiler *jsonschema.Compiler
+	// schemas is the compiled schemas by the paths, or nil for the paths that do not exist.
+	schemas map[string]*jsonschema.Schema
+}
+
+func (v *schemaValidator) validateFile(name string, content interface{}) []error {
+	docs, err := documents(name, content)
+	if err != nil {
+		return []error{err}
+	}
+
+	if docs == nil {
+		return nil
+	}
+
+	var rule *SchemaRule
+	for i, r := range v.cfg.Rules {
+		ok, err := matchGlob(r.Path, name)
+		if err != nil {
+			return []error{fmt.Errorf("invalid schema rule path %q: %w", r.Path, err)}
+		}
+		if ok {
+			rule = &v.cfg.Rules[i]
+			break
+		}
+	}
+
+	var errs []error
+
+	for i, doc := range docs {
+		if doc == nil {
+			continue
+		}
+
+		id := documentID(doc, i, len(docs))
+
+		var (
+			schema *jsonschema.Schema
+			err    error
+		)
+		if rule != nil {
+			schema, err = v.schema(rule.Schema)
+			if err == nil && schema == nil {
+				err = fmt.Errorf("schema %s not found", rule.Schema)
+			}
+		} else {
+			schema, err = v.kubernetesSchema(doc)
+		}
+
+		if err != nil {
+			errs = append(errs, withID(id, err))
+			continue
+		}
+
+		if schema == nil {
+			if v.cfg.Strict {
+				errs = append(errs, withID(id, errors.New("no schema found")))
+			}
+			continue
+		}
+
+		var ve *jsonschema.ValidationError
+		if err := schema.Validate(doc); errors.As(err, &ve) {
+			for _, l := range leafErrors(ve) {
+				loc := l.InstanceLocation
+				if loc == "" {
+					loc = "/"
+				}
+				errs = append(errs, withID(id, fmt.Errorf("%s: %s", loc, l.Message)))
+			}
+		} else if err != nil {
+			errs = append(errs, withID(id, err))
+		}
+	}
+
+	return errs
+}
+
+// documents returns the documents in the YAML or JSON file, or nil for the other files.
+func documents(name string, content interface{}) ([]interface{}, error) {
+	s, isString := content.(string)
+
+	switch path.Ext(name) {
+	case ".yaml", ".yml":
+		if !isString {
+			return []interface{}{content}, nil
+		}
+		docs, err := parseYAMLDocuments(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		return docs, nil
+	case ".json":
+		if !isString {
+			return []interface{}{content}, nil
+		}
+		var doc interface{}
+		if err := json.Unmarshal([]byte(s), &doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return []interface{}{doc}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// documentID identifies the document in the errors, like "Deployment/default/app" or "#1",
+// or returns an empty string for the only document that is not a Kubernetes object.
+func documentID(doc interface{}, i, n int) string {
+	if apiVersion, kind, name, ns := kubernetesObject(doc); apiVersion != "" && kind != "" && name != "" {
+		if ns != "" {
+			return kind + "/" + ns + "/" + name
+		}
+		return kind + "/" + name
+	}
+
+	if n > 1 {
+		return fmt.Sprintf("#%d", i)
+	}
+
+	return ""
+}
+
+func withID(id string, err error) error {
+	if id == "" {
+		return err
+	}
+
+	return fmt.Errorf("%s: %w", id, err)
+}
+
+func kubernetesObject(doc interface{}) (apiVersion, kind, name, namespace string) {
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return "", "", "", ""
+	}
+
+	apiVersion, _ = m["apiVersion"].(string)
+	kind, _ = m["kind"].(string)
+	meta, _ := m["metadata"].(map[string]interface{})
+	name, _ = meta["name"].(string)
+	namespace, _ = meta["namespace"].(string)
+
+	return apiVersion, kind, name, namespace
+}
+
+// kubernetesSchema returns the schema of the Kubernetes object in SchemaConfig.Dir,
+// or nil when the document is not a Kubernetes object or the schema is not found.
+func (v *schemaValidator) kubernetesSchema(doc interface{}) (*jsonschema.Schema, error) {
+	apiVersion, kind, _, _ := kubernetesObject(doc)
+	if v.cfg.Dir == "" || apiVersion == "" || kind == "" {
+		return nil, nil
+	}
+
+	kind = strings.ToLower(kind)
+	group, version, ok := strings.Cut(apiVersion, "/")
+	if !ok {
+		group, version = "", apiVersion
+	}
+
+	var candidates []string
+	if group == "" {
+		candidates = append(candidates, fmt.Sprintf("%s-%s.json", kind, version))
+	} else {
+		first, _, _ := strings.Cut(group, ".")
+		candidates = append(candidates,
+			fmt.Sprintf("%s-%s-%s.json", kind, first, version),
+			filepath.Join(group, fmt.Sprintf("%s_%s.json", kind, version)),
+		)
+	}
+
+	for _, c := range candidates {
+		s, err := v.schema(filepath.Join(v.cfg.Dir, c))
+		if err != nil || s != nil {
+			return s, err
+		}
+	}
+
+	return nil, nil
+}
+
+// schema compiles the schema file, returning nil when it does not exist.
+func (v *schemaValidator) schema(p string) (*jsonschema.Schema, error) {
+	if s, ok := v.schemas[p]; ok {
+		return s, nil
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get absolute path to %s: %w", p, err)
+	}
+
+	if _, err := os.Stat(abs); errors.Is(err, os.ErrNotExist) {
+		v.schemas[p] = nil
+		return nil, nil
+	}
+
+	s, err := v.compiler.Compile(abs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile schema %s: %w", p, err)
+	}
+
+	v.schemas[p] = s
+
+	return s, nil
+}
+
+// leafErrors returns the innermost validation errors, which point to the offending fields.
+func leafErrors(ve *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(ve.Causes) == 0 {
+		return []*jsonschema.ValidationError{ve}
+	}
+
+	var leaves []*jsonschema.ValidationError
+	for _, c := range ve.Causes {
+		leaves = append(leaves, leafErrors(c)...)
+	}
+
+	return leaves
+}
@@ -0,0 +1,550 @@
+package gitimpart
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/getsops/sops/v3"
+	"github.com/getsops/sops/v3/aes"
+	sopsage "github.com/getsops/sops/v3/age"
+	"github.com/getsops/sops/v3/keyservice"
+	"github.com/getsops/sops/v3/pgp"
+	sopsjson "github.com/getsops/sops/v3/stores/json"
+	sopsyaml "github.com/getsops/sops/v3/stores/yaml"
+	"github.com/mumoshu/gitimpart/config"
+	"github.com/mumoshu/gitimpart/envvar"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// sopsVersion is the version of sops whose format the files are written in.
+	sopsVersion = "3.8.1"
+	// sopsMetadataKey is the top-level key of the SOPS metadata.
+	sopsMetadataKey = "sops"
+)
+
+// SOPSKeys is the private keys to decrypt the SOPS files with.
+type SOPSKeys struct {
+	// Age is the age identities.
+	Age []age.Identity
+	// PGP is the OpenPGP private keys, which must have been decrypted.
+	PGP openpgp.EntityList
+}
+
+// SOPSKeysFromEnv reads the age identities as sops does, from SOPS_AGE_KEY, SOPS_AGE_KEY_FILE,
+// and sops/age/keys.txt in the user configuration directory,
+// and the armored OpenPGP private key from GITIMPART_SOPS_PGP_KEY or GITIMPART_SOPS_PGP_KEY_FILE,
+// decrypted with GITIMPART_SOPS_PGP_KEY_PASSPHRASE.
+//
+// It returns the empty keys when none is configured.
+func SOPSKeysFromEnv() (*SOPSKeys, error) {
+	var keys SOPSKeys
+
+	parseAge := func(source string, b []byte) error {
+		ids, err := age.ParseIdentities(bytes.NewReader(b))
+		if err != nil {
+			return fmt.Errorf("unable to parse age identities in %s: %w", source, err)
+		}
+		keys.Age = append(keys.Age, ids...)
+		return nil
+	}
+
+	if k := os.Getenv(envvar.SOPSAgeKey); k != "" {
+		if err := parseAge("$"+envvar.SOPSAgeKey, []byte(k)); err != nil {
+			return nil, err
+		}
+	}
+
+	files := []string{os.Getenv(envvar.SOPSAgeKeyFile)}
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "sops", "age", "keys.txt"))
+	}
+
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+
+		b, err := os.ReadFile(f)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("unable to read age key file: %w", err)
+		}
+
+		if err := parseAge(f, b); err != nil {
+			return nil, err
+		}
+	}
+
+	key := []byte(os.Getenv(envvar.SOPSPGPKey))
+	if len(key) == 0 {
+		if f := os.Getenv(envvar.SOPSPGPKeyFile); f != "" {
+			var err error
+			key, err = os.ReadFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read OpenPGP key file: %w", err)
+			}
+		}
+	}
+
+	if len(key) > 0 {
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse OpenPGP private key: %w", err)
+		}
+
+		passphrase := []byte(os.Getenv(envvar.SOPSPGPKeyPassphrase))
+		for _, e := range keyring {
+			if e.PrivateKey == nil {
+				return nil, errors.New("unable to parse OpenPGP private key: the key is a public key")
+			}
+			if e.PrivateKey.Encrypted {
+				if len(passphrase) == 0 {
+					return nil, errors.New("the OpenPGP private key is encrypted but no passphrase is provided")
+				}
+				if err := e.DecryptPrivateKeys(passphrase); err != nil {
+					return nil, fmt.Errorf("unable to decrypt OpenPGP private key: %w", err)
+				}
+			}
+		}
+
+		keys.PGP = keyring
+	}
+
+	return &keys, nil
+}
+
+// sopsKeyService is the sops key service that encrypts and decrypts the data keys with the keys in memory,
+// instead of the ones sops reads from the environment and the GnuPG home.
+// Only the age and PGP keys are supported.
+type sopsKeyService struct {
+	// keys is the private keys to decrypt the data keys with.
+	keys *SOPSKeys
+	// recipients is the OpenPGP public keys to encrypt the data keys for.
+	recipients openpgp.EntityList
+}
+
+func (s sopsKeyService) clients() []keyservice.KeyServiceClient {
+	return []keyservice.KeyServiceClient{keyservice.NewCustomLocalClient(s)}
+}
+
+// Encrypt encrypts the data key for the age recipient or the OpenPGP public key.
+func (s sopsKeyService) Encrypt(_ context.Context, req *keyservice.EncryptRequest) (*keyservice.EncryptResponse, error) {
+	switch k := req.Key.KeyType.(type) {
+	case *keyservice.Key_AgeKey:
+		mk, err := sopsage.MasterKeyFromRecipient(k.AgeKey.Recipient)
+		if err != nil {
+			return nil, err
+		}
+		if err := mk.Encrypt(req.Plaintext); err != nil {
+			return nil, err
+		}
+		return &keyservice.EncryptResponse{Ciphertext: mk.EncryptedDataKey()}, nil
+	case *keyservice.Key_PgpKey:
+		for _, e := range s.recipients {
+			if pgpFingerprint(e) == k.PgpKey.Fingerprint {
+				b, err := encryptPGP(e, req.Plaintext)
+				if err != nil {
+					return nil, err
+				}
+				return &keyservice.EncryptResponse{Ciphertext: b}, nil
+			}
+		}
+		return nil, fmt.Errorf("no OpenPGP public key for %s is given", k.PgpKey.Fingerprint)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+}
+
+// Decrypt decrypts the data key with the age identities or the OpenPGP private keys.
+func (s sopsKeyService) Decrypt(_ context.Context, req *keyservice.DecryptRequest) (*keyservice.DecryptResponse, error) {
+	switch k := req.Key.KeyType.(type) {
+	case *keyservice.Key_AgeKey:
+		// Without identities, sops would read them from the environment.
+		if s.keys == nil || len(s.keys.Age) == 0 {
+			return nil, errors.New("no age identity is given")
+		}
+		mk := &sopsage.MasterKey{Recipient: k.AgeKey.Recipient, EncryptedKey: string(req.Ciphertext)}
+		sopsage.ParsedIdentities(s.keys.Age).ApplyToMasterKey(mk)
+		b, err := mk.Decrypt()
+		if err != nil {
+			return nil, err
+		}
+		return &keyservice.DecryptResponse{Plaintext: b}, nil
+	case *keyservice.Key_PgpKey:
+		if s.keys == nil || len(s.keys.PGP) == 0 {
+			return nil, errors.New("no OpenPGP private key is given")
+		}
+		b, err := decryptPGP(s.keys.PGP, req.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		return &keyservice.DecryptResponse{Plaintext: b}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+}
+
+func encryptPGP(e *openpgp.Entity, plaintext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	aw, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+	w, err := openpgp.Encrypt(aw, openpgp.EntityList{e}, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decryptPGP(keyring openpgp.EntityList, ciphertext []byte) ([]byte, error) {
+	block, err := armor.Decode(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+func pgpFingerprint(e *openpgp.Entity) string {
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+}
+
+// sopsStore returns the sops store of the file by its extension, or nil when it is neither YAML nor JSON.
+func sopsStore(name string) sops.Store {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		return &sopsyaml.Store{}
+	case ".json":
+		return &sopsjson.Store{}
+	default:
+		return nil
+	}
+}
+
+// loadSOPSFile loads the YAML or JSON file encrypted by sops, returning nil when it is not a SOPS file.
+func loadSOPSFile(name string, b []byte) (*sops.Tree, error) {
+	s := sopsStore(name)
+	if s == nil {
+		return nil, nil
+	}
+
+	tree, err := s.LoadEncryptedFile(b)
+	if errors.Is(err, sops.MetadataNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to load the SOPS file %s: %w", name, err)
+	}
+
+	if len(tree.Branches) != 1 {
+		return nil, fmt.Errorf("unable to load the SOPS file %s: only a single YAML document is supported", name)
+	}
+
+	return &tree, nil
+}
+
+// sopsDataKey decrypts the data key of the file with any of the keys.
+func sopsDataKey(tree *sops.Tree, keys *SOPSKeys) ([]byte, error) {
+	var available bool
+	for _, group := range tree.Metadata.KeyGroups {
+		for _, k := range group {
+			switch k.(type) {
+			case *sopsage.MasterKey:
+				available = available || keys != nil && len(keys.Age) > 0
+			case *pgp.MasterKey:
+				available = available || keys != nil && len(keys.PGP) > 0
+			}
+		}
+	}
+
+	if !available {
+		return nil, errors.New("unable to decrypt the data key: no key for any of the recipients is available")
+	}
+
+	key, err := tree.Metadata.GetDataKeyWithKeyServices(sopsKeyService{keys: keys}.clients())
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the data key: %w", err)
+	}
+
+	return key, nil
+}
+
+// decryptSOPS decrypts the values of the tree in place with the data key, verifying the MAC.
+func decryptSOPS(tree *sops.Tree, key []byte) error {
+	cipher := aes.NewCipher()
+
+	mac, err := tree.Decrypt(key, cipher)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt: %w", err)
+	}
+
+	want, err := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, key, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("unable to decrypt the MAC: %w", err)
+	}
+
+	if want != mac {
+		return errors.New("the MAC does not match the content, which may have been tampered with")
+	}
+
+	return nil
+}
+
+// sopsMaskingCipher decrypts the values like aes.Cipher, replacing each with what the function returns for it
+// and the path of the keys to it.
+type sopsMaskingCipher struct {
+	aes.Cipher
+	mask func(path []string, v interface{}) interface{}
+}
+
+func (c sopsMaskingCipher) Decrypt(ciphertext string, key []byte, additionalData string) (interface{}, error) {
+	v, err := c.Cipher.Decrypt(ciphertext, key, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	// sops authenticates each value with the path of the keys joined by colons.
+	return c.mask(strings.Split(strings.TrimSuffix(additionalData, ":"), ":"), v), nil
+}
+
+// sopsRecipients is the parsed SOPSOptions.
+type sopsRecipients struct {
+	options config.SOPSOptions
+	age     []string
+	pgp     openpgp.EntityList
+}
+
+func parseSOPSRecipients(o config.SOPSOptions) (*sopsRecipients, error) {
+	if len(o.Age) == 0 && len(o.PGP) == 0 {
+		return nil, errors.New("no age or PGP recipient is configured")
+	}
+
+	if o.EncryptedRegex != "" {
+		if _, err := regexp.Compile(o.EncryptedRegex); err != nil {
+			return nil, fmt.Errorf("invalid encrypted regex %q: %w", o.EncryptedRegex, err)
+		}
+	}
+
+	r := &sopsRecipients{options: o}
+
+	for _, a := range o.Age {
+		k, err := sopsage.MasterKeyFromRecipient(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %w", a, err)
+		}
+		r.age = append(r.age, k.Recipient)
+	}
+
+	for _, p := range o.PGP {
+		key := []byte(p)
+		if !strings.Contains(p, "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+			var err error
+			key, err = os.ReadFile(p)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read OpenPGP public key: %w", err)
+			}
+		}
+
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse OpenPGP public key: %w", err)
+		}
+		r.pgp = append(r.pgp, keyring...)
+	}
+
+	return r, nil
+}
+
+// keyGroup returns the master keys of the recipients, without the data key encrypted yet.
+func (r *sopsRecipients) keyGroup() sops.KeyGroup {
+	var g sops.KeyGroup
+	for _, a := range r.age {
+		g = append(g, &sopsage.MasterKey{Recipient: a})
+	}
+	for _, e := range r.pgp {
+		g = append(g, pgp.NewMasterKeyFromFingerprint(pgpFingerprint(e)))
+	}
+	return g
+}
+
+// matches reports whether the data key is encrypted for exactly the recipients and the values are selected by the options.
+func (r *sopsRecipients) matches(m sops.Metadata) bool {
+	if m.EncryptedRegex != r.options.EncryptedRegex {
+		return false
+	}
+
+	if m.EncryptedRegex == "" && m.UnencryptedSuffix != sops.DefaultUnencryptedSuffix {
+		return false
+	}
+
+	if len(m.KeyGroups) != 1 {
+		return false
+	}
+
+	var ageRecipients, pgpFingerprints []string
+	for _, k := range m.KeyGroups[0] {
+		switch k := k.(type) {
+		case *sopsage.MasterKey:
+			ageRecipients = append(ageRecipients, k.Recipient)
+		case *pgp.MasterKey:
+			pgpFingerprints = append(pgpFingerprints, k.Fingerprint)
+		default:
+			return false
+		}
+	}
+
+	var want []string
+	for _, e := range r.pgp {
+		want = append(want, pgpFingerprint(e))
+	}
+
+	return equalSets(ageRecipients, r.age) && equalSets(pgpFingerprints, want)
+}
+
+func equalSets(a, b []string) bool {
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	return strings.Join(a, "\n") == strings.Join(b, "\n")
+}
+
+// encryptSOPS encrypts the YAML or JSON content for the recipients with sops,
+// so that it can be decrypted by `sops --decrypt`.
+func encryptSOPS(name string, content interface{}, r *sopsRecipients, now time.Time) ([]byte, error) {
+	branch, err := sopsTree(name, content)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := sops.Tree{
+		Branches: sops.TreeBranches{branch},
+		Metadata: sops.Metadata{
+			KeyGroups:      []sops.KeyGroup{r.keyGroup()},
+			EncryptedRegex: r.options.EncryptedRegex,
+			Version:        sopsVersion,
+		},
+		FilePath: name,
+	}
+	if tree.Metadata.EncryptedRegex == "" {
+		tree.Metadata.UnencryptedSuffix = sops.DefaultUnencryptedSuffix
+	}
+
+	key, errs := tree.GenerateDataKeyWithKeyServices(sopsKeyService{recipients: r.pgp}.clients())
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("unable to encrypt the data key: %w", err)
+	}
+
+	cipher := aes.NewCipher()
+
+	mac, err := tree.Encrypt(key, cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Metadata.LastModified = now.UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, key, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt the MAC: %w", err)
+	}
+
+	return sopsStore(name).EmitEncryptedFile(tree)
+}
+
+// sopsTree reads the content of the YAML or JSON file into the tree sops encrypts, as sops reads the file.
+func sopsTree(name string, content interface{}) (sops.TreeBranch, error) {
+	s := sopsStore(name)
+	if s == nil {
+		return nil, errors.New("only YAML and JSON files can be encrypted")
+	}
+
+	var b []byte
+	if str, ok := content.(string); ok {
+		b = []byte(str)
+	} else {
+		tree, ok := orderedValue(content).(yaml.MapSlice)
+		if !ok {
+			return nil, errors.New("only an object can be encrypted")
+		}
+
+		var err error
+		b, err = marshalOrdered(name, tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	branches, err := s.LoadPlainFile(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(branches) != 1 {
+		return nil, errors.New("only a single YAML document can be encrypted")
+	}
+
+	for _, item := range branches[0] {
+		if item.Key == sopsMetadataKey {
+			return nil, errors.New("the content is already encrypted")
+		}
+	}
+
+	return branches[0], nil
+}
+
+// orderedSOPS converts the sops tree into the ordered tree, without the comments.
+func orderedSOPS(v interface{}) interface{} {
+	switch t := v.(type) {
+	case sops.TreeBranch:
+		m := make(yaml.MapSlice, 0, len(t))
+		for _, item := range t {
+			if _, ok := item.Key.(sops.Comment); ok {
+				continue
+			}
+			m = append(m, yaml.MapItem{Key: item.Key, Value: orderedSOPS(item.Value)})
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, 0, len(t))
+		for _, item := range t {
+			if _, ok := item.(sops.Comment); ok {
+				continue
+			}
+			s = append(s, orderedSOPS(item))
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,289 @@
+package gitimpart
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// GitSourcePrefix is the prefix of the sources fetched from git repositories,
+// like `git::https://github.com/org/repo//path/to/gitimpart.jsonnet@v1.0.0`.
+const GitSourcePrefix = "git::"
+
+// FetchConfig is the configuration for fetching the remote sources.
+type FetchConfig struct {
+	// Auth is used to clone the git sources over HTTPS from the AuthHosts.
+	Auth *http.BasicAuth
+	// AuthHosts is the hosts Auth is sent to. Defaults to github.com.
+	// Auth is never sent to the other hosts, nor over plain HTTP,
+	// so that a source URL pointing elsewhere, by mistake or on purpose, does not receive the credentials.
+	AuthHosts []string
+	// HTTPClient is used to download the HTTP(S) sources. It defaults to http.DefaultClient.
+	HTTPClient *nethttp.Client
+}
+
+type FetchOption func(*FetchConfig)
+
+// FetchWithGitHubToken authenticates against GitHub to clone the git sources in private repositories.
+// The token is sent only to github.com and the hosts, like the GitHub Enterprise Server, over HTTPS.
+func FetchWithGitHubToken(token string, hosts ...string) FetchOption {
+	return func(c *FetchConfig) {
+		if token == "" {
+			return
+		}
+
+		c.Auth = &http.BasicAuth{
+			Username: "gitimpartbot",
+			Password: token,
+		}
+		c.AuthHosts = append([]string{"github.com"}, hosts...)
+	}
+}
+
+// FetchWithHTTPClient downloads the HTTP(S) sources with the client.
+func FetchWithHTTPClient(client *nethttp.Client) FetchOption {
+	return func(c *FetchConfig) {
+		c.HTTPClient = client
+	}
+}
+
+// IsRemoteSource returns true when the source is fetched by FetchSource rather than read from the local filesystem.
+func IsRemoteSource(src string) bool {
+	return strings.HasPrefix(src, GitSourcePrefix) || strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://")
+}
+
+// FetchSource downloads the source into the directory and returns the local path to be rendered by RenderFile.
+// A local path is returned as is.
+//
+// The source is either:
+//
+//   - `git::URL//PATH@REF`, where PATH is the file or the directory in the repository,
+//     and REF is the branch, tag, or commit to check out, which defaults to the default branch.
+//     The other files in the repository are available to the imports relative to the file.
+//   - an HTTP(S) URL to the file, optionally pinned with the `checksum` query parameter,
+//     like `https://example.com/gitimpart.jsonnet?checksum=sha256:HEX`.
+//     The download fails when the checksum does not match.
+func FetchSource(src, dir string, opts ...FetchOption) (string, error) {
+	var c FetchConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	switch {
+	case strings.HasPrefix(src, GitSourcePrefix):
+		return c.fetchGit(src, dir)
+	case strings.HasPrefix(src, "https://"), strings.HasPrefix(src, "http://"):
+		return c.fetchHTTP(src, dir)
+	default:
+		return src, nil
+	}
+}
+
+// parseGitSource splits `git::URL//PATH@REF` into the URL, the path, and the ref.
+func parseGitSource(src string) (string, string, string, error) {
+	s := strings.TrimPrefix(src, GitSourcePrefix)
+
+	start := 0
+	if i := strings.Index(s, "://"); i >= 0 {
+		start = i + len("://")
+	}
+
+	i := strings.Index(s[start:], "//")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("invalid git source %q: it must be in the form of git::URL//PATH@REF", src)
+	}
+
+	repoURL, p := s[:start+i], s[start+i+2:]
+
+	var ref string
+	if j := strings.LastIndex(p, "@"); j >= 0 {
+		p, ref = p[:j], p[j+1:]
+	}
+
+	if p == "" {
+		p = "."
+	} else if err := validatePath(p); err != nil {
+		return "", "", "", fmt.Errorf("invalid git source %q: %w", src, err)
+	}
+
+	return repoURL, p, ref, nil
+}
+
+func (c FetchConfig) fetchGit(src, dir string) (string, error) {
+	repoURL, p, ref, err := parseGitSource(src)
+	if err != nil {
+		return "", err
+	}
+
+	cloneDir, err := os.MkdirTemp(dir, "git-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create directory to clone %s: %w", repoURL, err)
+	}
+
+	opts := &git.CloneOptions{URL: repoURL}
+	if c.Auth != nil && c.authorized(repoURL) {
+		opts.Auth = c.Auth
+	}
+
+	repo, err := git.PlainClone(cloneDir, false, opts)
+	if err != nil {
+		return "", fmt.Errorf("unable to clone %s: %w", repoURL, err)
+	}
+
+	if ref != "" {
+		hash, err := resolveRef(repo, ref)
+		if err != nil {
+			return "", fmt.Errorf("unable to resolve %s in %s: %w", ref, repoURL, err)
+		}
+
+		w, err := repo.Worktree()
+		if err != nil {
+			return "", fmt.Errorf("unable to get worktree of %s: %w", repoURL, err)
+		}
+
+		if err := w.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true}); err != nil {
+			return "", fmt.Errorf("unable to check out %s in %s: %w", ref, repoURL, err)
+		}
+	}
+
+	local := filepath.Join(cloneDir, filepath.FromSlash(p))
+	if _, err := os.Stat(local); err != nil {
+		return "", fmt.Errorf("unable to find %s in %s: %w", p, repoURL, err)
+	}
+
+	return local, nil
+}
+
+// authorized tells whether Auth may be sent to the repository.
+func (c FetchConfig) authorized(repoURL string) bool {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+
+	hosts := c.AuthHosts
+	if len(hosts) == 0 {
+		hosts = []string{"github.com"}
+	}
+
+	for _, h := range hosts {
+		if strings.EqualFold(u.Hostname(), h) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveRef resolves the branch, the tag, or the commit in the cloned repository.
+func resolveRef(repo *git.Repository, ref string) (*plumbing.Hash, error) {
+	candidates := []string{
+		plumbing.NewRemoteReferenceName(git.DefaultRemoteName, ref).String(),
+		plumbing.NewTagReferenceName(ref).String(),
+		ref,
+	}
+
+	var errs []error
+	for _, rev := range candidates {
+		hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+		if err == nil {
+			return hash, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", rev, err))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (c FetchConfig) fetchHTTP(src, dir string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("invalid source %q: %w", src, err)
+	}
+
+	q := u.Query()
+	checksum := q.Get("checksum")
+	q.Del("checksum")
+	u.RawQuery = q.Encode()
+
+	var (
+		h    hash.Hash
+		want string
+	)
+	if checksum != "" {
+		algo, sum, ok := strings.Cut(checksum, ":")
+		if !ok {
+			return "", fmt.Errorf("invalid checksum %q: it must be in the form of sha256:HEX or sha512:HEX", checksum)
+		}
+
+		switch algo {
+		case "sha256":
+			h = sha256.New()
+		case "sha512":
+			h = sha512.New()
+		default:
+			return "", fmt.Errorf("unsupported checksum algorithm %q: it must be either sha256 or sha512", algo)
+		}
+
+		want = strings.ToLower(sum)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = nethttp.DefaultClient
+	}
+
+	res, err := client.Get(u.String())
+	if err != nil {
+		return "", fmt.Errorf("unable to download %s: %w", u, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != nethttp.StatusOK {
+		return "", fmt.Errorf("unable to download %s: %s", u, res.Status)
+	}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to download %s: %w", u, err)
+	}
+
+	if h != nil {
+		h.Write(b)
+		if got := hex.EncodeToString(h.Sum(nil)); got != want {
+			return "", fmt.Errorf("checksum mismatch for %s: expected %s, but got %s", u, want, got)
+		}
+	}
+
+	// Keep the file name, whose extension selects the renderer.
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = "gitimpart.json"
+	}
+
+	d, err := os.MkdirTemp(dir, "http-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create directory to download %s: %w", u, err)
+	}
+
+	local := filepath.Join(d, name)
+	if err := os.WriteFile(local, b, 0644); err != nil {
+		return "", fmt.Errorf("unable to write %s: %w", local, err)
+	}
+
+	return local, nil
+}
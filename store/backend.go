@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-git/go-billy/v5"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// BackendGoGit is the git backend that uses go-git.
+	// It is the default backend.
+	BackendGoGit = "go-git"
+	// BackendExec is the git backend that shells out to the git binary.
+	// It supports partial clones, credential helpers, LFS, and everything
+	// else the git binary installed on the machine supports.
+	BackendExec = "exec"
+)
+
+// backend is the set of git operations that Git relies on to
+// clone, fetch, checkout, commit, and push.
+//
+// There are two implementations of this interface:
+// - goGit
+// - execGit
+type backend interface {
+	// open clones the repository, or opens the local repository if it has already been cloned.
+	// It returns true if the repository has already been cloned.
+	open(ctx context.Context) (bool, error)
+
+	// fetch force-fetches the branch from the remote origin into its remote-tracking branch,
+	// and returns the commit the branch points to.
+	fetch(ctx context.Context, branch plumbing.ReferenceName) (plumbing.Hash, error)
+
+	// reset points the branch to the commit and checks it out,
+	// discarding any changes and untracked files in the worktree.
+	reset(branch plumbing.ReferenceName, hash plumbing.Hash) error
+
+	// createBranch creates the branch at HEAD and checks it out,
+	// keeping the changes in the worktree.
+	// The branch is overwritten if it already exists.
+	createBranch(branch plumbing.ReferenceName) error
+
+	// deleteBranch deletes the local branch.
+	deleteBranch(branch plumbing.ReferenceName) error
+
+	// branches returns the local branches.
+	branches() ([]plumbing.ReferenceName, error)
+
+	// add stages the file or directory at path.
+	add(path string) error
+
+	// remove stages the deletion of the file at path.
+	remove(path string) error
+
+	// commit commits the staged changes and returns the hash of the commit.
+	commit(message string, author, committer *object.Signature) (plumbing.Hash, error)
+
+	// push pushes the local branch to the same branch on the remote origin.
+	// When force is true, the remote branch is overwritten even if the push is not a fast-forward.
+	push(ctx context.Context, branch plumbing.ReferenceName, force bool) error
+
+	// remoteBranches returns the branches on the remote origin, listed in a single round trip.
+	remoteBranches(ctx context.Context) (map[plumbing.ReferenceName]bool, error)
+
+	// sparsify limits the worktree to the directories.
+	// It is called after every reset.
+	sparsify(dirs []string) error
+
+	// unsparsify undoes sparsify.
+	// It is called before every reset.
+	unsparsify() error
+
+	// repository returns the go-git repository that can be used to
+	// read the commits, trees, and references.
+	repository() (*git.Repository, error)
+
+	// worktree returns the go-git worktree.
+	// It is used to serve the APIs that expose the go-git worktree, like ModifyWorktree.
+	worktree() (*git.Worktree, error)
+
+	// filesystem returns the filesystem of the worktree.
+	filesystem() (billy.Filesystem, error)
+}
+
+func newBackend(g *Git) (backend, error) {
+	switch g.Backend {
+	case "", BackendGoGit:
+		return &goGit{g: g}, nil
+	case BackendExec:
+		if g.GitRoot == "" {
+			return nil, fmt.Errorf("the %s backend requires GitRoot to be set", BackendExec)
+		}
+		return &execGit{g: g}, nil
+	default:
+		return nil, fmt.Errorf("unknown git backend %q: it must be either %q or %q", g.Backend, BackendGoGit, BackendExec)
+	}
+}
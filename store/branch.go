@@ -0,0 +1,190 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	// DefaultBranchTemplate is the template of the feature branch name used when none is configured.
+	DefaultBranchTemplate = appName + "/{{ .Target }}/{{ .ContentHash }}"
+
+	// BranchCollisionSuffix makes Git pick the first free name among "<name>-2", "<name>-3", and so on,
+	// when the feature branch already exists on the remote.
+	// It is the default.
+	BranchCollisionSuffix = "suffix"
+	// BranchCollisionReuse makes Git overwrite the feature branch when it already exists on the remote.
+	// As the default template contains the content hash, the existing branch usually has the same changes.
+	BranchCollisionReuse = "reuse"
+
+	// maxBranchSuffix is the maximum suffix tried by BranchCollisionSuffix.
+	maxBranchSuffix = 100
+)
+
+// BranchTemplateData is the data available to the feature branch name template.
+//
+// For example, `gitimpart/{{ .Vars.app }}/{{ .Target }}-{{ .ContentHash }}`.
+type BranchTemplateData struct {
+	// ID is the ID passed to Make. It is empty for the branches created by gitimpart.Push.
+	ID string
+	// Target is the short name of the base branch, like "main".
+	Target string
+	// ContentHash is the short hash of the paths and contents of the rendered files.
+	// It is the same across runs as long as the rendered files are the same.
+	ContentHash string
+	// Timestamp is the time the branch is created, in the form of "20060102150405".
+	Timestamp string
+	// Vars is the variables passed to the template, usually the same as the jsonnet ext vars.
+	Vars map[string]string
+}
+
+// resolveBranch renders the BranchTemplate, resolves the collision with the remote branches,
+// and checks out the resulting feature branch keeping the rendered changes.
+func (g *Git) resolveBranch(ctx context.Context) error {
+	t := g.branchTime
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	data := g.branchTemplateData()
+	data.ContentHash = contentHash(g.rendered)
+	data.Timestamp = t.Format("20060102150405")
+
+	name, err := g.renderBranch(data)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		return fmt.Errorf("branch template %q resulted in an empty branch name", g.BranchTemplate)
+	}
+
+	ref := plumbing.NewBranchReferenceName(name)
+	if err := ref.Validate(); err != nil {
+		return fmt.Errorf("branch template %q resulted in an invalid branch name %q: %w", g.BranchTemplate, name, err)
+	}
+
+	branches, err := g.backend.remoteBranches(ctx)
+	if err != nil {
+		return err
+	}
+
+	if branches[ref] {
+		switch g.BranchCollision {
+		case "", BranchCollisionSuffix:
+			ref, err = freeBranch(name, branches)
+			if err != nil {
+				return err
+			}
+		case BranchCollisionReuse:
+			g.branchReused = true
+		default:
+			return fmt.Errorf("unknown branch collision strategy %q: it must be either %q or %q", g.BranchCollision, BranchCollisionSuffix, BranchCollisionReuse)
+		}
+	}
+
+	if err := g.backend.createBranch(ref); err != nil {
+		return err
+	}
+
+	g.NewRefName = &ref
+
+	return nil
+}
+
+// branchTemplateData returns the data of the branch template without the ContentHash and the Timestamp.
+func (g *Git) branchTemplateData() BranchTemplateData {
+	data := BranchTemplateData{
+		ID:     g.BranchID,
+		Target: g.BaseRefName.Short(),
+		Vars:   g.BranchVars,
+	}
+	if data.Vars == nil {
+		data.Vars = map[string]string{}
+	}
+
+	return data
+}
+
+// renderBranch renders the BranchTemplate with the data.
+func (g *Git) renderBranch(data BranchTemplateData) (string, error) {
+	tmpl, err := template.New("branch").Option("missingkey=error").Parse(g.BranchTemplate)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse branch template: %w", err)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("unable to render branch template: %w", err)
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+const (
+	contentHashPlaceholder = "\x00ContentHash\x00"
+	timestampPlaceholder   = "\x00Timestamp\x00"
+)
+
+// branchPattern returns the regular expression matching the feature branch names
+// the BranchTemplate generates for the base branch with any content hash, timestamp, and collision suffix,
+// or nil when there is no BranchTemplate.
+func (g *Git) branchPattern() (*regexp.Regexp, error) {
+	if g.BranchTemplate == "" {
+		return nil, nil
+	}
+
+	data := g.branchTemplateData()
+	data.ContentHash = contentHashPlaceholder
+	data.Timestamp = timestampPlaceholder
+
+	name, err := g.renderBranch(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := regexp.QuoteMeta(name)
+	p = strings.ReplaceAll(p, contentHashPlaceholder, "[0-9a-f]{8}")
+	p = strings.ReplaceAll(p, timestampPlaceholder, "[0-9]{14}")
+
+	return regexp.Compile("^" + p + "(-[0-9]+)?$")
+}
+
+// freeBranch returns the first branch among "<name>-2", "<name>-3", and so on, that is not among the remote branches.
+func freeBranch(name string, branches map[plumbing.ReferenceName]bool) (plumbing.ReferenceName, error) {
+	for i := 2; i <= maxBranchSuffix; i++ {
+		ref := plumbing.NewBranchReferenceName(fmt.Sprintf("%s-%d", name, i))
+		if !branches[ref] {
+			return ref, nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to find a free branch name: %s-2 to %s-%d already exist", name, name, maxBranchSuffix)
+}
+
+// contentHash returns the short hash of the rendered files,
+// which are the paths mapped to the blob hashes of their contents.
+func contentHash(rendered map[string]plumbing.Hash) string {
+	paths := make([]string, 0, len(rendered))
+	for p := range rendered {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		fmt.Fprintf(h, "%s\x00%s\n", p, rendered[p])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
@@ -0,0 +1,388 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+)
+
+const (
+	// DiffFormatPatch prints the unified diff of the changes, like `git diff`.
+	// It is the default.
+	DiffFormatPatch = "patch"
+	// DiffFormatStat prints the per-file summary of the changes, like `git diff --stat`.
+	DiffFormatStat = "stat"
+	// DiffFormatPatchWithStat prints the summary followed by the unified diff, like `git diff --patch-with-stat`.
+	DiffFormatPatchWithStat = "patch-with-stat"
+	// DiffFormatSemantic prints the changes to the values of the YAML and JSON files by their key paths,
+	// like `spec.replicas: 2 → 3`, and the unified diff of the other files.
+	DiffFormatSemantic = "semantic"
+	// DiffFormatJSON prints the changes as a JSON object. See Diff for the schema.
+	DiffFormatJSON = "json"
+
+	// diffContextLines is the number of unchanged lines around the changes, the same as git's default.
+	diffContextLines = 3
+)
+
+// Diff is the machine-readable representation of the changes made by a commit.
+type Diff struct {
+	// Ref is the ref the commit would be pushed to, like "refs/heads/main".
+	Ref string `json:"ref"`
+	// Base is the hash of the commit the changes are made on top of.
+	Base string `json:"base"`
+	// Files is the files changed by the commit, sorted by path.
+	Files []FileDiff `json:"files"`
+	// Additions and Deletions are the total number of the lines added and deleted.
+	Additions int `json:"additions"`
+	Deletions int `json:"deletions"`
+}
+
+// FileDiff is the changes made to a file.
+type FileDiff struct {
+	Path   string     `json:"path"`
+	Status FileStatus `json:"status"`
+	// OldHash and NewHash are the blob hashes of the file before and after the change.
+	// OldHash is empty for added files and NewHash is empty for deleted files.
+	OldHash string `json:"oldHash,omitempty"`
+	NewHash string `json:"newHash,omitempty"`
+	// Binary is true when the file is binary, in which case Hunks is empty.
+	Binary    bool   `json:"binary,omitempty"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Hunks     []Hunk `json:"hunks,omitempty"`
+	// Semantic is the changes to the values of a modified YAML or JSON file,
+	// set only when both the old and new contents parse.
+	Semantic []SemanticChange `json:"semantic,omitempty"`
+}
+
+// Hunk is a contiguous range of the changed lines with the surrounding context, as in a unified diff.
+//
+// Each line is prefixed with " ", "+", or "-" for unchanged, added, and deleted lines respectively,
+// without the trailing newline.
+type Hunk struct {
+	OldStart int      `json:"oldStart"`
+	OldLines int      `json:"oldLines"`
+	NewStart int      `json:"newStart"`
+	NewLines int      `json:"newLines"`
+	Lines    []string `json:"lines"`
+}
+
+// newDiff builds the Diff from the patch between the base commit and the commit.
+func newDiff(ref, base string, patch fdiff.Patch) *Diff {
+	d := &Diff{
+		Ref:   ref,
+		Base:  base,
+		Files: []FileDiff{},
+	}
+
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+
+		var f FileDiff
+		switch {
+		case from == nil:
+			f.Path = to.Path()
+			f.Status = FileAdded
+		case to == nil:
+			f.Path = from.Path()
+			f.Status = FileDeleted
+		default:
+			f.Path = to.Path()
+			f.Status = FileModified
+		}
+
+		if from != nil {
+			f.OldHash = from.Hash().String()
+		}
+		if to != nil {
+			f.NewHash = to.Hash().String()
+		}
+
+		f.Binary = fp.IsBinary()
+		if !f.Binary {
+			f.Hunks, f.Additions, f.Deletions = hunks(fp.Chunks(), diffContextLines)
+		}
+
+		if f.Status == FileModified && !f.Binary {
+			f.Semantic, _ = semanticDiff(f.Path, fp.Chunks())
+		}
+
+		d.Additions += f.Additions
+		d.Deletions += f.Deletions
+		d.Files = append(d.Files, f)
+	}
+
+	return d
+}
+
+type diffLine struct {
+	op   fdiff.Operation
+	text string
+}
+
+// hunks splits the chunks into the hunks with the context lines around the changes,
+// and counts the added and deleted lines.
+func hunks(chunks []fdiff.Chunk, context int) ([]Hunk, int, int) {
+	var (
+		lines              []diffLine
+		additions, deletes int
+	)
+
+	for _, c := range chunks {
+		for _, l := range strings.SplitAfter(c.Content(), "\n") {
+			if l == "" {
+				continue
+			}
+
+			lines = append(lines, diffLine{op: c.Type(), text: strings.TrimSuffix(l, "\n")})
+
+			switch c.Type() {
+			case fdiff.Add:
+				additions++
+			case fdiff.Delete:
+				deletes++
+			}
+		}
+	}
+
+	// oldLine and newLine are the numbers of the old and new lines before lines[i].
+	oldLine := make([]int, len(lines)+1)
+	newLine := make([]int, len(lines)+1)
+	for i, l := range lines {
+		oldLine[i+1] = oldLine[i]
+		newLine[i+1] = newLine[i]
+		if l.op != fdiff.Add {
+			oldLine[i+1]++
+		}
+		if l.op != fdiff.Delete {
+			newLine[i+1]++
+		}
+	}
+
+	var result []Hunk
+
+	for i := 0; i < len(lines); {
+		if lines[i].op == fdiff.Equal {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk while the next change is within twice the context lines, as git does.
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].op != fdiff.Equal {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+
+		stop := end + context
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+
+		h := Hunk{
+			OldStart: oldLine[start] + 1,
+			OldLines: oldLine[stop] - oldLine[start],
+			NewStart: newLine[start] + 1,
+			NewLines: newLine[stop] - newLine[start],
+		}
+
+		// An empty range starts at the line before it, as in "@@ -0,0 +1 @@".
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+
+		for _, l := range lines[start:stop] {
+			prefix := " "
+			switch l.op {
+			case fdiff.Add:
+				prefix = "+"
+			case fdiff.Delete:
+				prefix = "-"
+			}
+			h.Lines = append(h.Lines, prefix+l.text)
+		}
+
+		result = append(result, h)
+
+		i = stop
+	}
+
+	return result, additions, deletes
+}
+
+// writeDiff writes the patch in the format.
+// The color is used only for the unified diff.
+func writeDiff(w io.Writer, format string, color bool, patch fdiff.Patch, d *Diff) error {
+	switch format {
+	case "", DiffFormatPatch:
+		return writePatch(w, patch, color)
+	case DiffFormatStat:
+		return writeStat(w, patch, d)
+	case DiffFormatPatchWithStat:
+		if err := writeStat(w, patch, d); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		return writePatch(w, patch, color)
+	case DiffFormatSemantic:
+		return writeSemantic(w, patch, d, color)
+	case DiffFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	default:
+		return fmt.Errorf("unknown diff format %q: it must be one of %q, %q, %q, %q, and %q", format, DiffFormatPatch, DiffFormatStat, DiffFormatPatchWithStat, DiffFormatSemantic, DiffFormatJSON)
+	}
+}
+
+func writePatch(w io.Writer, patch fdiff.Patch, color bool) error {
+	e := fdiff.NewUnifiedEncoder(w, fdiff.DefaultContextLines)
+	if color {
+		e.SetColor(fdiff.NewColorConfig())
+	}
+
+	if err := e.Encode(patch); err != nil {
+		return fmt.Errorf("unable to encode patch: %w", err)
+	}
+
+	// Keep the blank line that used to be printed after the patch by fmt.Println.
+	_, err := fmt.Fprintln(w)
+
+	return err
+}
+
+// writeStat writes the summary like `git diff --stat`.
+func writeStat(w io.Writer, patch fdiff.Patch, d *Diff) error {
+	if _, err := io.WriteString(w, patchStats(patch).String()); err != nil {
+		return err
+	}
+
+	plural := func(n int, s string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, s)
+		}
+		return fmt.Sprintf("%d %ss", n, s)
+	}
+
+	summary := " " + plural(len(d.Files), "file") + " changed"
+	if d.Additions > 0 || d.Deletions == 0 {
+		summary += fmt.Sprintf(", %s(+)", plural(d.Additions, "insertion"))
+	}
+	if d.Deletions > 0 {
+		summary += fmt.Sprintf(", %s(-)", plural(d.Deletions, "deletion"))
+	}
+
+	_, err := fmt.Fprintln(w, summary)
+
+	return err
+}
+
+// writeSemantic writes the semantic changes of the files that have them,
+// followed by the unified diff of the rest of the files.
+func writeSemantic(w io.Writer, patch fdiff.Patch, d *Diff, color bool) error {
+	semantic := map[string]bool{}
+	for _, f := range d.Files {
+		if len(f.Semantic) > 0 {
+			semantic[f.Path] = true
+		}
+	}
+
+	if text := d.SemanticText(); text != "" {
+		if _, err := io.WriteString(w, text); err != nil {
+			return err
+		}
+	}
+
+	var rest []fdiff.FilePatch
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		path := ""
+		if to != nil {
+			path = to.Path()
+		} else if from != nil {
+			path = from.Path()
+		}
+
+		if !semantic[path] {
+			rest = append(rest, fp)
+		}
+	}
+
+	if len(rest) == 0 {
+		return nil
+	}
+
+	if len(semantic) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	return writePatch(w, filePatches(rest), color)
+}
+
+// filePatches is the patch of the subset of the files.
+type filePatches []fdiff.FilePatch
+
+func (p filePatches) FilePatches() []fdiff.FilePatch {
+	return p
+}
+
+func (p filePatches) Message() string {
+	return ""
+}
+
+// Unified returns the changes as a unified diff, without the index lines,
+// which is suitable for embedding into Markdown.
+func (d *Diff) Unified() string {
+	var b strings.Builder
+
+	for _, f := range d.Files {
+		from, to := "a/"+f.Path, "b/"+f.Path
+		switch f.Status {
+		case FileAdded:
+			from = "/dev/null"
+		case FileDeleted:
+			to = "/dev/null"
+		}
+
+		fmt.Fprintf(&b, "diff --git a/%s b/%s\n", f.Path, f.Path)
+
+		if f.Binary {
+			fmt.Fprintf(&b, "Binary files %s and %s differ\n", from, to)
+			continue
+		}
+
+		fmt.Fprintf(&b, "--- %s\n+++ %s\n", from, to)
+
+		for _, h := range f.Hunks {
+			fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+			for _, l := range h.Lines {
+				b.WriteString(l)
+				b.WriteString("\n")
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, lines)
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGit_DryRunDiff(t *testing.T) {
+	forEachBackend(t, testGitDryRunDiff)
+}
+
+func testGitDryRunDiff(t *testing.T, backend string) {
+	dryRun := func(t *testing.T, format string, color bool) (string, *CommitResult) {
+		t.Helper()
+
+		remote := newRemote(t, map[string]string{
+			"a.txt": "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"b.txt": "b\n",
+		})
+
+		var out bytes.Buffer
+
+		g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+		g.Backend = backend
+		g.DryRun = true
+		g.DryRunOutput = &out
+		g.DiffFormat = format
+		g.DiffColor = color
+
+		_, err := g.Transact(func(dir string) (*RenderResult, error) {
+			r, err := writeFiles(map[string]string{
+				"a.txt": "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n",
+				"c.txt": "c\n",
+			})(dir)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+				return nil, err
+			}
+			r.DeletedFiles = []string{"b.txt"}
+			return r, nil
+		})
+		require.NoError(t, err)
+		require.NoError(t, g.Commit(context.Background(), "update", ""))
+
+		return out.String(), g.LastCommit()
+	}
+
+	t.Run("stat", func(t *testing.T) {
+		out, _ := dryRun(t, DiffFormatStat, false)
+		require.Equal(t, ` a.txt | 2 +-
+ b.txt | 1 -
+ c.txt | 1 +
+ 3 files changed, 2 insertions(+), 2 deletions(-)
+`, out)
+	})
+
+	t.Run("patch-with-stat", func(t *testing.T) {
+		out, _ := dryRun(t, DiffFormatPatchWithStat, false)
+		require.Contains(t, out, " 3 files changed, 2 insertions(+), 2 deletions(-)\n\ndiff --git a/a.txt b/a.txt\n")
+		require.Contains(t, out, "@@ -2,7 +2,7 @@ 1\n 2\n 3\n 4\n-5\n+five\n 6\n")
+	})
+
+	t.Run("color", func(t *testing.T) {
+		out, _ := dryRun(t, DiffFormatPatch, true)
+		require.Contains(t, out, "\x1b[32m+five\x1b[m")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		out, cr := dryRun(t, DiffFormatJSON, false)
+
+		var d Diff
+		require.NoError(t, json.Unmarshal([]byte(out), &d))
+		require.Equal(t, *cr.Diff, d)
+		require.False(t, cr.Pushed)
+
+		require.Equal(t, "refs/heads/main", d.Ref)
+		require.Equal(t, cr.Base, d.Base)
+		require.Equal(t, 2, d.Additions)
+		require.Equal(t, 2, d.Deletions)
+		require.Len(t, d.Files, 3)
+
+		a := d.Files[0]
+		require.Equal(t, "a.txt", a.Path)
+		require.Equal(t, FileModified, a.Status)
+		require.NotEmpty(t, a.OldHash)
+		require.NotEmpty(t, a.NewHash)
+		require.Equal(t, []Hunk{{
+			OldStart: 2, OldLines: 7, NewStart: 2, NewLines: 7,
+			Lines: []string{" 2", " 3", " 4", "-5", "+five", " 6", " 7", " 8"},
+		}}, a.Hunks)
+
+		b := d.Files[1]
+		require.Equal(t, "b.txt", b.Path)
+		require.Equal(t, FileDeleted, b.Status)
+		require.Empty(t, b.NewHash)
+		require.Equal(t, []Hunk{{OldStart: 1, OldLines: 1, NewStart: 0, NewLines: 0, Lines: []string{"-b"}}}, b.Hunks)
+
+		c := d.Files[2]
+		require.Equal(t, "c.txt", c.Path)
+		require.Equal(t, FileAdded, c.Status)
+		require.Empty(t, c.OldHash)
+		require.Equal(t, []Hunk{{OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 1, Lines: []string{"+c"}}}, c.Hunks)
+	})
+}
+
+func TestGit_DryRunSemanticDiff(t *testing.T) {
+	remote := newRemote(t, map[string]string{
+		"a.yaml": "spec:\n  replicas: 2\n",
+		"b.txt":  "b\n",
+	})
+
+	var out bytes.Buffer
+
+	g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+	g.DryRun = true
+	g.DryRunOutput = &out
+	g.DiffFormat = DiffFormatSemantic
+
+	_, err := g.Transact(writeFiles(map[string]string{
+		"a.yaml": "spec: {replicas: 3}\n",
+		"b.txt":  "B\n",
+	}))
+	require.NoError(t, err)
+	require.NoError(t, g.Commit(context.Background(), "update", ""))
+
+	require.Equal(t, `a.yaml:
+    spec.replicas: 2 → 3
+
+diff --git a/b.txt b/b.txt
+index 61780798228d17af2d34fce4cfbdf35556832472..223b7836fb19fdf64ba2d3cd6173c6a283141f78 100644
+--- a/b.txt
++++ b/b.txt
+@@ -1 +1 @@
+-b
++B
+
+`, out.String())
+}
@@ -0,0 +1,432 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// execGit is the backend that shells out to the git binary.
+//
+// The repository is read via go-git, which understands the repositories created by the git binary.
+type execGit struct {
+	g *Git
+
+	// sparse is true once the sparse checkout has been configured.
+	sparse bool
+}
+
+func (b *execGit) open(ctx context.Context) (bool, error) {
+	dir := b.g.getLocalRepoPath()
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return true, nil
+	}
+
+	args := []string{"clone", "--origin", "origin"}
+	if b.g.Depth > 0 {
+		args = append(args, "--depth", fmt.Sprint(b.g.Depth))
+	}
+	if b.g.SingleBranch {
+		args = append(args, "--single-branch", "--branch", b.g.BaseRefName.Short())
+	}
+	if b.g.Filter != "" {
+		args = append(args, "--filter", b.g.Filter)
+	}
+	if len(b.g.SparseCheckoutDirectories) > 0 {
+		// The base branch is checked out after the sparse checkout is configured.
+		args = append(args, "--no-checkout")
+	}
+	args = append(args, "--", b.g.GitRepoURL, dir)
+
+	if _, err := b.run(ctx, filepath.Dir(dir), args...); err != nil {
+		return false, fmt.Errorf("unable to clone git repository %s: %w", b.g.GitRepoURL, err)
+	}
+
+	if dirs := b.g.SparseCheckoutDirectories; len(dirs) > 0 {
+		if err := b.sparsify(dirs); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+func (b *execGit) fetch(ctx context.Context, branch plumbing.ReferenceName) (plumbing.Hash, error) {
+	remoteRefName := plumbing.NewRemoteReferenceName("origin", branch.Short())
+
+	if _, err := b.git(ctx, "fetch", "origin", "+"+branch.String()+":"+remoteRefName.String()); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to fetch %s from remote origin: %w", branch, err)
+	}
+
+	return b.revParse(ctx, remoteRefName.String())
+}
+
+func (b *execGit) reset(branch plumbing.ReferenceName, hash plumbing.Hash) error {
+	ctx := context.Background()
+
+	if _, err := b.git(ctx, "checkout", "--force", "-B", branch.Short(), hash.String()); err != nil {
+		return fmt.Errorf("unable to checkout branch %q: %w", branch, err)
+	}
+
+	if _, err := b.git(ctx, "clean", "-d", "--force"); err != nil {
+		return fmt.Errorf("unable to clean worktree: %w", err)
+	}
+
+	return nil
+}
+
+func (b *execGit) createBranch(branch plumbing.ReferenceName) error {
+	if _, err := b.git(context.Background(), "checkout", "-B", branch.Short()); err != nil {
+		return fmt.Errorf("unable to checkout branch %q: %w", branch, err)
+	}
+
+	return nil
+}
+
+func (b *execGit) deleteBranch(branch plumbing.ReferenceName) error {
+	if _, err := b.git(context.Background(), "branch", "--delete", "--force", branch.Short()); err != nil {
+		return fmt.Errorf("unable to delete branch %q: %w", branch, err)
+	}
+
+	return nil
+}
+
+func (b *execGit) branches() ([]plumbing.ReferenceName, error) {
+	out, err := b.git(context.Background(), "for-each-ref", "--format=%(refname)", "refs/heads/")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get branches: %w", err)
+	}
+
+	var bs []plumbing.ReferenceName
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if l != "" {
+			bs = append(bs, plumbing.ReferenceName(l))
+		}
+	}
+
+	return bs, nil
+}
+
+func (b *execGit) add(path string) error {
+	// --sparse allows adding files outside of the sparse checkout directories,
+	// like the files written at the root of the repository.
+	args := []string{"add"}
+	if b.sparse {
+		args = append(args, "--sparse")
+	}
+	args = append(args, "--", path)
+
+	if _, err := b.git(context.Background(), args...); err != nil {
+		return fmt.Errorf("unable to run git-add (chroot=%s, name=%s): %w", b.g.getLocalRepoPath(), path, err)
+	}
+
+	return nil
+}
+
+func (b *execGit) remove(path string) error {
+	args := []string{"rm", "--quiet"}
+	if b.sparse {
+		args = append(args, "--sparse")
+	}
+	args = append(args, "--", path)
+
+	if _, err := b.git(context.Background(), args...); err != nil {
+		return fmt.Errorf("unable to run git-rm: %w", err)
+	}
+
+	return nil
+}
+
+func (b *execGit) commit(message string, author, committer *object.Signature) (plumbing.Hash, error) {
+	ctx := context.Background()
+
+	if b.g.SigningKey != nil {
+		return b.commitSigned(ctx, message, author, committer)
+	}
+
+	// --cleanup=verbatim keeps the message as-is, as it is already formatted by Git.commitMessage.
+	cmd := b.command(ctx, b.g.getLocalRepoPath(), "commit", "--allow-empty", "--no-verify", "--quiet", "--cleanup=verbatim", "--file", "-")
+	cmd.Stdin = strings.NewReader(message)
+	cmd.Env = append(cmd.Env,
+		"GIT_AUTHOR_NAME="+author.Name,
+		"GIT_AUTHOR_EMAIL="+author.Email,
+		"GIT_AUTHOR_DATE="+author.When.Format(time.RFC3339),
+		"GIT_COMMITTER_NAME="+committer.Name,
+		"GIT_COMMITTER_EMAIL="+committer.Email,
+		"GIT_COMMITTER_DATE="+committer.When.Format(time.RFC3339),
+	)
+
+	if _, err := b.output(cmd); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to commit: %w", err)
+	}
+
+	return b.revParse(ctx, "HEAD")
+}
+
+// commitSigned creates the commit object signed with the SigningKey and points HEAD to it.
+//
+// We sign the commit on our own instead of running `git commit -S`,
+// so that the key does not need to be imported into the gpg keyring or the ssh-agent of the machine.
+func (b *execGit) commitSigned(ctx context.Context, message string, author, committer *object.Signature) (plumbing.Hash, error) {
+	tree, err := b.git(ctx, "write-tree")
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to write tree: %w", err)
+	}
+
+	parent, err := b.revParse(ctx, "HEAD")
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	c := &object.Commit{
+		Author:       *author,
+		Committer:    *committer,
+		Message:      message,
+		TreeHash:     plumbing.NewHash(strings.TrimSpace(tree)),
+		ParentHashes: []plumbing.Hash{parent},
+	}
+
+	if err := b.g.SigningKey.signCommit(c); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	obj := &plumbing.MemoryObject{}
+	if err := c.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to encode commit: %w", err)
+	}
+
+	r, err := obj.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to read commit: %w", err)
+	}
+
+	cmd := b.command(ctx, b.g.getLocalRepoPath(), "hash-object", "-t", "commit", "-w", "--stdin")
+	cmd.Stdin = r
+
+	out, err := b.output(cmd)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to write commit: %w", err)
+	}
+
+	hash := plumbing.NewHash(strings.TrimSpace(out))
+
+	if _, err := b.git(ctx, "update-ref", "HEAD", hash.String(), parent.String()); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to update HEAD: %w", err)
+	}
+
+	return hash, nil
+}
+
+func (b *execGit) push(ctx context.Context, branch plumbing.ReferenceName, force bool) error {
+	refSpec := branch.String() + ":" + branch.String()
+	if force {
+		refSpec = "+" + refSpec
+	}
+
+	out, err := b.git(ctx, "push", "--porcelain", "origin", refSpec)
+	if err != nil {
+		// The porcelain output tells why the ref was rejected, like
+		// "!	refs/heads/main:refs/heads/main	[rejected] (non-fast-forward)".
+		for _, l := range strings.Split(out, "\n") {
+			if fields := strings.SplitN(l, "\t", 3); len(fields) == 3 && fields[0] == "!" {
+				return fmt.Errorf("unable to push %v to remote origin: %s: %w", branch, fields[2], err)
+			}
+		}
+
+		return fmt.Errorf("unable to push %v to remote origin: %w", branch, err)
+	}
+
+	return nil
+}
+
+func (b *execGit) remoteBranches(ctx context.Context) (map[plumbing.ReferenceName]bool, error) {
+	out, err := b.git(ctx, "ls-remote", "--heads", "origin")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list remote branches: %w", err)
+	}
+
+	branches := map[plumbing.ReferenceName]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		// Each line is the hash and the ref name separated by a tab.
+		if _, ref, ok := strings.Cut(line, "\t"); ok {
+			branches[plumbing.ReferenceName(strings.TrimSpace(ref))] = true
+		}
+	}
+
+	return branches, nil
+}
+
+func (b *execGit) sparsify(dirs []string) error {
+	if b.sparse {
+		// git keeps the worktree sparse across checkouts and resets.
+		return nil
+	}
+
+	args := append([]string{"sparse-checkout", "set", "--cone", "--"}, dirs...)
+	if _, err := b.git(context.Background(), args...); err != nil {
+		return fmt.Errorf("unable to set the sparse checkout directories %v: %w", dirs, err)
+	}
+
+	b.sparse = true
+
+	return nil
+}
+
+// unsparsify disables the sparse checkout left by a previous run on the cached repository
+// when this run checks out the whole worktree.
+// The sparse checkout of this run is kept, as git keeps it across checkouts and resets,
+// and sparsify replaces the directories of the previous run.
+func (b *execGit) unsparsify() error {
+	if len(b.g.SparseCheckoutDirectories) > 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	out, err := b.git(ctx, "config", "--type=bool", "--default=false", "core.sparseCheckout")
+	if err != nil {
+		return fmt.Errorf("unable to read core.sparseCheckout: %w", err)
+	}
+
+	if strings.TrimSpace(out) != "true" {
+		return nil
+	}
+
+	if _, err := b.git(ctx, "sparse-checkout", "disable"); err != nil {
+		return fmt.Errorf("unable to disable the sparse checkout: %w", err)
+	}
+
+	b.sparse = false
+
+	return nil
+}
+
+func (b *execGit) repository() (*git.Repository, error) {
+	// We open the repository every time, as go-git caches the list of packfiles
+	// and would not see the objects added by the git binary afterwards.
+	r, err := git.PlainOpen(b.g.getLocalRepoPath())
+	if err != nil {
+		return nil, fmt.Errorf("unable to open local git repository: %w", err)
+	}
+
+	return r, nil
+}
+
+func (b *execGit) worktree() (*git.Worktree, error) {
+	r, err := b.repository()
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get worktree: %w", err)
+	}
+
+	return w, nil
+}
+
+func (b *execGit) filesystem() (billy.Filesystem, error) {
+	return osfs.New(b.g.getLocalRepoPath()), nil
+}
+
+func (b *execGit) revParse(ctx context.Context, rev string) (plumbing.Hash, error) {
+	out, err := b.git(ctx, "rev-parse", "--verify", rev+"^{commit}")
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to resolve %s: %w", rev, err)
+	}
+
+	return plumbing.NewHash(strings.TrimSpace(out)), nil
+}
+
+// git runs the git binary with the args within the local repository.
+func (b *execGit) git(ctx context.Context, args ...string) (string, error) {
+	return b.run(ctx, b.g.getLocalRepoPath(), args...)
+}
+
+func (b *execGit) run(ctx context.Context, dir string, args ...string) (string, error) {
+	return b.output(b.command(ctx, dir, args...))
+}
+
+func (b *execGit) command(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	bin := b.g.GitBin
+	if bin == "" {
+		bin = "git"
+	}
+
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	// Pass the credentials via an HTTP header set in the environment of the git process,
+	// so that they are neither persisted to the repository config, nor visible in the remote URL,
+	// nor in the command line that other local users can read via ps.
+	// GIT_CONFIG_COUNT requires git 2.31 or later.
+	if auth, ok := b.g.Auth.(*http.BasicAuth); ok && auth != nil && auth.Password != "" {
+		cred := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+		cmd.Env = append(cmd.Env, configEnv("http.extraHeader", "Authorization: Basic "+cred)...)
+	}
+
+	return cmd
+}
+
+// configEnv returns the environment variables that set the git config in addition to
+// the ones already set via GIT_CONFIG_COUNT in the environment of gitimpart.
+func configEnv(key, value string) []string {
+	n, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	if err != nil || n < 0 {
+		n = 0
+	}
+
+	return []string{
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", n, key),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n, value),
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+1),
+	}
+}
+
+// output runs the command and returns its stdout.
+// The stdout is returned even when the command fails, as some commands like push
+// report the details of the failure there.
+func (b *execGit) output(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		// We intentionally omit the command line from the error,
+		// as it may contain the long list of the files to add.
+		return stdout.String(), fmt.Errorf("git %s: %w: %s", subcommand(cmd.Args), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// subcommand returns the git subcommand like "push" from the command line,
+// skipping the global options.
+func subcommand(args []string) string {
+	for i := 1; i < len(args); i++ {
+		if args[i] == "-c" {
+			i++
+			continue
+		}
+		return args[i]
+	}
+
+	return ""
+}
@@ -7,21 +7,16 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/go-git/go-billy/v5"
-	"github.com/go-git/go-billy/v5/memfs"
-	"github.com/go-git/go-billy/v5/osfs"
 	git "github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/cache"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/storage"
-	"github.com/go-git/go-git/v5/storage/filesystem"
-	"github.com/go-git/go-git/v5/storage/memory"
 )
 
 // Git is a key-value-store-like interface for gitops config repository.
@@ -32,13 +27,17 @@ type Git struct {
 	// It needs to be a URL that can be handled by go-git and git-clone.
 	GitRepoURL string
 
-	// repository is the local git repository that contains the gitops config
-	// It is cloned from gitRepoURL.
-	// It is not nil only after Clone() has succeeded.
-	repository *git.Repository
+	// Backend is the git backend to clone, fetch, checkout, commit, and push.
+	// It is either BackendGoGit or BackendExec. Defaults to BackendGoGit.
+	Backend string
 
-	// worktree is the local git worktree that contains the gitops config
-	worktree *git.Worktree
+	// GitBin is the path to the git binary used by BackendExec.
+	// Defaults to "git".
+	GitBin string
+
+	// backend is the implementation of Backend.
+	// It is not nil only after clone() has succeeded.
+	backend backend
 
 	// AuthorName is the username to be used when committing changes to the gitops config
 	// It is usually the name of the bot user, with or without an email address,
@@ -47,6 +46,21 @@ type Git struct {
 
 	AuthorEmail string
 
+	// CommitterName and CommitterEmail are the committer of the commits.
+	// They default to AuthorName and AuthorEmail.
+	CommitterName  string
+	CommitterEmail string
+
+	// Trailers are the lines like "Co-authored-by: Name <email>" appended to the commit messages.
+	Trailers []string
+
+	// SignOff appends the Signed-off-by trailer of the committer to the commit messages.
+	SignOff bool
+
+	// SigningKey is the key to sign the commits with.
+	// If nil, the commits are not signed.
+	SigningKey *SigningKey
+
 	baseBranch string
 	// BaseRefName is the name of the branch that contains the gitops config
 	// It is usually "master" or "main".
@@ -55,19 +69,135 @@ type Git struct {
 	newBranch  string
 	NewRefName *plumbing.ReferenceName
 
+	// BranchTemplate is the Go template of the feature branch name, like DefaultBranchTemplate.
+	// It is used when NewRefName is nil, after the changes are rendered,
+	// so that it can refer to the content hash. See BranchTemplateData for the available data.
+	// If empty, the changes are pushed to the base branch.
+	BranchTemplate string
+	// BranchVars is available to BranchTemplate as .Vars.
+	BranchVars map[string]string
+	// BranchID is available to BranchTemplate as .ID.
+	BranchID string
+	// BranchCollision is what to do when the branch rendered from BranchTemplate already exists on the remote.
+	// It is either BranchCollisionSuffix or BranchCollisionReuse. Defaults to BranchCollisionSuffix.
+	BranchCollision string
+	// branchTime is available to BranchTemplate as .Timestamp. Defaults to the time the branch is created.
+	branchTime time.Time
+	// branchReused is true when the feature branch already exists on the remote and is overwritten.
+	branchReused bool
+
 	// GitRoot is the root of the local git repository, used to
 	// clone and checkout the remote repository that contains the gitops config
 	// or the kustomize config we are going to modify.
 	// If empty, we will use in-memory filesystem.
+	//
+	// The repository is cloned into a directory keyed by GitRepoURL under GitRoot,
+	// so that GitRoot can be used as a persistent cache across runs.
+	// When the repository already exists, it is fetched and reset to the remote base branch instead.
+	// The repository is locked from Transact until Close.
 	GitRoot string
 	// cloned is true when the git repository has been cloned.
 	cloned bool
+	// reused is true when the git repository had been cloned by a previous run.
+	reused bool
 
 	// Push specifies whether the gitops config is updated via git push.
 	Push bool
 
 	// DryRun instructs the git store to print the changes that would be made without actually making them.
 	DryRun bool
+
+	// DryRunOutput is where the changes are printed to in dry-run mode. Defaults to os.Stdout.
+	DryRunOutput io.Writer
+	// DiffFormat is the format of the changes printed in dry-run mode.
+	// It is one of DiffFormatPatch, DiffFormatStat, DiffFormatPatchWithStat, DiffFormatSemantic, and DiffFormatJSON.
+	// Defaults to DiffFormatPatch.
+	DiffFormat string
+	// DiffColor colorizes the unified diff printed in dry-run mode.
+	DiffColor bool
+	// TextConv converts the contents of the files shown in the diffs, like the ones of encrypted files.
+	// The diffs in dry-run mode and in CommitResult are converted, while the commit is not.
+	TextConv TextConv
+	// Redactor masks the secrets in the diffs, the same way as TextConv does,
+	// and in the pull request title and body.
+	Redactor *Redactor
+
+	// Depth limits the clone and fetches to the specified number of commits from the tip of the branch.
+	// 0 means the full history.
+	Depth int
+
+	// SingleBranch instructs the clone to fetch only the base branch.
+	SingleBranch bool
+
+	// Filter is the partial clone filter spec, like "blob:none".
+	// It is ignored by go-git, which does not support partial clones.
+	Filter string
+
+	// SparseCheckoutDirectories limits the checkout to the specified directories.
+	// "." checks out the files at the root of the repository, but not the directories under it.
+	// The files outside of the directories are kept in the commit as-is.
+	// If empty, the whole worktree is checked out.
+	SparseCheckoutDirectories []string
+
+	// MaxRetries is the number of times Commit retries the push when it is rejected
+	// as a non-fast-forward update, which happens when someone else pushed to the branch
+	// after we cloned it.
+	// On each retry, the base branch is fetched, the worktree is reset to the new base,
+	// the Transact callback is re-run, and the changes are committed and pushed again.
+	MaxRetries int
+
+	// RetryBackoff is the duration to wait before the first retry.
+	// It doubles on each subsequent retry.
+	RetryBackoff time.Duration
+
+	// render is the function passed to Transact.
+	// It is kept to re-render the changes on top of the new base on retry.
+	render func(path string) (*RenderResult, error)
+
+	// baseHash is the commit of the base branch the changes were rendered on.
+	baseHash plumbing.Hash
+
+	// unlock releases the lock on the local repository.
+	unlock func() error
+
+	// err is the error that occurred while configuring the store, like failing to read the signing key.
+	// It is returned from Transact, as Make does not return errors.
+	err error
+
+	// lastCommit is the commit made by the last successful Commit.
+	lastCommit *CommitResult
+
+	// rendered is the set of files written by the last render,
+	// mapped to the hash of the blob the render produced.
+	// Deleted files are mapped to plumbing.ZeroHash.
+	rendered map[string]plumbing.Hash
+}
+
+// ErrNoChanges is returned by Commit when the rendered files are identical to the base branch.
+// Nothing is committed, pushed, or proposed as a pull request in that case.
+var ErrNoChanges = errors.New("no changes: the rendered files are identical to the base branch")
+
+const (
+	// DefaultAuthorName is the name of the commit author used when none is configured.
+	DefaultAuthorName = appName + "bot"
+	// DefaultAuthorEmail is the email of the commit author used when none is configured.
+	DefaultAuthorEmail = appName + "bot@users.noreply.github.com"
+)
+
+// ConflictError is returned by Commit when the base branch has moved
+// and re-rendering on top of it would overwrite the changes made there
+// to the same files.
+type ConflictError struct {
+	// Files is the list of files modified both by the render and on the base branch.
+	Files []string
+	// Base is the commit the changes were originally rendered on.
+	Base string
+	// Upstream is the commit the base branch has moved to.
+	Upstream string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("rendered files conflict with changes made on the base branch between %s and %s: %s", e.Base, e.Upstream, strings.Join(e.Files, ", "))
 }
 
 func NewGit(auth transport.AuthMethod, baseBranch, newBranch, gitRepoURL, authorUserName, authorEmail, gitRoot string, push bool) *Git {
@@ -98,21 +228,16 @@ func NewGit(auth transport.AuthMethod, baseBranch, newBranch, gitRepoURL, author
 }
 
 func (g *Git) Transact(fn func(path string) (*RenderResult, error)) (*RenderResult, error) {
-	w, err := g.createAndCheckoutNewBranch("")
-	if err != nil {
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	if _, err := g.createAndCheckoutNewBranch(""); err != nil {
 		var msg string
-		if g.repository != nil {
-			branches, err := g.repository.Branches()
+		if g.backend != nil {
+			branches, err := g.backend.branches()
 			if err != nil {
-				return nil, fmt.Errorf("unable to get branches: %w", err)
-			}
-
-			var bs []string
-			if err := branches.ForEach(func(b *plumbing.Reference) error {
-				bs = append(bs, b.Name().String())
-				return nil
-			}); err != nil {
-				return nil, fmt.Errorf("unable to iterate over branches: %w", err)
+				return nil, err
 			}
 
 			msg = fmt.Sprintf("branches: %v", branches)
@@ -121,23 +246,64 @@ func (g *Git) Transact(fn func(path string) (*RenderResult, error)) (*RenderResu
 		return nil, fmt.Errorf("unable to create and/or checkout branch: %w: %s", err, msg)
 	}
 
-	r, err := fn(g.getLocalRepoPath())
+	g.render = fn
+
+	r, err := g.apply()
+	if err != nil {
+		return nil, err
+	}
+
+	if g.NewRefName == nil && g.BranchTemplate != "" {
+		if err := g.resolveBranch(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// apply runs the render function within the worktree and
+// stages the files added, modified, and deleted by it.
+func (g *Git) apply() (*RenderResult, error) {
+	r, err := g.render(g.getLocalRepoPath())
+	if err != nil {
+		// The render function may have written some files before failing, like when the policy rejects them.
+		// They are discarded so that the worktree is left clean.
+		if rerr := g.resetBase(g.baseHash); rerr != nil {
+			return nil, errors.Join(err, fmt.Errorf("unable to discard the changes: %w", rerr))
+		}
+		return nil, err
+	}
+
+	fs, err := g.backend.filesystem()
 	if err != nil {
 		return nil, err
 	}
 
+	rendered := map[string]plumbing.Hash{}
+
 	for _, f := range r.AddedOrModifiedFiles {
-		if _, err := w.Add(f); err != nil {
-			return nil, fmt.Errorf("unable to run git-add (chroot=%s, name=%s): %w", g.getLocalRepoPath(), f, err)
+		if err := g.backend.add(f); err != nil {
+			return nil, err
 		}
+
+		h, err := hashFile(fs, f)
+		if err != nil {
+			return nil, err
+		}
+		rendered[filepath.ToSlash(f)] = h
 	}
 
 	for _, f := range r.DeletedFiles {
-		if _, err := w.Remove(f); err != nil {
-			return nil, fmt.Errorf("unable to run git-rm: %w", err)
+		if err := g.backend.remove(f); err != nil {
+			return nil, err
 		}
+
+		rendered[filepath.ToSlash(f)] = plumbing.ZeroHash
 	}
 
+	g.rendered = rendered
+
 	return r, nil
 }
 
@@ -161,97 +327,474 @@ func (g *Git) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+// Commit commits the staged changes and pushes them to the remote.
+// It returns ErrNoChanges without committing anything when the rendered files are identical to the base branch.
+//
+// When the push is rejected as a non-fast-forward update,
+// it retries up to MaxRetries times by rebasing the changes onto the new base.
+// See MaxRetries for how the changes are rebased.
 func (g *Git) Commit(ctx context.Context, subject, body string) error {
 	if !g.Push {
 		return nil
 	}
 
-	w, err := g.getWorktree()
+	backoff := g.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		// Checked on every attempt, as the base branch may have got the same changes while retrying.
+		changed, err := g.hasChanges()
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return ErrNoChanges
+		}
+
+		err = g.commit(ctx, subject, body)
+		if err == nil || !g.isNonFastForward(err) || attempt >= g.MaxRetries {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Push rejected as non-fast-forward. Retrying in %s (%d/%d)\n", backoff, attempt+1, g.MaxRetries)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		if err := g.rebase(ctx); err != nil {
+			return fmt.Errorf("unable to rebase onto %s: %w", g.BaseRefName, err)
+		}
+	}
+}
+
+func (g *Git) commit(ctx context.Context, subject, body string) error {
+	author, committer := g.signatures()
+
+	hash, err := g.backend.commit(g.commitMessage(subject, body), author, committer)
 	if err != nil {
-		return fmt.Errorf("unable to get worktree: %w", err)
+		return err
 	}
 
-	hash, err := w.Commit(subject, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  g.AuthorName,
-			Email: g.AuthorEmail,
-			When:  time.Now(),
-		},
-	})
+	if err := g.recordCommit(hash); err != nil {
+		return err
+	}
+
+	patch, err := g.patch(hash)
 	if err != nil {
-		return fmt.Errorf("unable to commit: %w", err)
+		return err
 	}
 
-	baseHash, err := g.repository.Reference(g.BaseRefName, true)
+	g.lastCommit.Diff = newDiff(g.lastCommit.Ref, g.lastCommit.Base, patch)
+
+	if g.DryRun {
+		w := g.dryRunOutput()
+
+		// Unsigned commits are the default, so we only note the signing key to keep the patch as-is otherwise.
+		// The note would make the JSON invalid, so it is omitted there.
+		if g.SigningKey != nil && g.DiffFormat != DiffFormatJSON {
+			fmt.Fprintf(w, "The commit would be signed with the %s\n", g.SigningKey)
+		}
+
+		return writeDiff(w, g.DiffFormat, g.DiffColor, patch, g.lastCommit.Diff)
+	}
+
+	var refName plumbing.ReferenceName
+	if g.NewRefName == nil {
+		refName = g.BaseRefName
+	} else {
+		refName = *g.NewRefName
+	}
+
+	// The reused branch has been pushed by a previous run, which is not an ancestor of our commit.
+	if err := g.backend.push(ctx, refName, g.branchReused); err != nil {
+		g.lastCommit = nil
+		return err
+	}
+
+	g.lastCommit.Pushed = true
+
+	return nil
+}
+
+// patch returns the patch between the base commit and the commit, converted by TextConv and Redactor.
+func (g *Git) patch(hash plumbing.Hash) (fdiff.Patch, error) {
+	repo, err := g.backend.repository()
+	if err != nil {
+		return nil, err
+	}
+	baseCommit, err := repo.CommitObject(g.baseHash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get commit: %w", err)
+	}
+	headCommit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get commit: %w", err)
+	}
+	patch, err := baseCommit.Patch(headCommit)
 	if err != nil {
-		return fmt.Errorf("unable to get reference %v: %w", g.BaseRefName, err)
+		return nil, fmt.Errorf("unable to get patch: %w", err)
 	}
 
-	ref := plumbing.NewReferenceFromStrings(string(g.BaseRefName), hash.String())
-	if err := g.repository.Storer.SetReference(ref); err != nil {
-		return fmt.Errorf("unable to set reference %v: %w", ref, err)
+	return textConv(repo, patch, g.TextConv, g.Redactor)
+}
+
+func (g *Git) dryRunOutput() io.Writer {
+	if g.DryRunOutput == nil {
+		return os.Stdout
 	}
 
-	remote, err := g.repository.Remote("origin")
+	return g.DryRunOutput
+}
+
+// LastCommit returns the commit made by the last successful Commit.
+// It returns nil when Commit has not succeeded yet.
+func (g *Git) LastCommit() *CommitResult {
+	return g.lastCommit
+}
+
+// recordCommit records the commit so that LastCommit returns it once it is pushed.
+func (g *Git) recordCommit(hash plumbing.Hash) error {
+	files, err := g.changes()
 	if err != nil {
-		return fmt.Errorf("unable to get remote origin: %w", err)
+		return err
 	}
 
-	if g.DryRun {
-		baseCommit, err := g.repository.CommitObject(baseHash.Hash())
-		if err != nil {
-			return fmt.Errorf("unable to get commit: %w", err)
+	ref := g.BaseRefName
+	if g.NewRefName != nil {
+		ref = *g.NewRefName
+	}
+
+	g.lastCommit = &CommitResult{
+		Hash:  hash.String(),
+		Ref:   ref.String(),
+		Base:  g.baseHash.String(),
+		Files: files,
+	}
+
+	return nil
+}
+
+// changes returns the rendered files that differ from the base branch, sorted by path.
+func (g *Git) changes() ([]FileChange, error) {
+	baseTree, err := g.treeOf(g.baseHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileChange
+
+	for f, h := range g.rendered {
+		before := fileHash(baseTree, f)
+
+		switch {
+		case before == h:
+			continue
+		case h == plumbing.ZeroHash:
+			files = append(files, FileChange{Path: f, Status: FileDeleted})
+		case before == plumbing.ZeroHash:
+			files = append(files, FileChange{Path: f, Status: FileAdded})
+		default:
+			files = append(files, FileChange{Path: f, Status: FileModified})
 		}
-		headCommit, err := g.repository.CommitObject(hash)
-		if err != nil {
-			return fmt.Errorf("unable to get commit: %w", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	return files, nil
+}
+
+// signatures returns the author and the committer of the commit.
+func (g *Git) signatures() (*object.Signature, *object.Signature) {
+	now := time.Now()
+
+	author := &object.Signature{
+		Name:  g.AuthorName,
+		Email: g.AuthorEmail,
+		When:  now,
+	}
+	if author.Name == "" {
+		author.Name = DefaultAuthorName
+	}
+	if author.Email == "" {
+		author.Email = DefaultAuthorEmail
+	}
+
+	committer := &object.Signature{
+		Name:  g.CommitterName,
+		Email: g.CommitterEmail,
+		When:  now,
+	}
+	if committer.Name == "" {
+		committer.Name = author.Name
+	}
+	if committer.Email == "" {
+		committer.Email = author.Email
+	}
+
+	return author, committer
+}
+
+// commitMessage returns the commit message made of the subject, the body, and the trailers.
+func (g *Git) commitMessage(subject, body string) string {
+	msg := strings.TrimSpace(subject)
+
+	if body = strings.TrimSpace(body); body != "" {
+		msg += "\n\n" + body
+	}
+
+	trailers := g.Trailers
+	if g.SignOff {
+		_, committer := g.signatures()
+		trailers = append(trailers[:len(trailers):len(trailers)], fmt.Sprintf("Signed-off-by: %s <%s>", committer.Name, committer.Email))
+	}
+
+	if len(trailers) > 0 {
+		msg += "\n\n" + strings.Join(trailers, "\n")
+	}
+
+	return msg + "\n"
+}
+
+// rebase fetches the base branch, resets the worktree to it,
+// and re-runs the render function on top of it.
+//
+// It returns a ConflictError when the base branch has changed any of the rendered files
+// in a way that re-rendering would discard.
+func (g *Git) rebase(ctx context.Context) error {
+	upstream, err := g.resetToUpstream(ctx)
+	if err != nil {
+		return err
+	}
+
+	if g.NewRefName != nil {
+		if err := g.backend.createBranch(*g.NewRefName); err != nil {
+			return err
 		}
-		patch, err := baseCommit.Patch(headCommit)
-		if err != nil {
-			return fmt.Errorf("unable to get patch: %w", err)
+	}
+
+	previous := g.rendered
+	base := g.baseHash
+
+	g.baseHash = upstream
+
+	if _, err := g.apply(); err != nil {
+		return fmt.Errorf("unable to re-render: %w", err)
+	}
+
+	return g.checkConflicts(base, upstream, previous)
+}
+
+// resetToUpstream fetches the base branch from the remote,
+// and checks it out discarding any local commits, changes, and untracked files.
+// It returns the commit the base branch points to on the remote.
+func (g *Git) resetToUpstream(ctx context.Context) (plumbing.Hash, error) {
+	upstream, err := g.backend.fetch(ctx, g.BaseRefName)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := g.resetBase(upstream); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return upstream, nil
+}
+
+// resetBase checks out the base branch at the commit, discarding any local commits, changes, and untracked files.
+func (g *Git) resetBase(hash plumbing.Hash) error {
+	dirs := g.SparseCheckoutDirectories
+
+	// The sparse checkout left by a previous run on the cached repository is cleared, too.
+	if err := g.backend.unsparsify(); err != nil {
+		return err
+	}
+
+	if err := g.backend.reset(g.BaseRefName, hash); err != nil {
+		return err
+	}
+
+	if len(dirs) > 0 {
+		if err := g.backend.sparsify(dirs); err != nil {
+			return err
 		}
+	}
 
-		fmt.Println(patch.String())
-		return nil
+	return nil
+}
+
+// pruneBranches deletes the local branches created by gitimpart in previous runs,
+// so that the repository reused from a previous run is in the same state as a fresh clone.
+// Only the new branch and the feature branches the BranchTemplate generates for the base branch are deleted,
+// so that the other branches in the GitRoot, like the ones created by hand, are kept.
+func (g *Git) pruneBranches() error {
+	pattern, err := g.branchPattern()
+	if err != nil {
+		return err
 	}
 
-	if !g.Push {
+	branches, err := g.backend.branches()
+	if err != nil {
+		return err
+	}
+
+	for _, b := range branches {
+		if b == g.BaseRefName {
+			continue
+		}
+
+		if g.newBranch != "" && b == branchRefName(g.newBranch) || pattern != nil && pattern.MatchString(b.Short()) {
+			if err := g.backend.deleteBranch(b); err != nil {
+				return fmt.Errorf("unable to delete branch %q: %w", b, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close releases the lock on the local repository acquired by Transact.
+// It is safe to call Close multiple times.
+func (g *Git) Close() error {
+	if g.unlock == nil {
 		return nil
 	}
 
-	var refName plumbing.ReferenceName
-	if g.NewRefName == nil {
-		refName = g.BaseRefName
-	} else {
-		refName = *g.NewRefName
+	err := g.unlock()
+	g.unlock = nil
+
+	return err
+}
+
+// checkConflicts returns a ConflictError if any of the files rendered on the base commit
+// was modified between base and upstream, and the new render still produced
+// the previous content, which means the render would discard the upstream change.
+//
+// Files whose new render differs from the previous one, like a kustomization.yaml
+// that is updated via kustomize-edit, are considered to have incorporated the upstream change.
+func (g *Git) checkConflicts(base, upstream plumbing.Hash, previous map[string]plumbing.Hash) error {
+	baseTree, err := g.treeOf(base)
+	if err != nil {
+		return err
+	}
+
+	upstreamTree, err := g.treeOf(upstream)
+	if err != nil {
+		return err
+	}
+
+	var conflicts []string
+
+	for f, prev := range previous {
+		before := fileHash(baseTree, f)
+		after := fileHash(upstreamTree, f)
+
+		if before == after {
+			continue
+		}
+
+		if cur := g.rendered[f]; cur == prev && cur != after {
+			conflicts = append(conflicts, f)
+		}
 	}
 
-	if err := remote.Push(&git.PushOptions{
-		Progress: os.Stdout,
-		RefSpecs: []config.RefSpec{
-			config.RefSpec(refName + ":" + refName),
-		},
-		Auth: g.Auth,
-	}); err != nil {
-		return fmt.Errorf("unable to push %v to remote origin: %w", *g.NewRefName, err)
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+
+		return &ConflictError{
+			Files:    conflicts,
+			Base:     base.String(),
+			Upstream: upstream.String(),
+		}
 	}
 
 	return nil
 }
 
-func (g *Git) getWorktree() (*git.Worktree, error) {
-	if g.worktree != nil {
-		return g.worktree, nil
+// hasChanges returns true if any of the rendered files differs from the base branch.
+func (g *Git) hasChanges() (bool, error) {
+	files, err := g.changes()
+	if err != nil {
+		return false, err
 	}
 
-	w, err := g.repository.Worktree()
+	return len(files) > 0, nil
+}
+
+func (g *Git) treeOf(h plumbing.Hash) (*object.Tree, error) {
+	repo, err := g.backend.repository()
 	if err != nil {
 		return nil, err
 	}
 
-	g.worktree = w
+	c, err := repo.CommitObject(h)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get commit %s: %w", h, err)
+	}
+
+	t, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get tree of commit %s: %w", h, err)
+	}
+
+	return t, nil
+}
+
+// fileHash returns the hash of the blob at path in the tree,
+// or plumbing.ZeroHash if the file does not exist.
+func fileHash(t *object.Tree, path string) plumbing.Hash {
+	f, err := t.File(path)
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+
+	return f.Hash
+}
+
+// hashFile returns the hash of the blob that git would store for the file at path,
+// or plumbing.ZeroHash if the path is a directory.
+func hashFile(fs billy.Filesystem, path string) (plumbing.Hash, error) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to stat file %q: %w", path, err)
+	}
+
+	if info.IsDir() {
+		return plumbing.ZeroHash, nil
+	}
+
+	f, err := fs.Open(path)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to open file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to read file %q: %w", path, err)
+	}
+
+	return plumbing.ComputeHash(plumbing.BlobObject, data), nil
+}
+
+// isNonFastForward returns true if the push was rejected because
+// the remote branch has commits that the local branch does not have.
+func (g *Git) isNonFastForward(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// In a shallow clone, go-git fails to find the remote commit
+	// while walking the history to see if the push is a fast-forward.
+	if g.Depth > 0 && errors.Is(err, plumbing.ErrObjectNotFound) {
+		return true
+	}
+
+	msg := err.Error()
 
-	return w, nil
+	return strings.Contains(msg, "non-fast-forward") || strings.Contains(msg, "fetch first")
 }
 
 func (s *Git) GetFileFromBranch(branch, path string) ([]byte, error) {
@@ -345,41 +888,23 @@ func (s *Git) ModifyWorktree(branch, message string, fn func(*git.Worktree) erro
 		return fmt.Errorf("unable to verify git status: %w", err)
 	}
 
-	hash, err := w.Commit(
-		message,
-		&git.CommitOptions{
-			Author: &object.Signature{
-				Name:  s.AuthorName,
-				Email: "",
-				When:  time.Now(),
-			},
-		})
-	if err != nil {
-		return fmt.Errorf("unable to commit: %w", err)
-	}
+	author, committer := s.signatures()
 
-	ref := plumbing.NewReferenceFromStrings(branch, hash.String())
-	if err := s.repository.Storer.SetReference(ref); err != nil {
-		return fmt.Errorf("unable to set reference %v: %w", ref, err)
+	if _, err := s.backend.commit(s.commitMessage(message, ""), author, committer); err != nil {
+		return err
 	}
 
-	remote, err := s.repository.Remote("origin")
-	if err != nil {
-		return fmt.Errorf("unable to get remote origin: %w", err)
-	}
+	return s.backend.push(context.Background(), branchRefName(branch), false)
+}
 
-	refSpec := config.RefSpec(plumbing.ReferenceName(branch) + ":" + plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch)))
-	if err := remote.Push(&git.PushOptions{
-		Progress: os.Stdout,
-		RefSpecs: []config.RefSpec{
-			refSpec,
-		},
-		Auth: s.Auth,
-	}); err != nil {
-		return fmt.Errorf("unable to push %v to remote origin: %w", refSpec, err)
+// branchRefName returns the reference name of the branch,
+// which can be either a short name like "main" or a full name like "refs/heads/main".
+func branchRefName(branch string) plumbing.ReferenceName {
+	if strings.HasPrefix(branch, "refs/") {
+		return plumbing.ReferenceName(branch)
 	}
 
-	return nil
+	return plumbing.NewBranchReferenceName(branch)
 }
 
 func (s *Git) getLocalRepoPath() string {
@@ -393,43 +918,36 @@ func (s *Git) getLocalRepoPath() string {
 }
 
 func (s *Git) clone() error {
-	var (
-		storage storage.Storer
-		fs      billy.Filesystem
-	)
+	b, err := newBackend(s)
+	if err != nil {
+		return err
+	}
 
 	if s.GitRoot != "" {
-		gitRoot := s.getLocalRepoPath()
-		fs = osfs.New(gitRoot)
-		storage = filesystem.NewStorage(
-			osfs.New(filepath.Join(gitRoot, ".git")),
-			cache.NewObjectLRUDefault(),
-		)
-	} else {
-		storage = memory.NewStorage()
-		fs = memfs.New()
-	}
-	r, err := git.Clone(storage, fs, &git.CloneOptions{
-		URL:  s.GitRepoURL,
-		Auth: s.Auth,
-	})
+		dir := s.getLocalRepoPath()
 
-	if errors.Is(err, git.ErrRepositoryAlreadyExists) {
-		r, err = git.PlainOpen(s.getLocalRepoPath())
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return fmt.Errorf("unable to create directory for %s: %w", dir, err)
+		}
+
+		// Concurrent runs on the same machine can share the GitRoot.
+		// We lock the repository until Close so that they do not corrupt each other's worktree.
+		unlock, err := lockFile(dir + ".lock")
 		if err != nil {
-			return fmt.Errorf("unable to open local git repository: %w", err)
+			return fmt.Errorf("unable to lock %s: %w", dir, err)
 		}
-	} else if err != nil {
-		return fmt.Errorf("unable to clone git repository %s: %w", s.GitRepoURL, err)
+		s.unlock = unlock
 	}
 
-	s.repository = r
+	reused, err := b.open(context.Background())
+	if err != nil {
+		return err
+	}
 
-	return nil
-}
+	s.backend = b
+	s.reused = reused
 
-func (s *Git) deleteBranch(branch string) (err error) {
-	return s.repository.Storer.RemoveReference(plumbing.ReferenceName(branch))
+	return nil
 }
 
 func (s *Git) createAndCheckoutNewBranch(branch string) (*git.Worktree, error) {
@@ -441,72 +959,50 @@ func (s *Git) createAndCheckoutNewBranch(branch string) (*git.Worktree, error) {
 		s.cloned = true
 	}
 
-	// if err := s.deleteBranch(branch); err != nil {
-	// 	fmt.Printf("Unable to delete branch %q: %v", branch, err)
-	// }
-
-	w, err := s.getWorktree()
+	upstream, err := s.resetToUpstream(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("unable to get worktree: %w", err)
+		return nil, err
 	}
 
-	if checkoutErr := w.Checkout(&git.CheckoutOptions{
-		Create: false,
-		Branch: s.BaseRefName,
-	}); checkoutErr != nil {
-		remote, err := s.repository.Remote("origin")
-		if err != nil {
-			return nil, fmt.Errorf("unable to get remote origin: %w", err)
-		}
-
-		if err := remote.Fetch(&git.FetchOptions{
-			Auth: s.Auth,
-			RefSpecs: []config.RefSpec{
-				config.RefSpec(s.BaseRefName + ":" + s.BaseRefName),
-			},
-		}); err != nil {
-			return nil, fmt.Errorf("unable to checkout %s: %w\nunable to fetch from remote origin: %w", s.BaseRefName, checkoutErr, err)
-		}
+	s.baseHash = upstream
 
-		if err := w.Checkout(&git.CheckoutOptions{
-			Create: false,
-			Branch: s.BaseRefName,
-		}); err != nil {
-			return nil, fmt.Errorf("unable to checkout branch %q: %w", s.BaseRefName, err)
+	if s.reused {
+		if err := s.pruneBranches(); err != nil {
+			return nil, err
 		}
-	}
 
-	if err := w.Pull(&git.PullOptions{
-		RemoteName: "origin",
-		Auth:       s.Auth,
-	}); err != nil && err != git.NoErrAlreadyUpToDate {
-		return nil, fmt.Errorf("unable to pull from remote origin: %w", err)
+		s.reused = false
 	}
 
 	var b *plumbing.ReferenceName
 
 	if branch != "" {
-		n := plumbing.ReferenceName(branch)
+		n := branchRefName(branch)
 		b = &n
 	} else if s.NewRefName != nil {
 		b = s.NewRefName
 	}
 
 	if b != nil {
-		// h, err := s.repository.Head()
-		// if err != nil {
-		// 	return nil, fmt.Errorf("unable to resolve revision %q: %w", s.BaseRefName, err)
-		// }
-		if err := w.Checkout(&git.CheckoutOptions{
-			Create: true,
-			// Hash:   h.Hash(),
-			Branch: *b,
-		}); err != nil {
-			return nil, fmt.Errorf("unable to checkout branch %q: %w", *b, err)
+		if err := s.backend.createBranch(*b); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.backend.worktree()
+}
+
+// isUnderDirs returns true if the slash-separated path is one of the dirs or under any of them.
+// The dir "." contains the files at the root only, as in git's cone-mode sparse checkout.
+func isUnderDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		dir = strings.TrimSuffix(filepath.ToSlash(dir), "/")
+		if dir == "." && !strings.Contains(path, "/") || path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
 		}
 	}
 
-	return w, nil
+	return false
 }
 
 func (s *Git) verify(w *git.Worktree) error {
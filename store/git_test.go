@@ -2,12 +2,21 @@ package store
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 	"time"
 
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/stretchr/testify/require"
 )
@@ -92,3 +101,517 @@ func TestGit(t *testing.T) {
 		require.Empty(t, r2.AddedOrModifiedFiles)
 	})
 }
+
+// newRemote creates a bare repository that serves as the remote origin in tests,
+// with a main branch that contains the given files.
+func newRemote(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "remote.git")
+
+	r, err := git.PlainInit(dir, true)
+	require.NoError(t, err)
+	require.NoError(t, r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Main)))
+
+	pushToRemote(t, dir, files)
+
+	return dir
+}
+
+// pushToRemote commits the given files to the main branch of the remote,
+// emulating someone else pushing to the remote.
+func pushToRemote(t *testing.T, remote string, files map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	r, err := git.PlainClone(dir, false, &git.CloneOptions{URL: remote})
+	if err != nil {
+		require.ErrorIs(t, err, transport.ErrEmptyRemoteRepository)
+
+		r, err = git.PlainInit(dir, false)
+		require.NoError(t, err)
+
+		_, err = r.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{remote}})
+		require.NoError(t, err)
+
+		require.NoError(t, r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Main)))
+	}
+
+	w, err := r.Worktree()
+	require.NoError(t, err)
+
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+		_, err := w.Add(name)
+		require.NoError(t, err)
+	}
+
+	_, err = w.Commit("update", &git.CommitOptions{
+		Author: &object.Signature{Name: "someone", Email: "someone@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, r.Push(&git.PushOptions{
+		RefSpecs: []gitconfig.RefSpec{"refs/heads/main:refs/heads/main"},
+	}))
+}
+
+// readRemote returns the content of the file in the main branch of the remote.
+func readRemote(t *testing.T, remote, branch, name string) string {
+	t.Helper()
+
+	r, err := git.PlainClone(t.TempDir(), false, &git.CloneOptions{
+		URL:           remote,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+	})
+	require.NoError(t, err)
+
+	w, err := r.Worktree()
+	require.NoError(t, err)
+
+	f, err := w.Filesystem.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+
+	return string(b)
+}
+
+func writeFiles(files map[string]string) func(string) (*RenderResult, error) {
+	return func(dir string) (*RenderResult, error) {
+		var r RenderResult
+		for name, content := range files {
+			p := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				return nil, err
+			}
+			r.AddedOrModifiedFiles = append(r.AddedOrModifiedFiles, name)
+		}
+		return &r, nil
+	}
+}
+
+func TestGit_RetryOnNonFastForward(t *testing.T) {
+	forEachBackend(t, testGitRetryOnNonFastForward)
+}
+
+func testGitRetryOnNonFastForward(t *testing.T, backend string) {
+	t.Run("rebase", func(t *testing.T) {
+		remote := newRemote(t, map[string]string{"README.md": "readme"})
+
+		g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+		g.Backend = backend
+		g.MaxRetries = 1
+
+		_, err := g.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+		require.NoError(t, err)
+
+		pushToRemote(t, remote, map[string]string{"b.txt": "b"})
+
+		require.NoError(t, g.Commit(context.Background(), "add a.txt", ""))
+
+		require.Equal(t, "a", readRemote(t, remote, "main", "a.txt"))
+		require.Equal(t, "b", readRemote(t, remote, "main", "b.txt"))
+	})
+
+	t.Run("no retries", func(t *testing.T) {
+		remote := newRemote(t, map[string]string{"README.md": "readme"})
+
+		g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+		g.Backend = backend
+
+		_, err := g.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+		require.NoError(t, err)
+
+		pushToRemote(t, remote, map[string]string{"b.txt": "b"})
+
+		err = g.Commit(context.Background(), "add a.txt", "")
+		require.Error(t, err)
+		require.True(t, g.isNonFastForward(err), "unexpected error: %v", err)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		remote := newRemote(t, map[string]string{"a.txt": "original"})
+
+		g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+		g.Backend = backend
+		g.MaxRetries = 3
+
+		_, err := g.Transact(writeFiles(map[string]string{"a.txt": "ours"}))
+		require.NoError(t, err)
+
+		pushToRemote(t, remote, map[string]string{"a.txt": "theirs"})
+
+		err = g.Commit(context.Background(), "update a.txt", "")
+
+		var conflict *ConflictError
+		require.ErrorAs(t, err, &conflict)
+		require.Equal(t, []string{"a.txt"}, conflict.Files)
+		require.Equal(t, "theirs", readRemote(t, remote, "main", "a.txt"))
+	})
+}
+
+func TestGit_ShallowSparse(t *testing.T) {
+	forEachBackend(t, testGitShallowSparse)
+}
+
+func testGitShallowSparse(t *testing.T, backend string) {
+	remote := newRemote(t, map[string]string{"README.md": "readme", "x/1.txt": "1", "y/2.txt": "2"})
+	pushToRemote(t, remote, map[string]string{"z.txt": "z"})
+
+	g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+	g.Backend = backend
+	g.Depth = 1
+	g.SingleBranch = true
+	g.SparseCheckoutDirectories = []string{"x"}
+	g.MaxRetries = 1
+
+	_, err := g.Transact(func(dir string) (*RenderResult, error) {
+		_, err := os.Stat(filepath.Join(dir, "y", "2.txt"))
+		require.True(t, os.IsNotExist(err), "y/2.txt should not be checked out")
+
+		return writeFiles(map[string]string{"x/a.txt": "a", "root.txt": "root"})(dir)
+	})
+	require.NoError(t, err)
+
+	pushToRemote(t, remote, map[string]string{"q.txt": "q"})
+
+	require.NoError(t, g.Commit(context.Background(), "add x/a.txt", ""))
+
+	require.Equal(t, "a", readRemote(t, remote, "main", "x/a.txt"))
+	require.Equal(t, "root", readRemote(t, remote, "main", "root.txt"))
+	require.Equal(t, "2", readRemote(t, remote, "main", "y/2.txt"))
+	require.Equal(t, "q", readRemote(t, remote, "main", "q.txt"))
+}
+
+func TestGit_SparseRoot(t *testing.T) {
+	forEachBackend(t, testGitSparseRoot)
+}
+
+func testGitSparseRoot(t *testing.T, backend string) {
+	remote := newRemote(t, map[string]string{"README.md": "readme", "x/1.txt": "1", "y/2.txt": "2"})
+
+	g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+	g.Backend = backend
+	g.SparseCheckoutDirectories = []string{"."}
+
+	_, err := g.Transact(func(dir string) (*RenderResult, error) {
+		b, err := os.ReadFile(filepath.Join(dir, "README.md"))
+		require.NoError(t, err)
+		require.Equal(t, "readme", string(b))
+
+		_, err = os.Stat(filepath.Join(dir, "x", "1.txt"))
+		require.True(t, os.IsNotExist(err), "x/1.txt should not be checked out")
+
+		return writeFiles(map[string]string{"README.md": "updated"})(dir)
+	})
+	require.NoError(t, err)
+	require.NoError(t, g.Commit(context.Background(), "update README.md", ""))
+
+	require.Equal(t, "updated", readRemote(t, remote, "main", "README.md"))
+	require.Equal(t, "1", readRemote(t, remote, "main", "x/1.txt"))
+	require.Equal(t, "2", readRemote(t, remote, "main", "y/2.txt"))
+}
+
+func TestGit_ReuseCache(t *testing.T) {
+	forEachBackend(t, testGitReuseCache)
+
+	t.Run("sparse then whole", func(t *testing.T) {
+		forEachBackend(t, testGitReuseSparseCache)
+	})
+}
+
+func testGitReuseSparseCache(t *testing.T, backend string) {
+	remote := newRemote(t, map[string]string{"x/1.txt": "1", "y/2.txt": "2"})
+	gitRoot := t.TempDir()
+
+	g := NewGit(nil, "main", "", remote, "test author", "test@example.com", gitRoot, true)
+	g.Backend = backend
+	g.SparseCheckoutDirectories = []string{"x"}
+	_, err := g.Transact(writeFiles(map[string]string{"x/a.txt": "a"}))
+	require.NoError(t, err)
+	require.NoError(t, g.Commit(context.Background(), "add x/a.txt", ""))
+	require.NoError(t, g.Close())
+
+	// The next run without the sparse checkout sees and writes the whole worktree.
+	g2 := NewGit(nil, "main", "", remote, "test author", "test@example.com", gitRoot, true)
+	g2.Backend = backend
+	t.Cleanup(func() { require.NoError(t, g2.Close()) })
+
+	_, err = g2.Transact(func(dir string) (*RenderResult, error) {
+		b, err := os.ReadFile(filepath.Join(dir, "y", "2.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "2", string(b))
+
+		return writeFiles(map[string]string{"y/b.txt": "b"})(dir)
+	})
+	require.NoError(t, err)
+	require.NoError(t, g2.Commit(context.Background(), "add y/b.txt", ""))
+
+	require.Equal(t, "b", readRemote(t, remote, "main", "y/b.txt"))
+	require.Equal(t, "1", readRemote(t, remote, "main", "x/1.txt"))
+	require.Equal(t, "2", readRemote(t, remote, "main", "y/2.txt"))
+}
+
+func testGitReuseCache(t *testing.T, backend string) {
+	remote := newRemote(t, map[string]string{"README.md": "readme"})
+	gitRoot := t.TempDir()
+
+	g := NewGit(nil, "main", "", remote, "test author", "test@example.com", gitRoot, true)
+	g.Backend = backend
+	g.BranchTemplate = "gitimpart/{{ .Target }}-{{ .ContentHash }}"
+	_, err := g.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+	require.NoError(t, err)
+	require.NoError(t, g.Commit(context.Background(), "add a.txt", ""))
+	first := *g.NewRefName
+
+	// A branch not generated by gitimpart is kept even if it has the same prefix.
+	require.NoError(t, g.backend.createBranch(plumbing.NewBranchReferenceName("gitimpart-mine")))
+	require.NoError(t, g.Close())
+
+	// Leave garbage that the next run should not see
+	require.NoError(t, os.WriteFile(filepath.Join(g.getLocalRepoPath(), "untracked.txt"), []byte("x"), 0644))
+
+	pushToRemote(t, remote, map[string]string{"b.txt": "b"})
+
+	g2 := NewGit(nil, "main", "", remote, "test author", "test@example.com", gitRoot, true)
+	g2.Backend = backend
+	g2.BranchTemplate = g.BranchTemplate
+	t.Cleanup(func() { require.NoError(t, g2.Close()) })
+
+	_, err = g2.Transact(func(dir string) (*RenderResult, error) {
+		_, err := os.Stat(filepath.Join(dir, "untracked.txt"))
+		require.True(t, os.IsNotExist(err), "untracked.txt should have been cleaned")
+
+		_, err = os.Stat(filepath.Join(dir, "a.txt"))
+		require.True(t, os.IsNotExist(err), "a.txt from the previous feature branch should not be checked out")
+
+		b, err := os.ReadFile(filepath.Join(dir, "b.txt"))
+		require.NoError(t, err)
+		require.Equal(t, "b", string(b))
+
+		return writeFiles(map[string]string{"c.txt": "c"})(dir)
+	})
+	require.NoError(t, err)
+
+	branches, err := g2.backend.branches()
+	require.NoError(t, err)
+	require.NotContains(t, branches, first)
+	require.Contains(t, branches, plumbing.NewBranchReferenceName("gitimpart-mine"))
+
+	require.NoError(t, g2.Commit(context.Background(), "add c.txt", ""))
+	require.NotEqual(t, first, *g2.NewRefName)
+	require.Equal(t, "c", readRemote(t, remote, g2.NewRefName.Short(), "c.txt"))
+	require.Equal(t, "a", readRemote(t, remote, first.Short(), "a.txt"))
+}
+
+// forEachBackend runs the test against every git backend,
+// so that they are tested against the same remote repository fixtures.
+func forEachBackend(t *testing.T, fn func(t *testing.T, backend string)) {
+	for _, b := range []string{BackendGoGit, BackendExec} {
+		b := b
+		t.Run(b, func(t *testing.T) {
+			if b == BackendExec {
+				if _, err := exec.LookPath("git"); err != nil {
+					t.Skip("git is not installed")
+				}
+			}
+
+			fn(t, b)
+		})
+	}
+}
+
+func TestGit_BranchTemplate(t *testing.T) {
+	forEachBackend(t, testGitBranchTemplate)
+}
+
+func testGitBranchTemplate(t *testing.T, backend string) {
+	remote := newRemote(t, map[string]string{"README.md": "readme"})
+
+	push := func(collision string) string {
+		t.Helper()
+
+		g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+		g.Backend = backend
+		g.BranchTemplate = "gitimpart/{{ .Vars.app }}/{{ .Target }}-{{ .ContentHash }}"
+		g.BranchVars = map[string]string{"app": "myapp"}
+		g.BranchCollision = collision
+
+		_, err := g.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+		require.NoError(t, err)
+		require.NoError(t, g.Commit(context.Background(), "add a.txt", ""))
+
+		return g.NewRefName.Short()
+	}
+
+	first := push("")
+	require.Regexp(t, `^gitimpart/myapp/main-[0-9a-f]{8}$`, first)
+	require.Equal(t, "a", readRemote(t, remote, first, "a.txt"))
+
+	require.Equal(t, first+"-2", push(BranchCollisionSuffix))
+	require.Equal(t, "a", readRemote(t, remote, first+"-2", "a.txt"))
+
+	// The base branch has moved, so that reusing the branch requires a force push
+	pushToRemote(t, remote, map[string]string{"b.txt": "b"})
+
+	require.Equal(t, first, push(BranchCollisionReuse))
+	require.Equal(t, "b", readRemote(t, remote, first, "b.txt"))
+}
+
+func TestGit_BranchPattern(t *testing.T) {
+	g := NewGit(nil, "main", "", "", "", "", "", true)
+	g.BranchTemplate = "gitimpart/{{ .Vars.app }}/{{ .Target }}-{{ .ContentHash }}.{{ .Timestamp }}"
+	g.BranchVars = map[string]string{"app": "my.app"}
+
+	p, err := g.branchPattern()
+	require.NoError(t, err)
+
+	require.True(t, p.MatchString("gitimpart/my.app/main-0123abcd.20240102030405"))
+	require.True(t, p.MatchString("gitimpart/my.app/main-0123abcd.20240102030405-2"))
+	require.False(t, p.MatchString("gitimpart/myxapp/main-0123abcd.20240102030405"))
+	require.False(t, p.MatchString("gitimpart/my.app/dev-0123abcd.20240102030405"))
+	require.False(t, p.MatchString("gitimpart/my.app/main-fix"))
+	require.False(t, p.MatchString("gitimpart-mine"))
+
+	g.BranchTemplate = ""
+	p, err = g.branchPattern()
+	require.NoError(t, err)
+	require.Nil(t, p)
+}
+
+func TestGit_NoChanges(t *testing.T) {
+	forEachBackend(t, testGitNoChanges)
+}
+
+func testGitNoChanges(t *testing.T, backend string) {
+	t.Run("identical", func(t *testing.T) {
+		remote := newRemote(t, map[string]string{"a.txt": "a"})
+
+		g := NewGit(nil, "main", "gitimpart-1", remote, "test author", "test@example.com", t.TempDir(), true)
+		g.Backend = backend
+
+		_, err := g.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+		require.NoError(t, err)
+		require.ErrorIs(t, g.Commit(context.Background(), "add a.txt", ""), ErrNoChanges)
+
+		r, err := git.PlainOpen(remote)
+		require.NoError(t, err)
+		_, err = r.Reference(plumbing.NewBranchReferenceName("gitimpart-1"), false)
+		require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	})
+
+	t.Run("identical after rebase", func(t *testing.T) {
+		remote := newRemote(t, map[string]string{"README.md": "readme"})
+
+		g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+		g.Backend = backend
+		g.MaxRetries = 1
+
+		_, err := g.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+		require.NoError(t, err)
+
+		pushToRemote(t, remote, map[string]string{"a.txt": "a"})
+
+		require.ErrorIs(t, g.Commit(context.Background(), "add a.txt", ""), ErrNoChanges)
+	})
+}
+
+func TestGit_LastCommit(t *testing.T) {
+	forEachBackend(t, testGitLastCommit)
+}
+
+func testGitLastCommit(t *testing.T, backend string) {
+	remote := newRemote(t, map[string]string{"a.txt": "a", "b.txt": "b", "unchanged.txt": "u"})
+
+	g := NewGit(nil, "main", "gitimpart-1", remote, "test author", "test@example.com", t.TempDir(), true)
+	g.Backend = backend
+
+	_, err := g.Transact(func(dir string) (*RenderResult, error) {
+		r, err := writeFiles(map[string]string{"a.txt": "A", "c.txt": "c", "unchanged.txt": "u"})(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+			return nil, err
+		}
+		r.DeletedFiles = append(r.DeletedFiles, "b.txt")
+
+		return r, nil
+	})
+	require.NoError(t, err)
+	require.Nil(t, g.LastCommit())
+	require.NoError(t, g.Commit(context.Background(), "update", ""))
+
+	c := g.LastCommit()
+	require.NotNil(t, c)
+	require.True(t, c.Pushed)
+	require.Equal(t, "refs/heads/gitimpart-1", c.Ref)
+	require.Equal(t, []FileChange{
+		{Path: "a.txt", Status: FileModified},
+		{Path: "b.txt", Status: FileDeleted},
+		{Path: "c.txt", Status: FileAdded},
+	}, c.Files)
+
+	r, err := git.PlainOpen(remote)
+	require.NoError(t, err)
+	ref, err := r.Reference(plumbing.NewBranchReferenceName("gitimpart-1"), false)
+	require.NoError(t, err)
+	require.Equal(t, ref.Hash().String(), c.Hash)
+}
+
+func TestExecGit_Credentials(t *testing.T) {
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+
+	g := &Git{Auth: &http.BasicAuth{Username: "x-access-token", Password: "s3cret-token"}}
+	cmd := (&execGit{g: g}).command(context.Background(), t.TempDir(), "fetch", "origin")
+
+	// The credentials are not on the command line.
+	require.Equal(t, []string{"git", "fetch", "origin"}, cmd.Args)
+
+	require.Subset(t, cmd.Env, []string{
+		"GIT_CONFIG_KEY_1=http.extraHeader",
+		"GIT_CONFIG_VALUE_1=Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:s3cret-token")),
+		"GIT_CONFIG_COUNT=2",
+	})
+}
+
+func TestGit_TransactError(t *testing.T) {
+	forEachBackend(t, testGitTransactError)
+}
+
+func testGitTransactError(t *testing.T, backend string) {
+	remote := newRemote(t, map[string]string{"a.txt": "a"})
+
+	gitRoot := t.TempDir()
+
+	g := NewGit(nil, "main", "gitimpart-1", remote, "test author", "test@example.com", gitRoot, true)
+	g.Backend = backend
+	defer g.Close()
+
+	rejected := errors.New("rejected")
+
+	_, err := g.Transact(func(dir string) (*RenderResult, error) {
+		if _, err := writeFiles(map[string]string{"a.txt": "modified", "b.txt": "b"})(dir); err != nil {
+			return nil, err
+		}
+		return nil, rejected
+	})
+	require.ErrorIs(t, err, rejected)
+
+	// The files written before the error are discarded.
+	w, err := g.backend.worktree()
+	require.NoError(t, err)
+	status, err := w.Status()
+	require.NoError(t, err)
+	require.True(t, status.IsClean(), "%v", status)
+}
@@ -0,0 +1,444 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// goGit is the backend that uses go-git.
+type goGit struct {
+	g *Git
+
+	// repo is the local git repository that contains the gitops config.
+	// It is not nil only after open has succeeded.
+	repo *git.Repository
+
+	// wt is the local git worktree that contains the gitops config
+	wt *git.Worktree
+}
+
+func (b *goGit) open(ctx context.Context) (bool, error) {
+	var (
+		storage storage.Storer
+		fs      billy.Filesystem
+	)
+
+	if b.g.GitRoot != "" {
+		dir := b.g.getLocalRepoPath()
+		fs = osfs.New(dir)
+		storage = filesystem.NewStorage(
+			osfs.New(filepath.Join(dir, ".git")),
+			cache.NewObjectLRUDefault(),
+		)
+	} else {
+		storage = memory.NewStorage()
+		fs = memfs.New()
+	}
+
+	if b.g.Filter != "" {
+		fmt.Fprintf(os.Stderr, "Ignoring the partial clone filter %q as it is not supported by go-git\n", b.g.Filter)
+	}
+
+	opts := &git.CloneOptions{
+		URL:          b.g.GitRepoURL,
+		Auth:         b.g.Auth,
+		Depth:        b.g.Depth,
+		SingleBranch: b.g.SingleBranch,
+		// The base branch is checked out sparsely by reset.
+		NoCheckout: len(b.g.SparseCheckoutDirectories) > 0,
+	}
+	if b.g.SingleBranch {
+		opts.ReferenceName = b.g.BaseRefName
+	}
+
+	r, err := git.CloneContext(ctx, storage, fs, opts)
+	if errors.Is(err, git.ErrRepositoryAlreadyExists) {
+		r, err = git.PlainOpen(b.g.getLocalRepoPath())
+		if err != nil {
+			return false, fmt.Errorf("unable to open local git repository: %w", err)
+		}
+
+		b.repo = r
+
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to clone git repository %s: %w", b.g.GitRepoURL, err)
+	}
+
+	b.repo = r
+
+	return false, nil
+}
+
+func (b *goGit) fetch(ctx context.Context, branch plumbing.ReferenceName) (plumbing.Hash, error) {
+	remote, err := b.repo.Remote("origin")
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to get remote origin: %w", err)
+	}
+
+	remoteRefName := plumbing.NewRemoteReferenceName("origin", branch.Short())
+
+	if err := remote.FetchContext(ctx, &git.FetchOptions{
+		Auth: b.g.Auth,
+		RefSpecs: []config.RefSpec{
+			config.RefSpec("+" + branch + ":" + remoteRefName),
+		},
+		Force: true,
+	}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return plumbing.ZeroHash, fmt.Errorf("unable to fetch %s from remote origin: %w", branch, err)
+	}
+
+	ref, err := b.repo.Reference(remoteRefName, true)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to get reference %v: %w", remoteRefName, err)
+	}
+
+	return ref.Hash(), nil
+}
+
+func (b *goGit) reset(branch plumbing.ReferenceName, hash plumbing.Hash) error {
+	if err := b.repo.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+		return fmt.Errorf("unable to set reference %v: %w", branch, err)
+	}
+
+	w, err := b.worktree()
+	if err != nil {
+		return err
+	}
+
+	if dirs := b.g.SparseCheckoutDirectories; len(dirs) > 0 {
+		if err := b.resetSparsely(w, branch, hash, dirs); err != nil {
+			return err
+		}
+	} else if err := w.Checkout(&git.CheckoutOptions{
+		Branch: branch,
+		Force:  true,
+	}); err != nil {
+		return fmt.Errorf("unable to checkout branch %q: %w", branch, err)
+	}
+
+	if err := w.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return fmt.Errorf("unable to clean worktree: %w", err)
+	}
+
+	return nil
+}
+
+func (b *goGit) createBranch(branch plumbing.ReferenceName) error {
+	if err := b.repo.Storer.RemoveReference(branch); err != nil {
+		return fmt.Errorf("unable to delete branch %q: %w", branch, err)
+	}
+
+	w, err := b.worktree()
+	if err != nil {
+		return err
+	}
+
+	if err := w.Checkout(&git.CheckoutOptions{
+		Create: true,
+		Keep:   true,
+		Branch: branch,
+	}); err != nil {
+		return fmt.Errorf("unable to checkout branch %q: %w", branch, err)
+	}
+
+	return nil
+}
+
+func (b *goGit) deleteBranch(branch plumbing.ReferenceName) error {
+	return b.repo.Storer.RemoveReference(branch)
+}
+
+func (b *goGit) branches() ([]plumbing.ReferenceName, error) {
+	iter, err := b.repo.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get branches: %w", err)
+	}
+
+	var bs []plumbing.ReferenceName
+	if err := iter.ForEach(func(r *plumbing.Reference) error {
+		bs = append(bs, r.Name())
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("unable to iterate over branches: %w", err)
+	}
+
+	return bs, nil
+}
+
+func (b *goGit) add(path string) error {
+	w, err := b.worktree()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Add(path); err != nil {
+		return fmt.Errorf("unable to run git-add (chroot=%s, name=%s): %w", w.Filesystem.Root(), path, err)
+	}
+
+	return nil
+}
+
+func (b *goGit) remove(path string) error {
+	w, err := b.worktree()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Remove(path); err != nil {
+		return fmt.Errorf("unable to run git-rm: %w", err)
+	}
+
+	return nil
+}
+
+func (b *goGit) commit(message string, author, committer *object.Signature) (plumbing.Hash, error) {
+	w, err := b.worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	opts := &git.CommitOptions{
+		Author:    author,
+		Committer: committer,
+	}
+
+	if k := b.g.SigningKey; k != nil {
+		if k.OpenPGP != nil {
+			opts.SignKey = k.OpenPGP
+		} else {
+			opts.Signer = k.signer()
+		}
+	}
+
+	hash, err := w.Commit(message, opts)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to commit: %w", err)
+	}
+
+	return hash, nil
+}
+
+func (b *goGit) push(ctx context.Context, branch plumbing.ReferenceName, force bool) error {
+	remote, err := b.repo.Remote("origin")
+	if err != nil {
+		return fmt.Errorf("unable to get remote origin: %w", err)
+	}
+
+	if err := remote.PushContext(ctx, &git.PushOptions{
+		Progress: os.Stderr,
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(branch + ":" + branch),
+		},
+		Auth:  b.g.Auth,
+		Force: force,
+	}); err != nil {
+		return fmt.Errorf("unable to push %v to remote origin: %w", branch, err)
+	}
+
+	return nil
+}
+
+func (b *goGit) remoteBranches(ctx context.Context) (map[plumbing.ReferenceName]bool, error) {
+	remote, err := b.repo.Remote("origin")
+	if err != nil {
+		return nil, fmt.Errorf("unable to get remote origin: %w", err)
+	}
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: b.g.Auth})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list remote branches: %w", err)
+	}
+
+	branches := map[plumbing.ReferenceName]bool{}
+	for _, r := range refs {
+		if r.Name().IsBranch() {
+			branches[r.Name()] = true
+		}
+	}
+
+	return branches, nil
+}
+
+// resetSparsely checks out the branch at the commit with only the files in the directories,
+// marking the others as skip-worktree in the index so that they are kept as-is in the next commit.
+// Only the files that differ from the commit are written, and the files outside of the directories are never.
+//
+// We do this on our own because go-git's sparse checkout checks out all the files into an empty worktree,
+// drops the skip-worktree entries from the in-memory index,
+// and fails to remove the files whose directories are not checked out.
+func (b *goGit) resetSparsely(w *git.Worktree, branch plumbing.ReferenceName, hash plumbing.Hash, dirs []string) error {
+	if err := b.repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch)); err != nil {
+		return fmt.Errorf("unable to checkout branch %q: %w", branch, err)
+	}
+
+	// The index is reset without touching the worktree.
+	if err := w.Reset(&git.ResetOptions{Commit: hash, Mode: git.MixedReset}); err != nil {
+		return fmt.Errorf("unable to reset the index to %v: %w", hash, err)
+	}
+
+	idx, err := b.repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("unable to read index: %w", err)
+	}
+
+	for _, e := range idx.Entries {
+		if isUnderDirs(e.Name, dirs) {
+			continue
+		}
+
+		e.SkipWorktree = true
+
+		// The file is left by a previous run without the sparse checkout.
+		if err := w.Filesystem.Remove(e.Name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove %q outside of the sparse checkout directories: %w", e.Name, err)
+		}
+	}
+
+	// The skip-worktree flag is an extended flag, which needs the index version 3.
+	if idx.Version < 3 {
+		idx.Version = 3
+	}
+
+	if err := b.repo.Storer.SetIndex(idx); err != nil {
+		return fmt.Errorf("unable to write index: %w", err)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return fmt.Errorf("unable to run git-status: %w", err)
+	}
+
+	commit, err := b.repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("unable to get commit %v: %w", hash, err)
+	}
+
+	for path, s := range status {
+		// The untracked files are removed by the clean that follows the reset.
+		if s.Worktree != git.Modified && s.Worktree != git.Deleted || !isUnderDirs(path, dirs) {
+			continue
+		}
+
+		f, err := commit.File(path)
+		if err != nil {
+			return fmt.Errorf("unable to get %q in commit %v: %w", path, hash, err)
+		}
+
+		if err := checkoutFile(w.Filesystem, f); err != nil {
+			return fmt.Errorf("unable to checkout %q: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// checkoutFile writes the file in a commit into the worktree.
+func checkoutFile(fs billy.Filesystem, f *object.File) error {
+	if err := fs.Remove(f.Name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if f.Mode == filemode.Symlink {
+		target, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		return fs.Symlink(target, f.Name)
+	}
+
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := fs.OpenFile(f.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// sparsify does nothing, as reset checks out only the directories.
+func (b *goGit) sparsify(dirs []string) error {
+	return nil
+}
+
+// unsparsify clears the skip-worktree flags set by resetSparsely.
+// It needs to be called before resetting the worktree, as go-git drops
+// the index entries marked as skip-worktree on reset.
+// When the run checks out the whole worktree, the reset restores the files outside of the directories.
+func (b *goGit) unsparsify() error {
+	idx, err := b.repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("unable to read index: %w", err)
+	}
+
+	for _, e := range idx.Entries {
+		e.SkipWorktree = false
+	}
+
+	if err := b.repo.Storer.SetIndex(idx); err != nil {
+		return fmt.Errorf("unable to write index: %w", err)
+	}
+
+	return nil
+}
+
+func (b *goGit) repository() (*git.Repository, error) {
+	return b.repo, nil
+}
+
+func (b *goGit) worktree() (*git.Worktree, error) {
+	if b.wt != nil {
+		return b.wt, nil
+	}
+
+	w, err := b.repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get worktree: %w", err)
+	}
+
+	b.wt = w
+
+	return w, nil
+}
+
+func (b *goGit) filesystem() (billy.Filesystem, error) {
+	w, err := b.worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	return w.Filesystem, nil
+}
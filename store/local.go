@@ -1,9 +1,15 @@
 package store
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"context"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
@@ -35,6 +41,30 @@ func newLocal(id string) *Local {
 		fs: fs,
 	}
 }
+
+// NewLocal returns the Local store that writes to the directory, creating it if it does not exist.
+//
+// Unlike the store made by Make, which writes to `.gitimpart/<id>` in the working directory,
+// it is for rendering the files to an arbitrary directory.
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create directory %s: %w", dir, err)
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get absolute path to %s: %w", dir, err)
+	}
+
+	return &Local{
+		fs: osfs.New(abs),
+	}, nil
+}
+
+// Dir returns the directory the store writes to.
+func (f *Local) Dir() string {
+	return f.fs.Root()
+}
 func (f *Local) Transact(fn func(path string) (*RenderResult, error)) (*RenderResult, error) {
 	r, err := fn(f.fs.Root())
 	return r, err
@@ -61,6 +91,10 @@ func (f *Local) Commit(ctx context.Context, subject, body string) error {
 	return nil
 }
 
+func (f *Local) Close() error {
+	return nil
+}
+
 type File struct {
 	Name    string
 	Content string
@@ -92,3 +126,105 @@ func (f *Local) Write(dir string, files ...File) error {
 
 	return nil
 }
+
+// Files returns the paths of all the files in the store, relative to Dir and sorted.
+// The paths are slash-separated regardless of the OS.
+func (f *Local) Files() ([]string, error) {
+	root := f.fs.Root()
+
+	var files []string
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, filepath.ToSlash(rel))
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list files in %s: %w", root, err)
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// WriteTar writes all the files in the store to w as a tar archive.
+//
+// The entries are sorted by path and have no timestamps,
+// so that the same files always result in the same archive.
+func (f *Local) WriteTar(w io.Writer) error {
+	files, err := f.Files()
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+
+	for _, name := range files {
+		b, err := os.ReadFile(filepath.Join(f.fs.Root(), filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", name, err)
+		}
+
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(b)),
+		}); err != nil {
+			return fmt.Errorf("unable to write tar header for %s: %w", name, err)
+		}
+
+		if _, err := tw.Write(b); err != nil {
+			return fmt.Errorf("unable to write %s to tar: %w", name, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+// WriteZip writes all the files in the store to w as a zip archive.
+//
+// Like WriteTar, the entries are sorted by path and have no timestamps.
+func (f *Local) WriteZip(w io.Writer) error {
+	files, err := f.Files()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	for _, name := range files {
+		b, err := os.ReadFile(filepath.Join(f.fs.Root(), filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", name, err)
+		}
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   name,
+			Method: zip.Deflate,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write zip header for %s: %w", name, err)
+		}
+
+		if _, err := fw.Write(b); err != nil {
+			return fmt.Errorf("unable to write %s to zip: %w", name, err)
+		}
+	}
+
+	return zw.Close()
+}
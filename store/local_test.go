@@ -1,6 +1,10 @@
 package store
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -20,3 +24,68 @@ func TestLocal(t *testing.T) {
 	})
 	require.True(t, called)
 }
+
+func TestLocal_Archive(t *testing.T) {
+	l, err := NewLocal(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+
+	_, err = l.Transact(writeFiles(map[string]string{
+		"b.txt":     "b",
+		"a/c.yaml":  "c: C\n",
+		"a/b/d.txt": "d",
+	}))
+	require.NoError(t, err)
+
+	files, err := l.Files()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/b/d.txt", "a/c.yaml", "b.txt"}, files)
+
+	want := map[string]string{
+		"a/b/d.txt": "d",
+		"a/c.yaml":  "c: C\n",
+		"b.txt":     "b",
+	}
+
+	var tarBuf bytes.Buffer
+	require.NoError(t, l.WriteTar(&tarBuf))
+
+	got := map[string]string{}
+	var names []string
+	tr := tar.NewReader(&tarBuf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		got[h.Name] = string(b)
+		names = append(names, h.Name)
+	}
+	require.Equal(t, want, got)
+	require.Equal(t, files, names)
+
+	var tarBuf2 bytes.Buffer
+	require.NoError(t, l.WriteTar(&tarBuf2))
+	var tarBuf3 bytes.Buffer
+	require.NoError(t, l.WriteTar(&tarBuf3))
+	require.Equal(t, tarBuf2.Bytes(), tarBuf3.Bytes(), "tar archives must be reproducible")
+
+	var zipBuf bytes.Buffer
+	require.NoError(t, l.WriteZip(&zipBuf))
+
+	zr, err := zip.NewReader(bytes.NewReader(zipBuf.Bytes()), int64(zipBuf.Len()))
+	require.NoError(t, err)
+
+	got = map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		got[f.Name] = string(b)
+	}
+	require.Equal(t, want, got)
+}
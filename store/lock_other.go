@@ -0,0 +1,40 @@
+//go:build !unix
+
+package store
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockFile acquires an exclusive lock by creating the file at path exclusively.
+// It blocks until the lock is acquired.
+// Unlike the flock-based implementation, the lock is left behind if the process crashes,
+// in which case the file needs to be removed manually.
+func lockFile(path string) (func() error, error) {
+	var waiting bool
+
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return func() error {
+				if err := f.Close(); err != nil {
+					return err
+				}
+				return os.Remove(path)
+			}, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "Waiting for another gitimpart process to release %s\n", path)
+			waiting = true
+		}
+
+		time.Sleep(time.Second)
+	}
+}
@@ -2,8 +2,11 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/google/go-github/v56/github"
 	"github.com/mumoshu/gitimpart/config"
@@ -14,6 +17,15 @@ type PullRequest struct {
 	Git           *Git
 	// DryRun is a flag to print the changes that would be made without actually making them.
 	DryRun bool
+
+	// BodyFunc renders the body of the pull request from the commit,
+	// which contains the changed files and the diff.
+	// If nil, the body of the commit message is used.
+	BodyFunc func(*CommitResult) (string, error)
+
+	// Number and URL are the pull request created or updated by Commit.
+	Number int
+	URL    string
 }
 
 func (c *PullRequest) Transact(fn func(path string) (*RenderResult, error)) (*RenderResult, error) {
@@ -36,6 +48,13 @@ func (c *PullRequest) Delete(ctx context.Context, path string) error {
 	return fmt.Errorf("not implemented")
 }
 
+// Close releases the lock on the local repository acquired by Transact.
+func (c *PullRequest) Close() error {
+	return c.Git.Close()
+}
+
+// Commit commits and pushes the changes to the feature branch, and creates a pull request from it.
+// It returns ErrNoChanges without creating the pull request when there are no changes to propose.
 func (c *PullRequest) Commit(ctx context.Context, subject, body string) error {
 	if err := c.Git.Commit(ctx, subject, body); err != nil {
 		return err
@@ -56,22 +75,99 @@ func (c *PullRequest) createPullRequest(ctx context.Context, subject, body strin
 		repo = repo[:len(repo)-len(".git")]
 	}
 
+	head := c.Git.NewRefName.Short()
+
+	if c.BodyFunc != nil {
+		b, err := c.BodyFunc(c.Git.LastCommit())
+		if err != nil {
+			return fmt.Errorf("unable to render pull request body: %w", err)
+		}
+		body = b
+	}
+
+	// The title and the body may contain the values of the variables and the diff.
 	newPR := &github.NewPullRequest{
-		Title: github.String(subject),
-		Head:  github.String(string(*c.Git.NewRefName)),
-		Base:  github.String(string(c.Git.BaseRefName)),
-		Body:  github.String(body),
+		Title: github.String(c.Git.Redactor.Redact(subject)),
+		Head:  github.String(head),
+		Base:  github.String(c.Git.BaseRefName.Short()),
+		Body:  github.String(truncateBody(c.Git.Redactor.Redact(body))),
 	}
 
 	if c.DryRun {
-		fmt.Printf("Dry-run: Would create a pull request with the following title and body:\n\n%s\n\n%s\n", subject, body)
-		return nil
+		return c.printDryRun(newPR)
+	}
+
+	if c.Git.branchReused {
+		// The pull request may have been created by the previous run that created the branch.
+		prs, _, err := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+			State: "open",
+			Head:  owner + ":" + head,
+			Base:  c.Git.BaseRefName.Short(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(prs) > 0 {
+			// The title and the body are updated to describe the commits pushed by this run.
+			pr, _, err := client.PullRequests.Edit(ctx, owner, repo, prs[0].GetNumber(), &github.PullRequest{
+				Title: newPR.Title,
+				Body:  newPR.Body,
+			})
+			if err != nil {
+				return fmt.Errorf("unable to update pull request #%d: %w", prs[0].GetNumber(), err)
+			}
+
+			c.Number = pr.GetNumber()
+			c.URL = pr.GetHTMLURL()
+			// The message goes to stderr, as stdout may be the result in JSON.
+			fmt.Fprintf(os.Stderr, "Updated the existing pull request %s\n", c.URL)
+			return nil
+		}
 	}
 
-	_, _, err := client.PullRequests.Create(ctx, owner, repo, newPR)
+	pr, _, err := client.PullRequests.Create(ctx, owner, repo, newPR)
 	if err != nil {
 		return err
 	}
 
+	c.Number = pr.GetNumber()
+	c.URL = pr.GetHTMLURL()
+
 	return nil
 }
+
+// printDryRun prints the pull request that would be created, in JSON when the diff is printed in JSON.
+func (c *PullRequest) printDryRun(pr *github.NewPullRequest) error {
+	w := c.Git.dryRunOutput()
+
+	if c.Git.DiffFormat == DiffFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			PullRequest *github.NewPullRequest `json:"pullRequest"`
+		}{pr})
+	}
+
+	_, err := fmt.Fprintf(w, "Dry-run: Would create a pull request from %s to %s with the following title and body:\n\n%s\n\n%s\n", pr.GetHead(), pr.GetBase(), pr.GetTitle(), pr.GetBody())
+
+	return err
+}
+
+// maxPullRequestBodyLength is the maximum number of the characters GitHub accepts in the pull request body.
+const maxPullRequestBodyLength = 65536
+
+// truncatedSuffix is appended to the truncated pull request body.
+const truncatedSuffix = "\n\n... (truncated)\n"
+
+// truncateBody truncates the body to maxPullRequestBodyLength characters,
+// so that a large diff in the body does not make GitHub reject the pull request.
+func truncateBody(body string) string {
+	if utf8.RuneCountInString(body) <= maxPullRequestBodyLength {
+		return body
+	}
+
+	runes := []rune(body)
+
+	return string(runes[:maxPullRequestBodyLength-utf8.RuneCountInString(truncatedSuffix)]) + truncatedSuffix
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mumoshu/gitimpart/envvar"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullRequest_ReuseBranch(t *testing.T) {
+	var edited map[string]interface{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/org/repo/pulls":
+			require.Regexp(t, `^org:gitimpart/main-[0-9a-f]{8}$`, r.URL.Query().Get("head"))
+			json.NewEncoder(w).Encode([]map[string]interface{}{{"number": 7, "html_url": "https://github.com/org/repo/pull/7"}})
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/org/repo/pulls/7":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&edited))
+			json.NewEncoder(w).Encode(map[string]interface{}{"number": 7, "html_url": "https://github.com/org/repo/pull/7"})
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv(envvar.GitHubBaseURL, srv.URL+"/")
+
+	remote := newRemote(t, map[string]string{"README.md": "readme"})
+
+	newGit := func() *Git {
+		g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+		g.BranchTemplate = "gitimpart/{{ .Target }}-{{ .ContentHash }}"
+		g.BranchCollision = BranchCollisionReuse
+		return g
+	}
+
+	g := newGit()
+	_, err := g.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+	require.NoError(t, err)
+	require.NoError(t, g.Commit(context.Background(), "add a.txt", ""))
+
+	// The base branch has moved, so that the branch is pushed again to the existing pull request.
+	pushToRemote(t, remote, map[string]string{"b.txt": "b"})
+
+	pr := &PullRequest{RepositoryURL: "https://github.com/org/repo", Git: newGit()}
+	_, err = pr.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+	require.NoError(t, err)
+	require.NoError(t, pr.Commit(context.Background(), "add a.txt again", "the new body"))
+
+	require.Equal(t, 7, pr.Number)
+	require.Equal(t, "https://github.com/org/repo/pull/7", pr.URL)
+	require.Equal(t, map[string]interface{}{"title": "add a.txt again", "body": "the new body"}, edited)
+}
+
+func TestTruncateBody(t *testing.T) {
+	require.Equal(t, "short", truncateBody("short"))
+
+	body := truncateBody(strings.Repeat("→", maxPullRequestBodyLength+1))
+	require.Equal(t, maxPullRequestBodyLength, utf8.RuneCountInString(body))
+	require.True(t, utf8.ValidString(body))
+	require.True(t, strings.HasSuffix(body, "→\n\n... (truncated)\n"))
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Redacted is what the Redactor replaces the secrets with.
+const Redacted = "<redacted>"
+
+// CredentialPatterns is the credentials of the known formats, like GitHub tokens and AWS access keys.
+var CredentialPatterns = []*regexp.Regexp{
+	// GitHub personal access, OAuth, user-to-server, server-to-server, and refresh tokens.
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,255}\b`),
+	// GitHub fine-grained personal access tokens.
+	regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,255}\b`),
+	// GitLab personal access tokens.
+	regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`),
+	// AWS access key IDs.
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`),
+	// Google API keys.
+	regexp.MustCompile(`\bAIza[A-Za-z0-9_-]{35}\b`),
+	// Slack tokens.
+	regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`),
+	// age secret keys.
+	regexp.MustCompile(`\bAGE-SECRET-KEY-1[0-9A-Z]{58}\b`),
+	// PEM-encoded private keys.
+	regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`),
+}
+
+// minSecretLength is the length of the shortest secret the Redactor masks.
+// A shorter value, like a dummy token in tests or an environment variable set to "true",
+// is likely to appear in the unrelated text, and masking it would mangle the output while hiding little.
+const minSecretLength = 8
+
+// Redactor masks the secrets in the diffs, the dry-run output, and the pull requests.
+// The nil Redactor masks nothing.
+type Redactor struct {
+	// Secrets is the strings masked wherever they appear, like the GitHub token.
+	// The strings shorter than 8 bytes are not masked. See minSecretLength.
+	Secrets []string
+	// Patterns is the regular expressions of the strings masked wherever they appear, like CredentialPatterns.
+	Patterns []*regexp.Regexp
+}
+
+// Redact returns the string with the secrets replaced with Redacted.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	for _, secret := range r.Secrets {
+		if len(secret) >= minSecretLength {
+			s = strings.ReplaceAll(s, secret, Redacted)
+		}
+	}
+
+	for _, p := range r.Patterns {
+		s = p.ReplaceAllString(s, Redacted)
+	}
+
+	return s
+}
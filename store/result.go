@@ -4,3 +4,34 @@ type RenderResult struct {
 	AddedOrModifiedFiles []string
 	DeletedFiles         []string
 }
+
+// FileStatus is how a file is changed by a commit.
+type FileStatus string
+
+const (
+	FileAdded    FileStatus = "added"
+	FileModified FileStatus = "modified"
+	FileDeleted  FileStatus = "deleted"
+)
+
+// FileChange is a file changed by a commit.
+type FileChange struct {
+	Path   string     `json:"path"`
+	Status FileStatus `json:"status"`
+}
+
+// CommitResult describes the commit made by Git.Commit.
+type CommitResult struct {
+	// Hash is the hash of the commit.
+	Hash string
+	// Ref is the ref the commit is pushed to, like "refs/heads/main".
+	Ref string
+	// Base is the hash of the commit on the base branch the commit is made on top of.
+	Base string
+	// Pushed is false when the commit is made in dry-run mode and not pushed.
+	Pushed bool
+	// Files is the files changed by the commit, sorted by path.
+	Files []FileChange
+	// Diff is the changes made by the commit.
+	Diff *Diff
+}
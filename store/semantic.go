@@ -0,0 +1,479 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"gopkg.in/yaml.v2"
+)
+
+// SemanticChange is a change to a value in a YAML or JSON file,
+// identified by the path of keys from the root of the document.
+type SemanticChange struct {
+	// Object identifies the document in the file, like "Deployment/default/app" for a Kubernetes object,
+	// or "#1" for the second document that is not a Kubernetes object.
+	// It is empty when the file contains only one document that is not a Kubernetes object.
+	Object string `json:"object,omitempty"`
+	// Path is the path to the value, like "spec.replicas" or `spec.template.spec.containers[name=app].image`.
+	// It is empty when the whole object is added or removed.
+	Path string `json:"path,omitempty"`
+	// Op is either "added", "removed", or "changed".
+	Op string `json:"op"`
+	// Old and New are the values before and after the change.
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+const (
+	semanticAdded   = "added"
+	semanticRemoved = "removed"
+	semanticChanged = "changed"
+)
+
+// semanticDiff compares the old and new contents of the YAML or JSON file.
+// It returns false when the file is neither YAML nor JSON, or either side does not parse.
+func semanticDiff(path string, chunks []fdiff.Chunk) ([]SemanticChange, bool) {
+	var parse func([]byte) ([]interface{}, error)
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		parse = parseYAMLDocs
+	case ".json":
+		parse = parseJSONDocs
+	default:
+		return nil, false
+	}
+
+	var old, new bytes.Buffer
+	for _, c := range chunks {
+		if c.Type() != fdiff.Add {
+			old.WriteString(c.Content())
+		}
+		if c.Type() != fdiff.Delete {
+			new.WriteString(c.Content())
+		}
+	}
+
+	oldDocs, err := parse(old.Bytes())
+	if err != nil {
+		return nil, false
+	}
+
+	newDocs, err := parse(new.Bytes())
+	if err != nil {
+		return nil, false
+	}
+
+	return diffDocs(oldDocs, newDocs), true
+}
+
+func parseYAMLDocs(b []byte) ([]interface{}, error) {
+	var docs []interface{}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Skip the empty documents like the one after a trailing "---".
+		if doc != nil {
+			docs = append(docs, stringKeys(doc))
+		}
+	}
+
+	return docs, nil
+}
+
+// stringKeys converts the maps decoded by yaml.v2 into the ones keyed by strings, like the JSON ones.
+func stringKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, item := range t {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, item := range t {
+			s[i] = stringKeys(item)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func parseJSONDocs(b []byte) ([]interface{}, error) {
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	return []interface{}{doc}, nil
+}
+
+// diffDocs matches the documents by their Kubernetes identities, or by their positions otherwise,
+// and compares the matched documents.
+func diffDocs(oldDocs, newDocs []interface{}) []SemanticChange {
+	single := len(oldDocs) <= 1 && len(newDocs) <= 1
+
+	ids := func(docs []interface{}) ([]string, map[string]interface{}) {
+		keys := make([]string, 0, len(docs))
+		byKey := make(map[string]interface{}, len(docs))
+		for i, doc := range docs {
+			key := objectID(doc)
+			if key == "" || byKey[key] != nil {
+				if single {
+					key = ""
+				} else {
+					key = fmt.Sprintf("#%d", i)
+				}
+			}
+			keys = append(keys, key)
+			byKey[key] = doc
+		}
+		return keys, byKey
+	}
+
+	oldKeys, oldByKey := ids(oldDocs)
+	newKeys, newByKey := ids(newDocs)
+
+	var changes []SemanticChange
+
+	for _, key := range oldKeys {
+		if _, ok := newByKey[key]; !ok {
+			changes = append(changes, SemanticChange{Object: key, Op: semanticRemoved, Old: oldByKey[key]})
+		}
+	}
+
+	for _, key := range newKeys {
+		old, ok := oldByKey[key]
+		if !ok {
+			changes = append(changes, SemanticChange{Object: key, Op: semanticAdded, New: newByKey[key]})
+			continue
+		}
+
+		for _, c := range diffValues("", old, newByKey[key]) {
+			c.Object = key
+			changes = append(changes, c)
+		}
+	}
+
+	return changes
+}
+
+// objectID returns "Kind/namespace/name", or "Kind/name" for cluster-scoped objects,
+// when the document is a Kubernetes object.
+func objectID(doc interface{}) string {
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	kind, _ := m["kind"].(string)
+	meta, _ := m["metadata"].(map[string]interface{})
+	name, _ := meta["name"].(string)
+	if kind == "" || name == "" {
+		return ""
+	}
+
+	if ns, _ := meta["namespace"].(string); ns != "" {
+		return kind + "/" + ns + "/" + name
+	}
+
+	return kind + "/" + name
+}
+
+func diffValues(path string, old, new interface{}) []SemanticChange {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if n, ok := new.(map[string]interface{}); ok {
+			return diffMaps(path, o, n)
+		}
+	case []interface{}:
+		if n, ok := new.([]interface{}); ok {
+			return diffLists(path, o, n)
+		}
+	}
+
+	if equalValues(old, new) {
+		return nil
+	}
+
+	return []SemanticChange{{Path: path, Op: semanticChanged, Old: old, New: new}}
+}
+
+func diffMaps(path string, old, new map[string]interface{}) []SemanticChange {
+	keys := map[string]bool{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []SemanticChange
+
+	for _, k := range sorted {
+		p := joinKey(path, k)
+		o, inOld := old[k]
+		n, inNew := new[k]
+
+		switch {
+		case !inOld:
+			changes = append(changes, SemanticChange{Path: p, Op: semanticAdded, New: n})
+		case !inNew:
+			changes = append(changes, SemanticChange{Path: p, Op: semanticRemoved, Old: o})
+		default:
+			changes = append(changes, diffValues(p, o, n)...)
+		}
+	}
+
+	return changes
+}
+
+// diffLists compares the lists by the "name" of the items when all the items have unique names,
+// like the containers and the env vars of Kubernetes objects, or by the positions otherwise.
+func diffLists(path string, old, new []interface{}) []SemanticChange {
+	oldNames, oldOK := itemNames(old)
+	newNames, newOK := itemNames(new)
+
+	var changes []SemanticChange
+
+	if oldOK && newOK {
+		newIndex := make(map[string]int, len(newNames))
+		for i, n := range newNames {
+			newIndex[n] = i
+		}
+
+		oldIndex := make(map[string]int, len(oldNames))
+		for i, n := range oldNames {
+			oldIndex[n] = i
+
+			if _, ok := newIndex[n]; !ok {
+				changes = append(changes, SemanticChange{Path: path + "[name=" + n + "]", Op: semanticRemoved, Old: old[i]})
+			}
+		}
+
+		for i, n := range newNames {
+			p := path + "[name=" + n + "]"
+			if j, ok := oldIndex[n]; ok {
+				changes = append(changes, diffValues(p, old[j], new[i])...)
+			} else {
+				changes = append(changes, SemanticChange{Path: p, Op: semanticAdded, New: new[i]})
+			}
+		}
+
+		return changes
+	}
+
+	for i := 0; i < len(old) || i < len(new); i++ {
+		p := fmt.Sprintf("%s[%d]", path, i)
+
+		switch {
+		case i >= len(new):
+			changes = append(changes, SemanticChange{Path: p, Op: semanticRemoved, Old: old[i]})
+		case i >= len(old):
+			changes = append(changes, SemanticChange{Path: p, Op: semanticAdded, New: new[i]})
+		default:
+			changes = append(changes, diffValues(p, old[i], new[i])...)
+		}
+	}
+
+	return changes
+}
+
+func itemNames(items []interface{}) ([]string, bool) {
+	if len(items) == 0 {
+		return nil, true
+	}
+
+	names := make([]string, 0, len(items))
+	seen := map[string]bool{}
+
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		name, ok := m["name"].(string)
+		if !ok || name == "" || seen[name] {
+			return nil, false
+		}
+
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names, true
+}
+
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// joinKey appends the key to the path, quoting the key when it contains dots or other special characters,
+// like `metadata.annotations["example.com/owner"]`.
+func joinKey(path, key string) string {
+	if !plainKey.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func equalValues(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		// YAML maps with non-string keys are not marshaled into JSON.
+		return reflect.DeepEqual(a, b)
+	}
+
+	return bytes.Equal(ja, jb)
+}
+
+// formatValue formats the value in the compact JSON form, which is also valid YAML.
+// The HTML characters are kept as they are, so that the masked values like `<redacted:0123abcd>` are readable.
+func formatValue(v interface{}) string {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	b := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	const max = 80
+	if len(b) > max {
+		return string(b[:max-3]) + "..."
+	}
+
+	return string(b)
+}
+
+// String formats the change like `  spec.replicas: 2 → 3`, `+ metadata.labels.team: "platform"`,
+// or `- Deployment/default/app` when the whole object is removed.
+// The first column is the marker as in unified diffs.
+func (c SemanticChange) String() string {
+	return c.marker() + " " + c.text()
+}
+
+func (c SemanticChange) marker() string {
+	switch c.Op {
+	case semanticAdded:
+		return "+"
+	case semanticRemoved:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+func (c SemanticChange) text() string {
+	if c.Path == "" && c.Op != semanticChanged {
+		if c.Object == "" {
+			return "(document)"
+		}
+		return c.Object
+	}
+
+	path := c.Path
+	if path == "" {
+		path = "(document)"
+	}
+
+	switch c.Op {
+	case semanticAdded:
+		return fmt.Sprintf("%s: %s", path, formatValue(c.New))
+	case semanticRemoved:
+		return fmt.Sprintf("%s: %s", path, formatValue(c.Old))
+	default:
+		return fmt.Sprintf("%s: %s → %s", path, formatValue(c.Old), formatValue(c.New))
+	}
+}
+
+// writeSemanticChanges writes the changes grouped by the objects.
+// The indent is put after the marker so that the marker stays in the first column.
+func writeSemanticChanges(w io.Writer, indent string, changes []SemanticChange) {
+	var object string
+
+	for _, c := range changes {
+		if c.Path == "" || c.Object == "" {
+			fmt.Fprintf(w, "%s %s%s\n", c.marker(), indent, c.text())
+			object = ""
+			continue
+		}
+
+		if c.Object != object {
+			fmt.Fprintf(w, "  %s%s:\n", indent, c.Object)
+			object = c.Object
+		}
+
+		fmt.Fprintf(w, "%s %s  %s\n", c.marker(), indent, c.text())
+	}
+}
+
+// SemanticText returns the semantic changes of the YAML and JSON files, grouped by the files and the objects.
+// It returns an empty string when no file has semantic changes.
+func (d *Diff) SemanticText() string {
+	var b strings.Builder
+
+	for _, f := range d.Files {
+		if len(f.Semantic) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "%s:\n", f.Path)
+		writeSemanticChanges(&b, "  ", f.Semantic)
+	}
+
+	return b.String()
+}
+
+// SemanticMarkdown returns the semantic changes as a Markdown section for pull request bodies.
+// It returns an empty string when no file has semantic changes.
+func (d *Diff) SemanticMarkdown() string {
+	var b strings.Builder
+
+	for _, f := range d.Files {
+		if len(f.Semantic) == 0 {
+			continue
+		}
+
+		if b.Len() == 0 {
+			b.WriteString("### Semantic changes\n")
+		}
+
+		fmt.Fprintf(&b, "\n`%s`\n\n```diff\n", f.Path)
+		writeSemanticChanges(&b, "", f.Semantic)
+		b.WriteString("```\n")
+	}
+
+	return b.String()
+}
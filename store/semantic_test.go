@@ -0,0 +1,121 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemanticDiff(t *testing.T) {
+	old := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: default
+  labels:
+    app: app
+  annotations:
+    example.com/other: x
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:1.0
+      - name: sidecar
+        image: sidecar:1.0
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+  namespace: default
+spec:
+  ports:
+  - port: 80
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: old
+`
+
+	// Reordered and reformatted, which makes the line diff noisy.
+	new := `kind: Service
+apiVersion: v1
+metadata: {name: app, namespace: default}
+spec:
+  ports:
+  - port: 80
+---
+kind: Deployment
+apiVersion: apps/v1
+metadata:
+  name: app
+  namespace: default
+  labels:
+    app: app
+    team: platform
+  annotations:
+    example.com/other: x
+    example.com/owner: me
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: sidecar
+        image: sidecar:1.0
+      - name: app
+        image: app:1.1
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: new
+`
+
+	oldDocs, err := parseYAMLDocs([]byte(old))
+	require.NoError(t, err)
+	newDocs, err := parseYAMLDocs([]byte(new))
+	require.NoError(t, err)
+
+	d := &Diff{Files: []FileDiff{{
+		Path:     "app.yaml",
+		Status:   FileModified,
+		Semantic: diffDocs(oldDocs, newDocs),
+	}}}
+
+	require.Equal(t, `app.yaml:
+-   ConfigMap/old
+    Deployment/default/app:
++     metadata.annotations["example.com/owner"]: "me"
++     metadata.labels.team: "platform"
+      spec.replicas: 2 → 3
+      spec.template.spec.containers[name=app].image: "app:1.0" → "app:1.1"
++   Namespace/new
+`, d.SemanticText())
+
+	require.Equal(t, "### Semantic changes\n\n`app.yaml`\n\n```diff\n"+`- ConfigMap/old
+  Deployment/default/app:
++   metadata.annotations["example.com/owner"]: "me"
++   metadata.labels.team: "platform"
+    spec.replicas: 2 → 3
+    spec.template.spec.containers[name=app].image: "app:1.0" → "app:1.1"
++ Namespace/new
+`+"```\n", d.SemanticMarkdown())
+}
+
+func TestSemanticDiff_JSON(t *testing.T) {
+	oldDocs, err := parseJSONDocs([]byte(`{"a": {"b": [1, 2]}, "c": "x"}`))
+	require.NoError(t, err)
+	newDocs, err := parseJSONDocs([]byte(`{"a": {"b": [1, 3, 4]}}`))
+	require.NoError(t, err)
+
+	require.Equal(t, []SemanticChange{
+		{Path: "a.b[1]", Op: semanticChanged, Old: float64(2), New: float64(3)},
+		{Path: "a.b[2]", Op: semanticAdded, New: float64(4)},
+		{Path: "c", Op: semanticRemoved, Old: "x"},
+	}, diffDocs(oldDocs, newDocs))
+}
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGit_Sign(t *testing.T) {
+	forEachBackend(t, testGitSign)
+}
+
+func testGitSign(t *testing.T, backend string) {
+	t.Run("openpgp", func(t *testing.T) {
+		e, err := openpgp.NewEntity("gitimpart", "", "gitimpart@example.com", nil)
+		require.NoError(t, err)
+
+		var priv bytes.Buffer
+		w, err := armor.Encode(&priv, openpgp.PrivateKeyType, nil)
+		require.NoError(t, err)
+		require.NoError(t, e.SerializePrivate(w, nil))
+		require.NoError(t, w.Close())
+
+		var pub bytes.Buffer
+		w, err = armor.Encode(&pub, openpgp.PublicKeyType, nil)
+		require.NoError(t, err)
+		require.NoError(t, e.Serialize(w))
+		require.NoError(t, w.Close())
+
+		key, err := ParseSigningKey(priv.Bytes(), "")
+		require.NoError(t, err)
+		require.NotNil(t, key.OpenPGP)
+
+		c := commitSigned(t, backend, key)
+
+		_, err = c.Verify(pub.String())
+		require.NoError(t, err)
+	})
+
+	t.Run("ssh", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		block, err := ssh.MarshalPrivateKey(priv, "")
+		require.NoError(t, err)
+
+		key, err := ParseSigningKey(pem.EncodeToMemory(block), "")
+		require.NoError(t, err)
+		require.NotNil(t, key.SSH)
+
+		c := commitSigned(t, backend, key)
+		require.Contains(t, c.PGPSignature, "-----BEGIN SSH SIGNATURE-----")
+
+		if _, err := exec.LookPath("ssh-keygen"); err != nil {
+			t.Skip("ssh-keygen is not installed")
+		}
+
+		sshPub, err := ssh.NewPublicKey(pub)
+		require.NoError(t, err)
+
+		// Verify the signature the same way git verify-commit does.
+		dir := t.TempDir()
+		allowedSigners := filepath.Join(dir, "allowed_signers")
+		require.NoError(t, os.WriteFile(allowedSigners, append([]byte("test@example.com "), ssh.MarshalAuthorizedKey(sshPub)...), 0644))
+
+		sig := filepath.Join(dir, "sig")
+		require.NoError(t, os.WriteFile(sig, []byte(c.PGPSignature), 0644))
+
+		obj := &plumbing.MemoryObject{}
+		require.NoError(t, c.EncodeWithoutSignature(obj))
+		r, err := obj.Reader()
+		require.NoError(t, err)
+
+		cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", allowedSigners, "-I", "test@example.com", "-n", "git", "-s", sig)
+		cmd.Stdin = r
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	})
+}
+
+// commitSigned commits a file to a new remote with the key, and returns the commit pushed to the remote.
+func commitSigned(t *testing.T, backend string, key *SigningKey) *object.Commit {
+	t.Helper()
+
+	remote := newRemote(t, map[string]string{"README.md": "readme"})
+
+	g := NewGit(nil, "main", "", remote, "test author", "test@example.com", t.TempDir(), true)
+	g.Backend = backend
+	g.SigningKey = key
+
+	_, err := g.Transact(writeFiles(map[string]string{"a.txt": "a"}))
+	require.NoError(t, err)
+	require.NoError(t, g.Commit(context.Background(), "add a.txt", ""))
+
+	r, err := git.PlainOpen(remote)
+	require.NoError(t, err)
+
+	head, err := r.Head()
+	require.NoError(t, err)
+
+	c, err := r.CommitObject(head.Hash())
+	require.NoError(t, err)
+	require.NotEmpty(t, c.PGPSignature)
+	require.True(t, strings.HasPrefix(c.Message, "add a.txt"), "unexpected message: %s", c.Message)
+
+	return c
+}
@@ -7,7 +7,6 @@ package store
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"time"
 
@@ -44,6 +43,10 @@ type Store interface {
 	// The subject and body are used as the commit message, if applicable.
 	// If the store does not support commits, it returns nil.
 	Commit(context context.Context, subject, body string) error
+
+	// Close releases the resources acquired by Transact, like the lock on the local clone of the repository.
+	// The caller is expected to call Close when it is done with the store, even if Transact or Commit failed.
+	Close() error
 }
 
 // Make makes a store based on the config.Delegate.
@@ -76,12 +79,6 @@ func newGit(id string, t time.Time, d *config.Delegate) *Git {
 		Password: os.Getenv(envvar.GitHubToken),
 	}
 
-	var newBranch string
-
-	if d.PullRequest != nil {
-		newBranch = fmt.Sprintf(appName+"/%s-%s", id, t.Format("20060102150405"))
-	}
-
 	gitRoot := os.Getenv(envvar.GitRoot)
 	if gitRoot == "" {
 		gitRoot = "." + appName + "/repositories"
@@ -92,13 +89,36 @@ func newGit(id string, t time.Time, d *config.Delegate) *Git {
 	g := NewGit(
 		auth,
 		baseBranch,
-		newBranch,
+		"",
 		repoURL,
 		os.Getenv(envvar.GitCommitAuthorUserName),
 		os.Getenv(envvar.GitCommitAuthorEmail),
 		gitRoot,
 		d.Git.Push,
 	)
+	g.Backend = os.Getenv(envvar.GitBackend)
+
+	if d.PullRequest != nil {
+		g.BranchTemplate = d.PullRequest.Branch
+		if g.BranchTemplate == "" {
+			g.BranchTemplate = DefaultBranchTemplate
+		}
+		g.BranchID = id
+		g.BranchCollision = d.PullRequest.OnBranchCollision
+		g.branchTime = t
+	}
+	g.CommitterName = os.Getenv(envvar.GitCommitCommitterUserName)
+	g.CommitterEmail = os.Getenv(envvar.GitCommitCommitterEmail)
+	g.SigningKey, g.err = signingKeyFromEnv()
+
+	if d.Git.Depth > 0 {
+		g.Depth = d.Git.Depth
+		g.SingleBranch = true
+	}
+
+	if d.Git.Sparse && d.Git.Path != "" {
+		g.SparseCheckoutDirectories = []string{d.Git.Path}
+	}
 
 	return g
 }
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// TextConv converts the content of the file into the text the diffs show instead of the content,
+// like git's diff.<driver>.textconv, so that the changes to encrypted files can be reviewed.
+// It returns nil to show the content as it is.
+type TextConv func(path string, content []byte) ([]byte, error)
+
+// textConv returns the patch with the contents of the files converted by the TextConv,
+// and the secrets in them masked by the Redactor.
+// The files neither of them changes are kept as they are.
+func textConv(repo *git.Repository, patch *object.Patch, conv TextConv, r *Redactor) (fdiff.Patch, error) {
+	if conv == nil && r == nil {
+		return patch, nil
+	}
+
+	read := func(f fdiff.File) (string, bool, error) {
+		if f == nil {
+			return "", false, nil
+		}
+
+		blob, err := repo.BlobObject(f.Hash())
+		if err != nil {
+			return "", false, fmt.Errorf("unable to get blob of %s: %w", f.Path(), err)
+		}
+
+		rd, err := blob.Reader()
+		if err != nil {
+			return "", false, fmt.Errorf("unable to read blob of %s: %w", f.Path(), err)
+		}
+		defer rd.Close()
+
+		b, err := io.ReadAll(rd)
+		if err != nil {
+			return "", false, fmt.Errorf("unable to read blob of %s: %w", f.Path(), err)
+		}
+
+		text := string(b)
+
+		if conv != nil {
+			converted, err := conv(f.Path(), b)
+			if err != nil {
+				return "", false, fmt.Errorf("unable to convert %s for the diff: %w", f.Path(), err)
+			}
+			if converted != nil {
+				text = string(converted)
+			}
+		}
+
+		text = r.Redact(text)
+
+		return text, text != string(b), nil
+	}
+
+	var files filePatches
+
+	for _, fp := range patch.FilePatches() {
+		if fp.IsBinary() {
+			files = append(files, fp)
+			continue
+		}
+
+		from, to := fp.Files()
+
+		oldText, oldConverted, err := read(from)
+		if err != nil {
+			return nil, err
+		}
+
+		newText, newConverted, err := read(to)
+		if err != nil {
+			return nil, err
+		}
+
+		if !oldConverted && !newConverted {
+			files = append(files, fp)
+			continue
+		}
+
+		c := &convertedFilePatch{from: from, to: to}
+		for _, d := range diff.Do(oldText, newText) {
+			op := fdiff.Equal
+			switch d.Type {
+			case diffmatchpatch.DiffInsert:
+				op = fdiff.Add
+			case diffmatchpatch.DiffDelete:
+				op = fdiff.Delete
+			}
+			c.chunks = append(c.chunks, textChunk{content: d.Text, op: op})
+		}
+
+		files = append(files, c)
+	}
+
+	return files, nil
+}
+
+type convertedFilePatch struct {
+	from, to fdiff.File
+	chunks   []fdiff.Chunk
+}
+
+func (p *convertedFilePatch) IsBinary() bool {
+	return false
+}
+
+func (p *convertedFilePatch) Files() (fdiff.File, fdiff.File) {
+	return p.from, p.to
+}
+
+func (p *convertedFilePatch) Chunks() []fdiff.Chunk {
+	return p.chunks
+}
+
+type textChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c textChunk) Content() string {
+	return c.content
+}
+
+func (c textChunk) Type() fdiff.Operation {
+	return c.op
+}
+
+// patchStats is object.Patch.Stats for any patch.
+func patchStats(patch fdiff.Patch) object.FileStats {
+	var stats object.FileStats
+
+	for _, fp := range patch.FilePatches() {
+		// Binary files have no chunks, and are left out as git does.
+		if len(fp.Chunks()) == 0 {
+			continue
+		}
+
+		var s object.FileStat
+
+		from, to := fp.Files()
+		switch {
+		case from == nil:
+			s.Name = to.Path()
+		case to == nil:
+			s.Name = from.Path()
+		case from.Path() != to.Path():
+			s.Name = fmt.Sprintf("%s => %s", from.Path(), to.Path())
+		default:
+			s.Name = from.Path()
+		}
+
+		for _, c := range fp.Chunks() {
+			text := c.Content()
+			if text == "" {
+				continue
+			}
+
+			n := strings.Count(text, "\n")
+			if !strings.HasSuffix(text, "\n") {
+				n++
+			}
+
+			switch c.Type() {
+			case fdiff.Add:
+				s.Addition += n
+			case fdiff.Delete:
+				s.Deletion += n
+			}
+		}
+
+		stats = append(stats, s)
+	}
+
+	return stats
+}
@@ -0,0 +1,212 @@
+package gitimpart
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	yamlv2 "gopkg.in/yaml.v2"
+)
+
+// TemplateData is the data available to the Go templates rendered by RenderFile.
+type TemplateData struct {
+	// Vars is the variables given via Vars, like `{{ .Vars.env }}`.
+	Vars map[string]string
+	// TLAs is the top-level arguments given via TLAs.
+	TLAs map[string]string
+}
+
+// executeTemplate renders the Go template with the sprig-like functions listed in templateFuncs.
+// A missing variable is an empty string, as in Helm, so that `default` works. Use `var` or `required` to require it.
+func executeTemplate(path, text string, cfg LoadConfig) (string, error) {
+	t, err := template.New(path).Funcs(templateFuncs(cfg)).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse template %s: %w", path, err)
+	}
+
+	data := TemplateData{
+		Vars: mergeVars(nil, cfg.Vars),
+		TLAs: mergeVars(nil, cfg.TLAs),
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("unable to render template %s: %w", path, err)
+	}
+
+	return b.String(), nil
+}
+
+// templateFuncs returns the functions available to the Go templates,
+// which are the commonly used subset of the sprig functions with the same names and argument orders,
+// plus `var`, which returns the variable or fails when it is not given, like std.extVar,
+// and `semverCmp`, which returns -1, 0, or 1 like the semverCompare native function.
+// sprig's semverCompare, which checks the version against a constraint, is not provided.
+func templateFuncs(cfg LoadConfig) template.FuncMap {
+	return template.FuncMap{
+		"var": func(name string) (string, error) {
+			v, ok := cfg.Vars[name]
+			if !ok {
+				return "", fmt.Errorf("undefined variable %q", name)
+			}
+			return v, nil
+		},
+
+		"default": func(d interface{}, v ...interface{}) interface{} {
+			if len(v) == 0 || empty(v[0]) {
+				return d
+			}
+			return v[0]
+		},
+		"required": func(msg string, v interface{}) (interface{}, error) {
+			if empty(v) {
+				return nil, fmt.Errorf("%s", msg)
+			}
+			return v, nil
+		},
+		"empty": empty,
+		"coalesce": func(v ...interface{}) interface{} {
+			for _, x := range v {
+				if !empty(x) {
+					return x
+				}
+			}
+			return nil
+		},
+		"ternary": func(a, b interface{}, cond bool) interface{} {
+			if cond {
+				return a
+			}
+			return b
+		},
+
+		"quote":      func(s interface{}) string { return fmt.Sprintf("%q", fmt.Sprint(s)) },
+		"squote":     func(s interface{}) string { return "'" + fmt.Sprint(s) + "'" },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"repeat":     func(n int, s string) string { return strings.Repeat(s, n) },
+		"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+		"join": func(sep string, v interface{}) string {
+			var ss []string
+			for _, x := range toList(v) {
+				ss = append(ss, fmt.Sprint(x))
+			}
+			return strings.Join(ss, sep)
+		},
+		"indent": indent,
+		"nindent": func(n int, s string) string {
+			return "\n" + indent(n, s)
+		},
+
+		"list": func(v ...interface{}) []interface{} { return v },
+		"dict": func(kv ...interface{}) (map[string]interface{}, error) {
+			if len(kv)%2 != 0 {
+				return nil, fmt.Errorf("dict: odd number of arguments")
+			}
+			d := make(map[string]interface{}, len(kv)/2)
+			for i := 0; i < len(kv); i += 2 {
+				d[fmt.Sprint(kv[i])] = kv[i+1]
+			}
+			return d, nil
+		},
+
+		"toYaml": func(v interface{}) (string, error) {
+			b, err := yamlv2.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(string(b), "\n"), nil
+		},
+		"fromYaml": parseYAML,
+		"toJson": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"fromJson": func(s string) (interface{}, error) {
+			var v interface{}
+			err := json.Unmarshal([]byte(s), &v)
+			return v, err
+		},
+
+		"b64enc": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec": func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		},
+		"sha256sum": func(s string) string {
+			sum := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(sum[:])
+		},
+
+		"regexMatch": func(pattern, s string) (bool, error) {
+			return regexp.MatchString(pattern, s)
+		},
+		"regexReplaceAll": func(pattern, s, repl string) (string, error) {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return "", err
+			}
+			return re.ReplaceAllString(s, repl), nil
+		},
+
+		"semverCmp": func(a, b string) (int, error) {
+			va, err := parseSemver(a)
+			if err != nil {
+				return 0, err
+			}
+			vb, err := parseSemver(b)
+			if err != nil {
+				return 0, err
+			}
+			return va.compare(vb), nil
+		},
+	}
+}
+
+// empty reports whether the value is the zero value of its type, or an empty string, slice, or map.
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	default:
+		return rv.IsZero()
+	}
+}
+
+func toList(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []interface{}{v}
+	}
+
+	l := make([]interface{}, rv.Len())
+	for i := range l {
+		l[i] = rv.Index(i).Interface()
+	}
+
+	return l
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
@@ -0,0 +1,7 @@
+env: *"dev" | string @tag(env)
+
+"$files": {
+	"\(env)/config.json": {
+		replicas: 2
+	}
+}
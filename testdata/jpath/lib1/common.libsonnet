@@ -0,0 +1 @@
+{ name: "lib1" }
@@ -0,0 +1 @@
+{ name: "lib2" }
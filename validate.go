@@ -0,0 +1,129 @@
+package gitimpart
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Validate checks the contents without writing any file or accessing the network.
+//
+// It reports every file whose path is not a clean relative path within the repository,
+// whose content is null, or whose content is neither a string nor
+// an object or array written to a .json, .yaml, or .yml file.
+// It also checks that the templates in the `$commit` section parse,
+// and that the files in the `$encrypt` section are YAML or JSON files in `$files`.
+func (c Contents) Validate() error {
+	var errs []error
+
+	names := make([]string, 0, len(c.Files))
+	for name := range c.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := validatePath(name); err != nil {
+			errs = append(errs, fmt.Errorf("$files[%q]: %w", name, err))
+			continue
+		}
+
+		content := c.Files[name]
+		if content == nil {
+			// RenderFile moves the contents of the files in $kustomize to $files,
+			// so a null here is always written by the user.
+			errs = append(errs, fmt.Errorf("$files[%q]: content is null", name))
+			continue
+		}
+
+		if _, err := marshalFile(name, content); err != nil {
+			errs = append(errs, fmt.Errorf("$files[%q]: %w", name, err))
+		}
+	}
+
+	dirs := make([]string, 0, len(c.Kustomize))
+	for dir := range c.Kustomize {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		if dir == "" || dir == "." {
+			continue
+		}
+
+		if err := validatePath(dir); err != nil {
+			errs = append(errs, fmt.Errorf("$kustomize[%q]: %w", dir, err))
+		}
+	}
+
+	errs = append(errs, c.validateEncrypt()...)
+
+	if c.Commit != nil {
+		for name, text := range map[string]string{"subject": c.Commit.Subject, "body": c.Commit.Body} {
+			if _, err := template.New(name).Parse(text); err != nil {
+				errs = append(errs, fmt.Errorf("$commit.%s: %w", name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validatePaths checks that the files and the kustomize directories stay within the repository,
+// so that neither Push nor RenderLocal writes or reads outside of the directory, whether or not Validate is called.
+func (c Contents) validatePaths() error {
+	var errs []error
+
+	names := make([]string, 0, len(c.Files))
+	for name := range c.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := validatePath(name); err != nil {
+			errs = append(errs, fmt.Errorf("$files[%q]: %w", name, err))
+		}
+	}
+
+	dirs := make([]string, 0, len(c.Kustomize))
+	for dir := range c.Kustomize {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		if dir == "" || dir == "." {
+			continue
+		}
+
+		if err := validatePath(dir); err != nil {
+			errs = append(errs, fmt.Errorf("$kustomize[%q]: %w", dir, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validatePath(p string) error {
+	switch {
+	case p == "":
+		return errors.New("path is empty")
+	case strings.Contains(p, `\`):
+		return errors.New("path must use forward slashes")
+	case path.IsAbs(p):
+		return errors.New("path must be relative to the repository root")
+	case path.Clean(p) != p:
+		return fmt.Errorf("path must be clean, like %q", path.Clean(p))
+	case p == ".." || strings.HasPrefix(p, "../"):
+		return errors.New("path must not point outside of the repository")
+	case p == ".git" || strings.HasPrefix(p, ".git/"):
+		return errors.New("path must not point into the .git directory")
+	}
+
+	return nil
+}